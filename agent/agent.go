@@ -0,0 +1,185 @@
+// Package agent — режим `vpnbot agent` для удалённых нод.
+// Агент принимает от панели подписанные конфиги sing-box/telemt, проверяет и применяет их,
+// а также отдаёт статистику V2Ray API и состояние сервисов.
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxConfigSize — ограничение на размер присылаемого конфига
+const maxConfigSize = 8 << 20
+
+// Run запускает HTTP(S)-сервер агента. Настройки берутся из окружения:
+// AGENT_SECRET — общий с панелью HMAC-ключ (обязателен),
+// AGENT_LISTEN — адрес (по умолчанию :9090),
+// AGENT_TLS_CERT/AGENT_TLS_KEY — сертификат сервера,
+// AGENT_CLIENT_CA — CA клиентских сертификатов; если задан, включается mTLS,
+// AGENT_INSECURE_HTTP=1 — разрешить запуск без TLS (только для отладки: конфиги уйдут открытым текстом).
+func Run() {
+	secret := os.Getenv("AGENT_SECRET")
+	if secret == "" {
+		log.Fatal("AGENT_SECRET is required in agent mode")
+	}
+
+	listen := os.Getenv("AGENT_LISTEN")
+	if listen == "" {
+		listen = ":9090"
+	}
+
+	r := gin.Default()
+	r.Use(verifySignature(secret))
+
+	r.POST("/v1/singbox", applySingbox)
+	r.POST("/v1/telemt", applyTelemet)
+	r.GET("/v1/stats", getStats)
+	r.GET("/v1/health", getHealth)
+
+	srv := &http.Server{Addr: listen, Handler: r}
+
+	certFile, keyFile := os.Getenv("AGENT_TLS_CERT"), os.Getenv("AGENT_TLS_KEY")
+	if certFile == "" || keyFile == "" {
+		if os.Getenv("AGENT_INSECURE_HTTP") != "1" {
+			log.Fatal("AGENT_TLS_CERT and AGENT_TLS_KEY are required; set AGENT_INSECURE_HTTP=1 to serve plain HTTP for debugging")
+		}
+		log.Println("Warning: AGENT_INSECURE_HTTP=1, agent is serving plain HTTP")
+		log.Println("Agent starting on", listen)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Fatal("Failed to start agent:", err)
+		}
+		return
+	}
+
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("AGENT_CLIENT_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatal("Failed to read AGENT_CLIENT_CA:", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatal("AGENT_CLIENT_CA contains no certificates")
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	log.Println("Agent starting on", listen, "(TLS)")
+	if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil {
+		log.Fatal("Failed to start agent:", err)
+	}
+}
+
+// verifySignature проверяет HMAC-подпись запроса и не пускает повторы в пределах окна
+func verifySignature(secret string) gin.HandlerFunc {
+	var (
+		mu   sync.Mutex
+		seen = map[string]time.Time{}
+	)
+
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxConfigSize+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+			return
+		}
+		if len(body) > maxConfigSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Body too large"})
+			return
+		}
+
+		if err := service.VerifyAgentRequest(secret, c.Request, body); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Подписанный nonce живёт в кэше дольше окна времени, поэтому повтор запроса отсекается
+		nonce := c.GetHeader(service.AgentNonceHeader)
+		now := time.Now()
+		mu.Lock()
+		for n, at := range seen {
+			if now.Sub(at) > 2*service.AgentMaxClockSkew {
+				delete(seen, n)
+			}
+		}
+		_, replay := seen[nonce]
+		seen[nonce] = now
+		mu.Unlock()
+		if replay {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Replayed request"})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// POST /v1/singbox — проверить и применить конфиг sing-box
+func applySingbox(c *gin.Context) {
+	data, _ := io.ReadAll(c.Request.Body)
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Empty config"})
+		return
+	}
+
+	if err := service.InstallSingboxConfig(data); err != nil {
+		log.Println("Failed to apply sing-box config:", err)
+		var checkErr *service.ConfigCheckError
+		if errors.As(err, &checkErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":       err.Error(),
+				"output":      checkErr.Output,
+				"rolled_back": checkErr.RolledBack,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Config applied"})
+}
+
+// POST /v1/telemt — применить конфиг telemt
+func applyTelemet(c *gin.Context) {
+	data, _ := io.ReadAll(c.Request.Body)
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Empty config"})
+		return
+	}
+
+	if err := service.InstallTelemetConfig(data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Config applied"})
+}
+
+// GET /v1/stats — счётчики V2Ray API локального sing-box
+func getStats(c *gin.Context) {
+	stats, err := service.QueryV2RayStats(service.ApiAddr)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GET /v1/health — состояние sing-box и telemt
+func getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, service.LocalNodeHealth())
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "test-agent-secret"
+
+// testServer — агент с проверкой подписи и эхо-обработчиком вместо применения конфигов
+func testServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(verifySignature(testSecret))
+	r.POST("/v1/echo", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/octet-stream", body)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func signedRequest(t *testing.T, url, secret string, ts int64, nonce string, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/echo", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(service.AgentTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(service.AgentNonceHeader, nonce)
+	req.Header.Set(service.AgentSignatureHeader, service.SignAgentRequest(secret, http.MethodPost, "/v1/echo", ts, nonce, body))
+	return req
+}
+
+func do(t *testing.T, req *http.Request) (int, []byte) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+func TestVerifySignature(t *testing.T) {
+	srv := testServer(t)
+	now := time.Now().Unix()
+	body := []byte(`{"inbounds":[]}`)
+
+	status, got := do(t, signedRequest(t, srv.URL, testSecret, now, service.NewAgentNonce(), body))
+	if status != http.StatusOK || !bytes.Equal(got, body) {
+		t.Fatalf("signed request: %d %s", status, got)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"wrong secret", signedRequest(t, srv.URL, "other", now, service.NewAgentNonce(), body), http.StatusUnauthorized},
+		{"stale timestamp", signedRequest(t, srv.URL, testSecret, now-int64(2*service.AgentMaxClockSkew/time.Second), service.NewAgentNonce(), body), http.StatusUnauthorized},
+		{"future timestamp", signedRequest(t, srv.URL, testSecret, now+int64(2*service.AgentMaxClockSkew/time.Second), service.NewAgentNonce(), body), http.StatusUnauthorized},
+		{"body too large", signedRequest(t, srv.URL, testSecret, now, service.NewAgentNonce(), make([]byte, maxConfigSize+1)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := do(t, tt.req); status != tt.want {
+				t.Errorf("status = %d, want %d: %s", status, tt.want, body)
+			}
+		})
+	}
+
+	// Тело подменено после подписи
+	req := signedRequest(t, srv.URL, testSecret, now, service.NewAgentNonce(), body)
+	req.Body = io.NopCloser(bytes.NewReader([]byte(`{"inbounds":[1]}`)))
+	req.ContentLength = int64(len(`{"inbounds":[1]}`))
+	if status, _ := do(t, req); status != http.StatusUnauthorized {
+		t.Errorf("tampered body: status = %d, want 401", status)
+	}
+}
+
+func TestVerifySignatureReplay(t *testing.T) {
+	srv := testServer(t)
+	now := time.Now().Unix()
+	body := []byte("config")
+
+	nonce := service.NewAgentNonce()
+	if status, _ := do(t, signedRequest(t, srv.URL, testSecret, now, nonce, body)); status != http.StatusOK {
+		t.Fatalf("first request: status = %d", status)
+	}
+	if status, _ := do(t, signedRequest(t, srv.URL, testSecret, now, nonce, body)); status != http.StatusUnauthorized {
+		t.Errorf("replayed request: status = %d, want 401", status)
+	}
+
+	// Тот же запрос в ту же секунду, но с новым nonce — не повтор
+	if status, _ := do(t, signedRequest(t, srv.URL, testSecret, now, service.NewAgentNonce(), body)); status != http.StatusOK {
+		t.Errorf("same request with a new nonce: status = %d, want 200", status)
+	}
+}
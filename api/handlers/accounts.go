@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+	"vpnbot/api/middleware"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// GET /api/me — текущий аккаунт и его права
+func GetMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var account database.AdminAccount
+		if err := database.DB.First(&account, c.GetUint(middleware.ContextAdminID)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"account":                  account,
+			"permissions":              middleware.RolePermissions[account.Role],
+			"recovery_codes_remaining": service.RemainingRecoveryCodes(account),
+		})
+	}
+}
+
+// PUT /api/me/password — сменить свой пароль
+func ChangeMyPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			OldPassword string `json:"old_password" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		var account database.AdminAccount
+		if err := database.DB.First(&account, c.GetUint(middleware.ContextAdminID)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if !service.CheckAdminPassword(account, input.OldPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+
+		hash, err := service.HashAdminPassword(input.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		database.DB.Model(&account).Update("password_hash", hash)
+		service.RevokeOtherAdminSessions(account.ID, c.GetUint(middleware.ContextSessionID))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed, other sessions revoked"})
+	}
+}
+
+// GET /api/accounts — список аккаунтов админки
+func GetAccounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var accounts []database.AdminAccount
+		database.DB.Order("id").Find(&accounts)
+		c.JSON(http.StatusOK, accounts)
+	}
+}
+
+// POST /api/accounts — создать аккаунт
+func CreateAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Username   string `json:"username" binding:"required"`
+			Password   string `json:"password" binding:"required"`
+			Role       string `json:"role" binding:"required"`
+			TelegramID int64  `json:"telegram_id"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		input.Username = strings.TrimSpace(input.Username)
+		if input.Username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+			return
+		}
+		if err := service.ValidateAdminRole(input.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var count int64
+		database.DB.Model(&database.AdminAccount{}).Where("username = ?", input.Username).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+
+		if !telegramIDFree(c, input.TelegramID, 0) {
+			return
+		}
+
+		hash, err := service.HashAdminPassword(input.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		account := database.AdminAccount{Username: input.Username, PasswordHash: hash, Role: input.Role, TelegramID: input.TelegramID}
+		if err := database.DB.Create(&account).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, account)
+	}
+}
+
+// PUT /api/accounts/:id — сменить роль, пароль, привязку Telegram или отключить аккаунт
+func UpdateAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var account database.AdminAccount
+		if err := database.DB.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		var input struct {
+			Role       *string `json:"role"`
+			Password   *string `json:"password"`
+			Disabled   *bool   `json:"disabled"`
+			ResetTOTP  bool    `json:"reset_totp"`  // Для админа, потерявшего аутентификатор и коды
+			TelegramID *int64  `json:"telegram_id"` // 0 — отвязать Telegram
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if input.Role != nil {
+			if err := service.ValidateAdminRole(*input.Role); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updates["role"] = *input.Role
+		}
+		if input.Password != nil {
+			hash, err := service.HashAdminPassword(*input.Password)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updates["password_hash"] = hash
+		}
+		if input.Disabled != nil {
+			if *input.Disabled && account.ID == c.GetUint(middleware.ContextAdminID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot disable your own account"})
+				return
+			}
+			updates["disabled"] = *input.Disabled
+		}
+
+		if input.TelegramID != nil {
+			if !telegramIDFree(c, *input.TelegramID, account.ID) {
+				return
+			}
+			updates["telegram_id"] = *input.TelegramID
+		}
+
+		// Нельзя остаться без owner'а
+		losesOwner := (input.Role != nil && *input.Role != service.RoleOwner) || (input.Disabled != nil && *input.Disabled)
+		if account.Role == service.RoleOwner && !account.Disabled && losesOwner && service.CountActiveOwners(account.ID) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one active owner account is required"})
+			return
+		}
+
+		if input.ResetTOTP {
+			updates["totp_secret"] = ""
+			updates["totp_enabled"] = false
+			updates["recovery_codes"] = ""
+		}
+
+		if len(updates) > 0 {
+			database.DB.Model(&account).Updates(updates)
+		}
+		if input.Password != nil || (input.Disabled != nil && *input.Disabled) || input.ResetTOTP {
+			service.RevokeAdminSessions(account.ID)
+		}
+		database.DB.First(&account, account.ID)
+
+		c.JSON(http.StatusOK, account)
+	}
+}
+
+// telegramIDFree проверяет, что Telegram ID не привязан к другому аккаунту, иначе отвечает 409
+func telegramIDFree(c *gin.Context, telegramID int64, selfID uint) bool {
+	if telegramID == 0 {
+		return true
+	}
+	var count int64
+	database.DB.Model(&database.AdminAccount{}).Where("telegram_id = ? AND id != ?", telegramID, selfID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Telegram ID is already linked to another account"})
+		return false
+	}
+	return true
+}
+
+// DELETE /api/accounts/:id — удалить аккаунт
+func DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var account database.AdminAccount
+		if err := database.DB.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		if account.ID == c.GetUint(middleware.ContextAdminID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete your own account"})
+			return
+		}
+		if account.Role == service.RoleOwner && !account.Disabled && service.CountActiveOwners(account.ID) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one active owner account is required"})
+			return
+		}
+
+		// Hard delete, чтобы имя можно было занять снова (uniqueIndex)
+		database.DB.Unscoped().Delete(&account)
+		service.RevokeAdminSessions(account.ID)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+	}
+}
+
+// POST /api/accounts/:id/revoke-sessions — разлогинить аккаунт везде
+func RevokeAccountSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var account database.AdminAccount
+		if err := database.DB.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		service.RevokeAdminSessions(account.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked"})
+	}
+}
+
+// GET /api/me/sessions — активные сессии текущего аккаунта
+func GetMySessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sessions []database.AdminSession
+		database.DB.Where("account_id = ? AND revoked_at IS NULL AND expires_at > ?", c.GetUint(middleware.ContextAdminID), time.Now()).
+			Order("updated_at desc").Find(&sessions)
+
+		current := c.GetUint(middleware.ContextSessionID)
+		result := make([]gin.H, 0, len(sessions))
+		for _, s := range sessions {
+			result = append(result, gin.H{"session": s, "current": s.ID == current})
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// DELETE /api/me/sessions/:id — отозвать свою сессию (например, забытую на чужом компьютере)
+func RevokeMySession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var session database.AdminSession
+		if err := database.DB.Where("id = ? AND account_id = ?", c.Param("id"), c.GetUint(middleware.ContextAdminID)).First(&session).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		service.RevokeAdminSession(session.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+	}
+}
+
+// currentAccount загружает аккаунт из контекста запроса
+func currentAccount(c *gin.Context) (database.AdminAccount, bool) {
+	var account database.AdminAccount
+	if err := database.DB.First(&account, c.GetUint(middleware.ContextAdminID)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return account, false
+	}
+	return account, true
+}
+
+// POST /api/me/totp/setup — сгенерировать секрет TOTP и QR для приложения.
+// Двухфакторка включится только после подтверждения кодом в /api/me/totp/enable.
+func SetupTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		account, ok := currentAccount(c)
+		if !ok {
+			return
+		}
+		if !service.CheckAdminPassword(account, input.Password) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+		if account.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "TOTP is already enabled"})
+			return
+		}
+
+		secret := service.GenerateTOTPSecret()
+		database.DB.Model(&account).Update("totp_secret", secret)
+
+		otpURL := service.TOTPProvisioningURL("VPN Admin", account.Username, secret)
+		response := gin.H{"secret": secret, "otpauth_url": otpURL}
+		if png, err := qrcode.Encode(otpURL, qrcode.Medium, 256); err == nil {
+			response["qr_png_base64"] = base64.StdEncoding.EncodeToString(png)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// POST /api/me/totp/enable — подтвердить секрет кодом и получить коды восстановления
+func EnableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		account, ok := currentAccount(c)
+		if !ok {
+			return
+		}
+		if account.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "TOTP is already enabled"})
+			return
+		}
+		if account.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Call /api/me/totp/setup first"})
+			return
+		}
+		if !service.VerifyAdminTOTP(&account, input.Code) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid TOTP code"})
+			return
+		}
+
+		database.DB.Model(&account).Update("totp_enabled", true)
+		codes := service.GenerateRecoveryCodes(&account)
+		service.RevokeOtherAdminSessions(account.ID, c.GetUint(middleware.ContextSessionID))
+
+		c.JSON(http.StatusOK, gin.H{"message": "TOTP enabled", "recovery_codes": codes})
+	}
+}
+
+// POST /api/me/totp/disable — выключить TOTP (нужны пароль и текущий код)
+func DisableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Password string `json:"password" binding:"required"`
+			Code     string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		account, ok := currentAccount(c)
+		if !ok {
+			return
+		}
+		if !account.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP is not enabled"})
+			return
+		}
+		if !service.CheckAdminPassword(account, input.Password) ||
+			(!service.VerifyAdminTOTP(&account, input.Code) && !service.UseRecoveryCode(&account, input.Code)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+			return
+		}
+
+		database.DB.Model(&account).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"recovery_codes": "",
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "TOTP disabled"})
+	}
+}
+
+// POST /api/me/totp/recovery-codes — выпустить новые коды восстановления взамен старых
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		account, ok := currentAccount(c)
+		if !ok {
+			return
+		}
+		if !account.TOTPEnabled || !service.VerifyAdminTOTP(&account, input.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid TOTP code"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": service.GenerateRecoveryCodes(&account)})
+	}
+}
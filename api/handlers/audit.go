@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"vpnbot/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/audit — журнал мутирующих вызовов API.
+// Фильтры: ?username=&method=&route=&status=&ip=&from=&to=&limit=&offset=
+func GetAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+
+		query := database.DB.Model(&database.AuditEvent{})
+		if username := c.Query("username"); username != "" {
+			query = query.Where("username = ?", username)
+		}
+		if method := c.Query("method"); method != "" {
+			query = query.Where("method = ?", method)
+		}
+		if route := c.Query("route"); route != "" {
+			query = query.Where("route LIKE ?", route+"%")
+		}
+		if status := c.Query("status"); status != "" {
+			// status=4xx / 5xx — весь класс ответов
+			if len(status) == 3 && status[1:] == "xx" && status[0] >= '1' && status[0] <= '5' {
+				base := int(status[0]-'0') * 100
+				query = query.Where("status >= ? AND status < ?", base, base+100)
+			} else {
+				query = query.Where("status = ?", status)
+			}
+		}
+		if ip := c.Query("ip"); ip != "" {
+			query = query.Where("ip = ?", ip)
+		}
+		if v := c.Query("from"); v != "" {
+			from, err := parseTimeParam(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from'"})
+				return
+			}
+			query = query.Where("created_at >= ?", from.Local())
+		}
+		if v := c.Query("to"); v != "" {
+			to, err := parseTimeParam(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to'"})
+				return
+			}
+			query = query.Where("created_at < ?", to.Local())
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var events []database.AuditEvent
+		query.Order("id desc").Limit(limit).Offset(offset).Find(&events)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "events": events})
+	}
+}
@@ -1,16 +1,116 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
+	"vpnbot/api/middleware"
+	"vpnbot/database"
+	"vpnbot/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type LoginRequest struct {
-	Password string `json:"password" binding:"required"`
+	Username     string `json:"username"` // Пусто = "admin" (совместимость со старым фронтом)
+	Password     string `json:"password" binding:"required"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// signAccessToken выпускает короткий access-токен для сессии
+func signAccessToken(jwtSecret []byte, account database.AdminAccount, session database.AdminSession) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.AdminClaims{
+		Role: account.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.AccessJTI,
+			Subject:   strconv.FormatUint(uint64(account.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(service.AdminAccessTokenTTL)),
+		},
+	})
+	return token.SignedString(jwtSecret)
+}
+
+// issueSession заводит новую сессию для аккаунта и отвечает парой access/refresh токенов
+func issueSession(c *gin.Context, jwtSecret []byte, account database.AdminAccount) {
+	session, refresh, err := service.CreateAdminSession(account.ID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create session"})
+		return
+	}
+
+	tokenString, err := signAccessToken(jwtSecret, account, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	database.DB.Model(&account).Update("last_login_at", time.Now())
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokenString,
+		"refresh_token": refresh,
+		"expires_in":    int(service.AdminAccessTokenTTL.Seconds()),
+		"username":      account.Username,
+		"role":          account.Role,
+	})
+}
+
+// loginAllowed проверяет троттлинг входа с IP и при блокировке отвечает 429 с Retry-After
+func loginAllowed(c *gin.Context, ip string) bool {
+	wait, ok := service.LoginAllowed(ip)
+	if !ok {
+		loginThrottled(c, wait)
+	}
+	return ok
+}
+
+func loginThrottled(c *gin.Context, wait time.Duration) {
+	seconds := int(wait.Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts", "retry_after": seconds})
+}
+
+// loginFailed учитывает неудачный вход и отвечает status/body,
+// а во время глобальной блокировки — 429, как будто вход закрыт
+func loginFailed(c *gin.Context, ip, username string, status int, body gin.H) {
+	service.RecordLoginFailure(ip, username)
+	if wait, locked := service.GlobalLoginLock(); locked {
+		loginThrottled(c, wait)
+		return
+	}
+	c.JSON(status, body)
+}
+
+// checkSecondFactor — второй шаг входа для аккаунтов с 2FA: TOTP или код восстановления.
+// Общий для пароля, Telegram и ссылки из бота. false — ответ уже отправлен.
+func checkSecondFactor(c *gin.Context, ip, username string, account *database.AdminAccount, totpCode, recoveryCode string) bool {
+	if !account.TOTPEnabled {
+		return true
+	}
+	switch {
+	case totpCode != "":
+		if !service.VerifyAdminTOTP(account, totpCode) {
+			loginFailed(c, ip, username, http.StatusUnauthorized, gin.H{"error": "Invalid TOTP code", "totp_required": true})
+			return false
+		}
+	case recoveryCode != "":
+		if !service.UseRecoveryCode(account, recoveryCode) {
+			loginFailed(c, ip, username, http.StatusUnauthorized, gin.H{"error": "Invalid recovery code", "totp_required": true})
+			return false
+		}
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "TOTP code required", "totp_required": true})
+		return false
+	}
+	return true
 }
 
 func Login(jwtSecret []byte) gin.HandlerFunc {
@@ -21,28 +121,174 @@ func Login(jwtSecret []byte) gin.HandlerFunc {
 			return
 		}
 
-		adminPassword := os.Getenv("ADMIN_PASSWORD")
-		if adminPassword == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server admin password not configured"})
+		ip := c.ClientIP()
+		if !loginAllowed(c, ip) {
 			return
 		}
 
-		if loginReq.Password != adminPassword {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		username := strings.TrimSpace(loginReq.Username)
+		if username == "" {
+			username = "admin"
+		}
+
+		var account database.AdminAccount
+		if err := database.DB.Where("username = ?", username).First(&account).Error; err != nil ||
+			account.Disabled || !service.CheckAdminPassword(account, loginReq.Password) {
+			loginFailed(c, ip, username, http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"admin": true,
-			"exp":   time.Now().Add(time.Hour * 24).Unix(),
-		})
+		if !checkSecondFactor(c, ip, username, &account, loginReq.TOTPCode, loginReq.RecoveryCode) {
+			return
+		}
+
+		service.RecordLoginSuccess(ip)
+		issueSession(c, jwtSecret, account)
+	}
+}
+
+// POST /api/login/telegram — вход через Telegram Login Widget.
+// Тело — объект, который отдаёт виджет: id, first_name, username, photo_url, auth_date, hash.
+// Для аккаунтов с 2FA к нему добавляется totp_code или recovery_code (в подпись виджета не входят).
+func TelegramLogin(jwtSecret []byte, botToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var raw map[string]interface{}
+		decoder := json.NewDecoder(c.Request.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		totpCode, _ := raw["totp_code"].(string)
+		recoveryCode, _ := raw["recovery_code"].(string)
+		delete(raw, "totp_code")
+		delete(raw, "recovery_code")
+
+		data := make(map[string]string, len(raw))
+		for k, v := range raw {
+			if v != nil {
+				data[k] = fmt.Sprint(v)
+			}
+		}
+
+		ip := c.ClientIP()
+		if !loginAllowed(c, ip) {
+			return
+		}
+
+		telegramID, err := service.VerifyTelegramLogin(botToken, data)
+		if err != nil {
+			loginFailed(c, ip, "telegram:"+data["id"], http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
 
-		tokenString, err := token.SignedString(jwtSecret)
+		account, err := service.AdminAccountByTelegramID(telegramID)
+		if err != nil {
+			loginFailed(c, ip, "telegram:"+data["id"], http.StatusForbidden, gin.H{"error": "This Telegram account has no access to the admin panel"})
+			return
+		}
+		if !checkSecondFactor(c, ip, account.Username, &account, totpCode, recoveryCode) {
+			return
+		}
+
+		service.RecordLoginSuccess(ip)
+		issueSession(c, jwtSecret, account)
+	}
+}
+
+// POST /api/login/magic — обмен одноразовой ссылки из бота (/panel) на сессию.
+// Для аккаунтов с 2FA нужен ещё totp_code или recovery_code; до их проверки ссылка не гасится.
+func MagicLinkLogin(jwtSecret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token        string `json:"token" binding:"required"`
+			TOTPCode     string `json:"totp_code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		ip := c.ClientIP()
+		if !loginAllowed(c, ip) {
+			return
+		}
+
+		account, err := service.PanelLoginTokenAccount(input.Token)
+		if err != nil {
+			loginFailed(c, ip, "magic-link", http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !checkSecondFactor(c, ip, account.Username, &account, input.TOTPCode, input.RecoveryCode) {
+			return
+		}
+
+		consumed, err := service.ConsumePanelLoginToken(input.Token)
+		if err != nil || consumed.ID != account.ID {
+			loginFailed(c, ip, "magic-link", http.StatusUnauthorized, gin.H{"error": service.ErrPanelTokenInvalid.Error()})
+			return
+		}
+
+		service.RecordLoginSuccess(ip)
+		issueSession(c, jwtSecret, account)
+	}
+}
+
+// POST /api/token/refresh — обменять refresh-токен на новую пару токенов
+func RefreshToken(jwtSecret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		session, refresh, err := service.RotateAdminSession(input.RefreshToken)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if !errors.Is(err, service.ErrSessionNotFound) && !errors.Is(err, service.ErrRefreshReused) {
+				status = http.StatusInternalServerError
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		var account database.AdminAccount
+		if err := database.DB.First(&account, session.AccountID).Error; err != nil || account.Disabled {
+			service.RevokeAdminSession(session.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account not found or disabled"})
+			return
+		}
+
+		tokenString, err := signAccessToken(jwtSecret, account, session)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"token": tokenString})
+		c.JSON(http.StatusOK, gin.H{
+			"token":         tokenString,
+			"refresh_token": refresh,
+			"expires_in":    int(service.AdminAccessTokenTTL.Seconds()),
+		})
+	}
+}
+
+// POST /api/logout — отозвать текущую сессию
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		service.RevokeAdminSession(c.GetUint(middleware.ContextSessionID))
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// POST /api/logout-all — отозвать все сессии текущего аккаунта
+func LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		service.RevokeAdminSessions(c.GetUint(middleware.ContextAdminID))
+		c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked"})
 	}
 }
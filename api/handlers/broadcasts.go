@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"vpnbot/api/middleware"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/broadcasts — рассылки с отчётами о доставке, новые первыми. Фильтры: ?status=&limit=&offset=
+func GetBroadcasts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := database.DB.Model(&database.Broadcast{})
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Count(&total)
+
+		broadcasts := []database.Broadcast{}
+		query.Order("id desc").Limit(limit).Offset(offset).Find(&broadcasts)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "broadcasts": broadcasts})
+	}
+}
+
+// GET /api/broadcasts/:id — рассылка и отчёт о доставке
+func GetBroadcast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bc database.Broadcast
+		if err := database.DB.First(&bc, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Broadcast not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"broadcast": bc, "report": service.BroadcastReportText(bc)})
+	}
+}
+
+// GET /api/broadcasts/:id/deliveries — доставки по юзерам. Фильтры: ?status=sent|failed|blocked|pending&limit=&offset=
+func GetBroadcastDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := database.DB.Model(&database.BroadcastDelivery{}).Where("broadcast_id = ?", c.Param("id"))
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Count(&total)
+
+		deliveries := []database.BroadcastDelivery{}
+		query.Order("id").Limit(limit).Offset(offset).Find(&deliveries)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "deliveries": deliveries})
+	}
+}
+
+// POST /api/broadcasts — черновик рассылки: {"text": "...", "parse_mode": "html", "media_type": "photo", "media_file": "https://...",
+// "filter_status": "active", "filter_plan_id": 0, "filter_inbound": "", "seen_within_days": 0, "not_seen_for_days": 0}.
+// В ответе total — сколько юзеров получат рассылку. Отправка — POST /api/broadcasts/:id/send.
+func CreateBroadcast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bc database.Broadcast
+		if err := c.ShouldBindJSON(&bc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		bc.CreatedBy = "api: " + c.GetString(middleware.ContextAdminUsername)
+
+		if err := service.CreateBroadcast(&bc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, bc)
+	}
+}
+
+// POST /api/broadcasts/:id/preview — прислать черновик в Telegram: {"telegram_id": 0}.
+// По умолчанию — в Telegram, привязанный к аккаунту админки.
+func PreviewBroadcast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bc database.Broadcast
+		if err := database.DB.First(&bc, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Broadcast not found"})
+			return
+		}
+
+		var input struct {
+			TelegramID int64 `json:"telegram_id"`
+		}
+		c.ShouldBindJSON(&input)
+		if input.TelegramID == 0 {
+			var account database.AdminAccount
+			if database.DB.First(&account, c.GetUint(middleware.ContextAdminID)).Error == nil {
+				input.TelegramID = account.TelegramID
+			}
+		}
+		if input.TelegramID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "telegram_id is required: account has no linked Telegram"})
+			return
+		}
+
+		if err := service.PreviewBroadcast(bc, input.TelegramID); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Preview failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Preview sent"})
+	}
+}
+
+// POST /api/broadcasts/:id/send — подтвердить черновик и поставить в очередь
+func SendBroadcast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bc database.Broadcast
+		if err := database.DB.First(&bc, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Broadcast not found"})
+			return
+		}
+
+		err := service.QueueBroadcast(&bc)
+		switch {
+		case errors.Is(err, service.ErrBroadcastNotDraft), errors.Is(err, service.ErrBroadcastEmpty):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, bc)
+	}
+}
+
+// POST /api/broadcasts/:id/cancel — отменить черновик или остановить отправку
+func CancelBroadcast() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bc database.Broadcast
+		if err := database.DB.First(&bc, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Broadcast not found"})
+			return
+		}
+
+		err := service.CancelBroadcast(&bc)
+		if errors.Is(err, service.ErrBroadcastFinished) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, bc)
+	}
+}
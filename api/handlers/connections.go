@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// connectionRow — запись журнала подключений с именем юзера
+type connectionRow struct {
+	database.ConnectionLog
+	Username string `json:"username"`
+}
+
+// connectionsQuery применяет общие фильтры журнала подключений:
+// ?ip= (точно или префикс с * на конце), ?inbound=, ?from=&to= (RFC3339 или YYYY-MM-DD, по умолчанию последние сутки)
+func connectionsQuery(c *gin.Context) (*gorm.DB, time.Time, time.Time, bool) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := c.Query("to"); v != "" {
+		if to, err = parseTimeParam(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to'"})
+			return nil, from, to, false
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		if from, err = parseTimeParam(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from'"})
+			return nil, from, to, false
+		}
+	}
+
+	query := database.DB.Model(&database.ConnectionLog{}).
+		Where("connection_logs.timestamp >= ? AND connection_logs.timestamp < ?", from.Local(), to.Local())
+	if ip := c.Query("ip"); ip != "" {
+		if strings.HasSuffix(ip, "*") {
+			query = query.Where("connection_logs.client_ip LIKE ?", strings.TrimSuffix(ip, "*")+"%")
+		} else {
+			query = query.Where("connection_logs.client_ip = ?", ip)
+		}
+	}
+	if inbound := c.Query("inbound"); inbound != "" {
+		query = query.Where("connection_logs.inbound_tag = ?", inbound)
+	}
+	return query, from, to, true
+}
+
+func pageParams(c *gin.Context) (limit, offset int) {
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset, _ = strconv.Atoi(c.Query("offset"))
+	return limit, max(offset, 0)
+}
+
+// GET /api/users/:id/connections — подключения юзера за период и сводка по IP:
+// сколько раз и когда последний раз юзер приходил с каждого адреса
+func GetUserConnections() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		query, from, to, ok := connectionsQuery(c)
+		if !ok {
+			return
+		}
+		query = query.Where("connection_logs.user_id = ?", user.ID)
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+
+		var rows []database.ConnectionLog
+		query.Session(&gorm.Session{}).Order("timestamp desc, id desc").Limit(limit).Offset(offset).Find(&rows)
+
+		type ipSummary struct {
+			ClientIP    string    `json:"client_ip"`
+			Connections int64     `json:"connections"`
+			FirstSeen   time.Time `json:"first_seen"`
+			LastSeen    time.Time `json:"last_seen"`
+		}
+		var ips []struct {
+			ClientIP    string
+			Connections int64
+			FirstSeen   string
+			LastSeen    string
+		}
+		query.Session(&gorm.Session{}).Where("reason = ?", "").
+			Select("client_ip, COUNT(*) AS connections, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen").
+			Group("client_ip").Order("last_seen desc").Scan(&ips)
+
+		summary := make([]ipSummary, 0, len(ips))
+		for _, ip := range ips {
+			summary = append(summary, ipSummary{
+				ClientIP:    ip.ClientIP,
+				Connections: ip.Connections,
+				FirstSeen:   parseSQLiteTime(ip.FirstSeen),
+				LastSeen:    parseSQLiteTime(ip.LastSeen),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":     user.ID,
+			"from":        from,
+			"to":          to,
+			"total":       total,
+			"ips":         summary,
+			"connections": rows,
+		})
+	}
+}
+
+// GET /api/connections — журнал подключений всех юзеров.
+// Фильтры: ?ip=&inbound=&user_id=&from=&to=&limit=&offset=
+func GetConnections() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, from, to, ok := connectionsQuery(c)
+		if !ok {
+			return
+		}
+		if userID := c.Query("user_id"); userID != "" {
+			query = query.Where("connection_logs.user_id = ?", userID)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+
+		rows := []connectionRow{}
+		query.Select("connection_logs.*, users.username").
+			Joins("LEFT JOIN users ON users.id = connection_logs.user_id").
+			Order("connection_logs.timestamp desc, connection_logs.id desc").
+			Limit(limit).Offset(offset).Scan(&rows)
+
+		c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "total": total, "connections": rows})
+	}
+}
+
+// parseSQLiteTime разбирает время из агрегатов SQLite (MIN/MAX возвращают строку)
+func parseSQLiteTime(v string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
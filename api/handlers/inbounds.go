@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 	"vpnbot/database"
@@ -225,9 +227,14 @@ func CreateInbound() gin.HandlerFunc {
 			return
 		}
 
-		// Check unique port (if non-zero)
+		if input.NodeID != 0 && database.DB.First(&database.Node{}, input.NodeID).Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Node not found"})
+			return
+		}
+
+		// Check unique port on the same node (if non-zero)
 		if input.ListenPort != 0 {
-			database.DB.Model(&database.InboundConfig{}).Where("listen_port = ?", input.ListenPort).Count(&count)
+			database.DB.Model(&database.InboundConfig{}).Where("listen_port = ? AND node_id = ?", input.ListenPort, input.NodeID).Count(&count)
 			if count > 0 {
 				c.JSON(http.StatusConflict, gin.H{"error": "Port already in use"})
 				return
@@ -250,7 +257,11 @@ func CreateInbound() gin.HandlerFunc {
 			return
 		}
 
-		service.GenerateAndReload()
+		if !reloadAfterInboundChange(c, func() {
+			database.DB.Unscoped().Delete(&database.InboundConfig{}, input.ID)
+		}, input.NodeID) {
+			return
+		}
 
 		// Авто-открытие порта и проброс
 		if !req.AutoOpenFirewall && !req.AutoAddForward {
@@ -321,10 +332,19 @@ func UpdateInbound() gin.HandlerFunc {
 			}
 		}
 
-		// Check unique port if changed
-		if input.ListenPort != 0 && input.ListenPort != existing.ListenPort {
+		if input.NodeID != 0 && database.DB.First(&database.Node{}, input.NodeID).Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Node not found"})
+			return
+		}
+
+		// Check unique port on the target node if port or node changed
+		nodeID := existing.NodeID
+		if input.NodeID != 0 {
+			nodeID = input.NodeID
+		}
+		if input.ListenPort != 0 && (input.ListenPort != existing.ListenPort || nodeID != existing.NodeID) {
 			var count int64
-			database.DB.Model(&database.InboundConfig{}).Where("listen_port = ? AND id != ?", input.ListenPort, existing.ID).Count(&count)
+			database.DB.Model(&database.InboundConfig{}).Where("listen_port = ? AND node_id = ? AND id != ?", input.ListenPort, nodeID, existing.ID).Count(&count)
 			if count > 0 {
 				c.JSON(http.StatusConflict, gin.H{"error": "Port already in use"})
 				return
@@ -335,12 +355,16 @@ func UpdateInbound() gin.HandlerFunc {
 		input.IsBuiltin = existing.IsBuiltin
 		input.ID = existing.ID
 
+		previous := existing
 		database.DB.Model(&existing).Updates(input)
 
 		// Reload updated record
 		database.DB.First(&existing, id)
 
-		service.GenerateAndReload()
+		// Инбаунд мог переехать на другую ноду — важны обе
+		if !reloadAfterInboundChange(c, func() { database.DB.Save(&previous) }, existing.NodeID, previous.NodeID) {
+			return
+		}
 
 		c.JSON(http.StatusOK, existing)
 	}
@@ -363,7 +387,11 @@ func DeleteInbound() gin.HandlerFunc {
 
 		database.DB.Delete(&existing)
 
-		service.GenerateAndReload()
+		if !reloadAfterInboundChange(c, func() {
+			database.DB.Unscoped().Model(&existing).Update("deleted_at", nil)
+		}, existing.NodeID) {
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"message": "Inbound deleted"})
 	}
@@ -382,8 +410,65 @@ func ToggleInbound() gin.HandlerFunc {
 		existing.Enabled = !existing.Enabled
 		database.DB.Model(&existing).Update("enabled", existing.Enabled)
 
-		service.GenerateAndReload()
+		if !reloadAfterInboundChange(c, func() {
+			database.DB.Model(&existing).Update("enabled", !existing.Enabled)
+		}, existing.NodeID) {
+			return
+		}
 
 		c.JSON(http.StatusOK, existing)
 	}
 }
+
+// reloadAfterInboundChange применяет изменение инбаунда на нодах. Ошибки на других нодах и в telemt
+// не относятся к этому изменению — они только логируются. Если нода инбаунда (nodeIDs) отвергла
+// новый конфиг, изменение в БД откатывается через revert, конфиги перегенерируются заново и клиент
+// получает 422 с выводом проверки. Возвращает true, если можно отвечать успехом.
+func reloadAfterInboundChange(c *gin.Context, revert func(), nodeIDs ...uint) bool {
+	err := service.ReloadNow(reloadTrigger(c))
+	if err == nil {
+		return true
+	}
+
+	failed := service.NodeReloadErrors(err)
+	var own *service.NodeReloadError
+	for _, id := range nodeIDs {
+		if nodeErr, ok := failed[id]; ok {
+			own = nodeErr
+			break
+		}
+	}
+	if own == nil {
+		log.Println("Inbound change applied, but reload failed elsewhere:", err)
+		return true
+	}
+
+	nodes := gin.H{}
+	for _, nodeErr := range failed {
+		nodes[nodeErr.Node] = nodeErr.Err.Error()
+	}
+
+	var checkErr *service.ConfigCheckError
+	if errors.As(own, &checkErr) {
+		revert()
+		if restoreErr := service.ReloadNow(reloadTrigger(c) + " (revert)"); restoreErr != nil {
+			log.Println("Failed to restore config after rejected inbound change:", restoreErr)
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       "sing-box rejected the new config, change reverted",
+			"node":        own.Node,
+			"details":     own.Err.Error(),
+			"rolled_back": checkErr.RolledBack,
+			"nodes":       nodes,
+		})
+		return false
+	}
+
+	c.JSON(http.StatusBadGateway, gin.H{
+		"error":   "Change saved, but config reload failed",
+		"node":    own.Node,
+		"details": own.Err.Error(),
+		"nodes":   nodes,
+	})
+	return false
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inviteView — код приглашения со ссылкой на бота
+type inviteView struct {
+	database.InviteCode
+	Link   string `json:"link"`
+	Active bool   `json:"active"`
+}
+
+func newInviteView(inv database.InviteCode) inviteView {
+	return inviteView{InviteCode: inv, Link: service.InviteLink(inv.Code), Active: service.InviteCodeActive(inv)}
+}
+
+// GET /api/invites — коды приглашений, новые первыми. Фильтры: ?active=true&owner_user_id=&limit=&offset=
+func GetInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := database.DB.Model(&database.InviteCode{})
+		if c.Query("active") == "true" {
+			query = query.Where("revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+		}
+		if owner := c.Query("owner_user_id"); owner != "" {
+			query = query.Where("owner_user_id = ?", owner)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Count(&total)
+
+		var invites []database.InviteCode
+		query.Order("id desc").Limit(limit).Offset(offset).Find(&invites)
+
+		result := make([]inviteView, 0, len(invites))
+		for _, inv := range invites {
+			result = append(result, newInviteView(inv))
+		}
+		c.JSON(http.StatusOK, gin.H{"total": total, "invites": result})
+	}
+}
+
+// POST /api/invites — создать код: {"code": "", "plan_id": 0, "max_uses": 1, "expires_at": null, "owner_user_id": 0, "note": ""}.
+// Пустой code генерируется.
+func CreateInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var invite database.InviteCode
+		if err := c.ShouldBindJSON(&invite); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		if invite.OwnerUserID != 0 {
+			var owner database.User
+			if err := database.DB.First(&owner, invite.OwnerUserID).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Owner user not found"})
+				return
+			}
+		}
+
+		if err := service.CreateInviteCode(&invite); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, newInviteView(invite))
+	}
+}
+
+// DELETE /api/invites/:id — отозвать код. Юзеры, уже пришедшие по нему, остаются
+func RevokeInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var invite database.InviteCode
+		if err := database.DB.First(&invite, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+			return
+		}
+
+		if err := service.RevokeInviteCode(&invite); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+			return
+		}
+		c.JSON(http.StatusOK, newInviteView(invite))
+	}
+}
+
+// GET /api/users/:id/referrals — кого пригласил юзер
+func GetUserReferrals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		referrals := []database.User{}
+		database.DB.Where("referred_by_id = ?", user.ID).Order("id desc").Find(&referrals)
+
+		var bonus int64
+		database.DB.Model(&database.Payment{}).
+			Where("user_id = ? AND kind = ? AND status = ?", user.ID, service.PaymentKindReferral, service.PaymentPaid).
+			Select("COALESCE(SUM(credited_bytes), 0)").Scan(&bonus)
+
+		c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "referrals": referrals, "bonus_bytes": bonus})
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nodeInput — тело POST/PUT /api/nodes: agent_secret принимается, но в ответах не отдаётся
+type nodeInput struct {
+	database.Node
+	AgentSecret string `json:"agent_secret"`
+}
+
+// nodeView — нода в ответах API: вместо секрета агента только признак, что он задан
+type nodeView struct {
+	database.Node
+	AgentSecretSet bool `json:"agent_secret_set"`
+}
+
+func newNodeView(node database.Node) nodeView {
+	return nodeView{Node: node, AgentSecretSet: node.AgentSecret != ""}
+}
+
+func bindNodeInput(c *gin.Context) (database.Node, bool) {
+	var input nodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return database.Node{}, false
+	}
+	input.Node.AgentSecret = input.AgentSecret
+	trimNodeStrings(&input.Node)
+	return input.Node, true
+}
+
+func trimNodeStrings(input *database.Node) {
+	input.Name = strings.TrimSpace(input.Name)
+	input.Address = strings.TrimSpace(input.Address)
+	input.Region = strings.TrimSpace(input.Region)
+	input.SSHHost = strings.TrimSpace(input.SSHHost)
+	input.SSHUser = strings.TrimSpace(input.SSHUser)
+	input.SSHKeyPath = strings.TrimSpace(input.SSHKeyPath)
+	input.StatsAddr = strings.TrimSpace(input.StatsAddr)
+	input.AgentURL = strings.TrimRight(strings.TrimSpace(input.AgentURL), "/")
+	input.AgentSecret = strings.TrimSpace(input.AgentSecret)
+}
+
+// GET /api/nodes — список удалённых нод
+func GetNodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var nodes []database.Node
+		database.DB.Order("id").Find(&nodes)
+
+		result := make([]nodeView, 0, len(nodes))
+		for _, node := range nodes {
+			result = append(result, newNodeView(node))
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// POST /api/nodes — добавить ноду
+func CreateNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		input, ok := bindNodeInput(c)
+		if !ok {
+			return
+		}
+		if input.Name == "" || input.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name and address are required"})
+			return
+		}
+
+		var count int64
+		database.DB.Model(&database.Node{}).Where("name = ?", input.Name).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Node name already exists"})
+			return
+		}
+
+		input.ID = 0
+		if err := database.DB.Create(&input).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create node"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, newNodeView(input))
+	}
+}
+
+// PUT /api/nodes/:id — обновить ноду
+func UpdateNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var existing database.Node
+		if err := database.DB.First(&existing, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+
+		input, ok := bindNodeInput(c)
+		if !ok {
+			return
+		}
+		if input.Name != "" && input.Name != existing.Name {
+			var count int64
+			database.DB.Model(&database.Node{}).Where("name = ? AND id != ?", input.Name, existing.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Node name already exists"})
+				return
+			}
+		}
+
+		input.ID = existing.ID
+		database.DB.Model(&existing).Updates(input)
+
+		database.DB.First(&existing, existing.ID)
+
+		service.RequestReload(reloadTrigger(c))
+
+		c.JSON(http.StatusOK, newNodeView(existing))
+	}
+}
+
+// PUT /api/nodes/:id/toggle — включить/выключить ноду
+func ToggleNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var existing database.Node
+		if err := database.DB.First(&existing, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+
+		existing.Enabled = !existing.Enabled
+		database.DB.Model(&existing).Update("enabled", existing.Enabled)
+
+		service.RequestReload(reloadTrigger(c))
+
+		c.JSON(http.StatusOK, newNodeView(existing))
+	}
+}
+
+// DELETE /api/nodes/:id — удалить ноду (только без инбаундов)
+func DeleteNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var existing database.Node
+		if err := database.DB.First(&existing, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+
+		var count int64
+		database.DB.Model(&database.InboundConfig{}).Where("node_id = ?", existing.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Node has inbounds. Move or delete them first."})
+			return
+		}
+
+		database.DB.Delete(&existing)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Node deleted"})
+	}
+}
+
+// POST /api/nodes/:id/check — проверить доступ к ноде и состояние сервисов на ней
+func CheckNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var node database.Node
+		if err := database.DB.First(&node, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+
+		health, err := service.NewNodeClient(node).Health()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"reachable": false, "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"reachable": true, "health": health})
+	}
+}
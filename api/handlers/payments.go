@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/payments — платежи, новые первыми. Фильтры: ?user_id=&status=&provider=&limit=&offset=
+func GetPayments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := database.DB.Model(&database.Payment{})
+		if userID := c.Query("user_id"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if provider := c.Query("provider"); provider != "" {
+			query = query.Where("provider = ?", provider)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Count(&total)
+
+		payments := []database.Payment{}
+		query.Order("id desc").Limit(limit).Offset(offset).Find(&payments)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "payments": payments})
+	}
+}
+
+// POST /api/payments/:id/refund — вернуть оплату через провайдера и списать начисленное
+func RefundPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payment database.Payment
+		if err := database.DB.First(&payment, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+
+		var input struct {
+			Note string `json:"note"`
+		}
+		c.ShouldBindJSON(&input)
+
+		err := service.RefundPayment(&payment, input.Note)
+		if errors.Is(err, service.ErrPaymentNotPaid) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Refund failed: " + err.Error()})
+			return
+		}
+
+		database.DB.First(&payment, payment.ID)
+		c.JSON(http.StatusOK, payment)
+	}
+}
+
+// POST /api/users/:id/credit — ручное начисление: {"days": 30, "traffic_gb": 10, "note": "..."}
+func CreditUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			Days      int    `json:"days"`
+			TrafficGB int64  `json:"traffic_gb"`
+			Note      string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil || input.Days < 0 || input.TrafficGB < 0 || input.Days+int(input.TrafficGB) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: days or traffic_gb must be positive"})
+			return
+		}
+
+		payment, err := service.CreditUserManually(&user, input.Days, input.TrafficGB*1024*1024*1024, input.Note)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to credit user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"payment": payment, "user": user})
+	}
+}
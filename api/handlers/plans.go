@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/plans — тарифы с числом юзеров на каждом
+func GetPlans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plans []database.Plan
+		database.DB.Order("id").Find(&plans)
+
+		var counts []struct {
+			PlanID uint
+			Users  int64
+		}
+		database.DB.Model(&database.User{}).Select("plan_id, COUNT(*) AS users").
+			Where("plan_id > 0").Group("plan_id").Scan(&counts)
+		usersByPlan := map[uint]int64{}
+		for _, row := range counts {
+			usersByPlan[row.PlanID] = row.Users
+		}
+
+		result := make([]gin.H, 0, len(plans))
+		for _, p := range plans {
+			result = append(result, gin.H{"plan": p, "users": usersByPlan[p.ID]})
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// POST /api/plans — создать тариф
+func CreatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plan database.Plan
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		plan.ID = 0
+
+		if err := service.ValidatePlan(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var count int64
+		database.DB.Model(&database.Plan{}).Where("name = ?", plan.Name).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Plan with this name already exists"})
+			return
+		}
+
+		if err := database.DB.Create(&plan).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create plan"})
+			return
+		}
+		c.JSON(http.StatusCreated, plan)
+	}
+}
+
+// PUT /api/plans/:id — изменить тариф. Список инбаундов сразу действует на всех юзеров тарифа,
+// лимиты и срок — только при следующем назначении (у текущих юзеров они могли быть изменены вручную).
+func UpdatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plan database.Plan
+		if err := database.DB.First(&plan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			return
+		}
+
+		id := plan.ID
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		plan.ID = id
+
+		if err := service.ValidatePlan(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var count int64
+		database.DB.Model(&database.Plan{}).Where("name = ? AND id != ?", plan.Name, plan.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Plan with this name already exists"})
+			return
+		}
+
+		if err := database.DB.Save(&plan).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update plan"})
+			return
+		}
+		service.RequestReload(reloadTrigger(c))
+
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+// DELETE /api/plans/:id — удалить тариф, на котором нет юзеров
+func DeletePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plan database.Plan
+		if err := database.DB.First(&plan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			return
+		}
+
+		var users int64
+		database.DB.Model(&database.User{}).Where("plan_id = ?", plan.ID).Count(&users)
+		if users > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Plan is assigned to users, move them to another plan first", "users": users})
+			return
+		}
+
+		database.DB.Delete(&plan)
+		c.JSON(http.StatusOK, gin.H{"message": "Plan deleted"})
+	}
+}
+
+// PUT /api/users/:id/plan — назначить юзеру тариф ({"plan_id": 0} — снять тариф, лимиты остаются)
+func UpdateUserPlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			PlanID uint `json:"plan_id"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		if input.PlanID == 0 {
+			database.DB.Model(&user).Update("plan_id", 0)
+		} else {
+			var plan database.Plan
+			if err := database.DB.First(&plan, input.PlanID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+				return
+			}
+			if err := service.ApplyPlan(&user, plan); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign plan"})
+				return
+			}
+		}
+		service.RequestReload(reloadTrigger(c))
+
+		c.JSON(http.StatusOK, user)
+	}
+}
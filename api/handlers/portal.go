@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// portalUser находит юзера по токену подписки из :token, иначе отвечает 404
+func portalUser(c *gin.Context) (database.User, bool) {
+	var user database.User
+	token := c.Param("token")
+	if token == "" || database.DB.Where("subscription_token = ?", token).First(&user).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return user, false
+	}
+	return user, true
+}
+
+func qrBase64(content string) string {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(png)
+}
+
+// GET /portal/:token — личный кабинет юзера: статус, квота, срок, трафик по дням и ссылки.
+// Ссылки отдаются только активным юзерам. ?days= — глубина графика (по умолчанию 30, максимум 90).
+func GetPortal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := portalUser(c)
+		if !ok {
+			return
+		}
+
+		days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if days <= 0 || days > 90 {
+			days = 30
+		}
+		to := time.Now()
+		from := to.AddDate(0, 0, -days)
+
+		var remaining *int64
+		if user.TrafficLimit > 0 {
+			left := max(user.TrafficLimit-user.TrafficUsed, 0)
+			remaining = &left
+		}
+
+		response := gin.H{
+			"username":       user.Username,
+			"status":         user.Status,
+			"expired_reason": user.ExpiredReason,
+			"expiry_date":    user.ExpiryDate,
+			"quota": gin.H{
+				"limit":      user.TrafficLimit,
+				"used":       user.TrafficUsed,
+				"uplink":     user.TrafficUplink,
+				"downlink":   user.TrafficDownlink,
+				"remaining":  remaining,
+				"next_reset": service.NextTrafficReset(user),
+			},
+			"traffic": service.GetTrafficSeries(user.ID, "", from, to, service.GranularityDay),
+		}
+
+		if user.Status != "active" {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		subURL := service.SubscriptionURL(user.SubscriptionToken)
+		response["subscription"] = gin.H{"url": subURL, "qr_png_base64": qrBase64(subURL)}
+
+		serverIP := service.DefaultServerIP()
+		inbounds := []gin.H{}
+		for _, ib := range service.ClientInboundsFor(user) {
+			link := service.GenerateLinkForInbound(ib, user, serverIP)
+			inbounds = append(inbounds, gin.H{
+				"tag":           ib.Tag,
+				"display_name":  ib.DisplayName,
+				"protocol":      ib.Protocol,
+				"link":          link,
+				"qr_png_base64": qrBase64(link),
+			})
+		}
+		response["inbounds"] = inbounds
+
+		if link, err := service.ExistingTelemetLink(user); err == nil {
+			response["telemt"] = gin.H{"link": link, "qr_png_base64": qrBase64(link)}
+		}
+
+		var turn database.TurnConfig
+		if database.DB.First(&turn).Error == nil && turn.Enabled && turn.VKJoinLink != "" {
+			response["turn"] = gin.H{
+				"format":      "markdown_v2",
+				"instruction": service.GenerateTurnClientInstruction(serverIP, turn),
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// POST /portal/:token/rotate — юзер сам перевыпускает UUID, токен подписки и/или секрет прокси
+// (например, если ссылка утекла). Старый токен и адрес кабинета перестают работать.
+func RotatePortalCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := portalUser(c)
+		if !ok {
+			return
+		}
+		if user.Status == "banned" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is banned"})
+			return
+		}
+
+		var parts service.CredentialParts
+		if err := c.ShouldBindJSON(&parts); err != nil || !parts.Any() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Specify uuid, subscription_token and/or telemt_secret"})
+			return
+		}
+
+		if wait, ok := service.PortalRotateAllowed(user.ID); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many rotations, try again later"})
+			return
+		}
+
+		// Конфиги применяются в фоне: публичный эндпоинт не должен держать перезагрузку
+		if err := service.RotatePortalCredentials(&user, parts, "portal: rotate "+user.Username); err != nil {
+			log.Printf("Portal rotate for %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate credentials"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"subscription_url": service.SubscriptionURL(user.SubscriptionToken),
+			"portal_url":       service.PortalURL(user.SubscriptionToken),
+			"rotated":          parts,
+		})
+	}
+}
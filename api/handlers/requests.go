@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"vpnbot/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/access-requests — заявки на доступ, новые первыми. Фильтр: ?status=pending|approved|rejected|banned
+func GetAccessRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := database.DB.Model(&database.AccessRequest{})
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		limit, offset := pageParams(c)
+
+		var total int64
+		query.Count(&total)
+
+		requests := []database.AccessRequest{}
+		query.Order("updated_at desc").Limit(limit).Offset(offset).Find(&requests)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "requests": requests})
+	}
+}
+
+// DELETE /api/access-requests/:id — сбросить заявку (в том числе бан): заявитель сможет подать её заново
+func DeleteAccessRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req database.AccessRequest
+		if err := database.DB.First(&req, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+			return
+		}
+
+		database.DB.Delete(&req)
+		c.JSON(http.StatusOK, gin.H{"message": "Request deleted"})
+	}
+}
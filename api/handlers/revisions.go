@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/config/revisions — история конфигов без содержимого.
+// Фильтры: ?kind=singbox|telemt&node_id=&limit=&offset=
+func GetConfigRevisions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+
+		query := database.DB.Model(&database.ConfigRevision{}).Omit("content")
+		if kind := c.Query("kind"); kind != "" {
+			query = query.Where("kind = ?", kind)
+		}
+		if nodeID := c.Query("node_id"); nodeID != "" {
+			query = query.Where("node_id = ?", nodeID)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var revisions []database.ConfigRevision
+		query.Order("id desc").Limit(limit).Offset(offset).Find(&revisions)
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "revisions": revisions})
+	}
+}
+
+// GET /api/config/revisions/:id — ревизия с содержимым
+func GetConfigRevision() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rev database.ConfigRevision
+		if err := database.DB.First(&rev, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		c.JSON(http.StatusOK, rev)
+	}
+}
+
+// GET /api/config/revisions/:id/diff?against=<id> — unified diff от ревизии against к :id.
+// Без against сравнивается с предыдущей ревизией той же ноды и того же типа.
+func DiffConfigRevisions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var to database.ConfigRevision
+		if err := database.DB.First(&to, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+
+		var from database.ConfigRevision
+		if against := c.Query("against"); against != "" {
+			if err := database.DB.First(&from, against).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Revision to compare against not found"})
+				return
+			}
+		} else {
+			database.DB.Where("kind = ? AND node_id = ? AND id < ?", to.Kind, to.NodeID, to.ID).
+				Order("id desc").First(&from)
+		}
+
+		fromName := "/dev/null"
+		if from.ID != 0 {
+			fromName = fmt.Sprintf("revision #%d (%s)", from.ID, from.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		toName := fmt.Sprintf("revision #%d (%s)", to.ID, to.CreatedAt.Format("2006-01-02 15:04:05"))
+
+		c.JSON(http.StatusOK, gin.H{
+			"from": from.ID,
+			"to":   to.ID,
+			"diff": service.UnifiedDiff(from.Content, to.Content, fromName, toName),
+		})
+	}
+}
+
+// POST /api/config/revisions/:id/restore — выложить сохранённый конфиг обратно на ноду.
+// Действует до следующей перегенерации конфигов из БД.
+func RestoreConfigRevision() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rev database.ConfigRevision
+		if err := database.DB.First(&rev, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+
+		trigger := fmt.Sprintf("%s (revision #%d)", reloadTrigger(c), rev.ID)
+		if err := service.RestoreConfigRevision(rev, trigger); err != nil {
+			var checkErr *service.ConfigCheckError
+			if errors.As(err, &checkErr) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "sing-box rejected the config", "details": err.Error(), "rolled_back": checkErr.RolledBack})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore config", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Config restored. It will be replaced on the next regeneration from the database."})
+	}
+}
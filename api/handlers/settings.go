@@ -1,15 +1,27 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"vpnbot/api/middleware"
 	"vpnbot/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+// reloadTrigger описывает API-вызов для истории ревизий конфига, например "api(admin): PUT /api/inbounds/:id"
+func reloadTrigger(c *gin.Context) string {
+	return "api(" + c.GetString(middleware.ContextAdminUsername) + "): " + c.Request.Method + " " + c.FullPath()
+}
+
 func ReloadConfig() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if err := service.GenerateAndReload(); err != nil {
+		if err := service.ForceReload(reloadTrigger(c)); err != nil {
+			var checkErr *service.ConfigCheckError
+			if errors.As(err, &checkErr) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "sing-box rejected the config", "details": err.Error(), "rolled_back": checkErr.RolledBack})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload config", "details": err.Error()})
 			return
 		}
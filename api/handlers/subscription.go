@@ -1,10 +1,8 @@
 package handlers
 
 import (
-	"encoding/base64"
 	"fmt"
 	"os"
-	"strings"
 	"vpnbot/database"
 	"vpnbot/service"
 
@@ -31,19 +29,22 @@ func GetSubscription() gin.HandlerFunc {
 			serverIP = "49.13.201.110"
 		}
 
-		var inbounds []database.InboundConfig
-		database.DB.Where("enabled = ?", true).Order("sort_order").Find(&inbounds)
+		inbounds := service.ClientInboundsFor(user)
 
-		links := []string{}
-		for _, ib := range inbounds {
-			links = append(links, service.GenerateLinkForInbound(ib, user, serverIP))
+		format := service.DetectSubscriptionFormat(c.GetHeader("User-Agent"), c.Query("format"))
+		body, contentType, err := service.RenderSubscription(format, inbounds, user, serverIP)
+		if err != nil {
+			c.String(500, err.Error())
+			return
 		}
 
-		body := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+		userinfo := fmt.Sprintf("upload=%d; download=%d; total=%d", user.TrafficUplink, user.TrafficDownlink, user.TrafficLimit)
+		if expire := service.SubscriptionExpire(user); expire != nil {
+			userinfo += fmt.Sprintf("; expire=%d", expire.Unix())
+		}
 
-		c.Header("Content-Type", "text/plain")
 		c.Header("Profile-Update-Interval", "6")
-		c.Header("Subscription-Userinfo", fmt.Sprintf("upload=0; download=%d; total=%d", user.TrafficUsed, user.TrafficLimit))
-		c.String(200, body)
+		c.Header("Subscription-Userinfo", userinfo)
+		c.Data(200, contentType, body)
 	}
 }
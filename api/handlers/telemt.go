@@ -130,8 +130,10 @@ func GetTelemetUsers() gin.HandlerFunc {
 // POST /api/telemt/sync — принудительная синхронизация юзеров
 func SyncTelemetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		service.SyncTelemetUsers()
-		service.GenerateAndReloadTelemet()
+		if err := service.ReloadNow(reloadTrigger(c)); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(200, gin.H{"message": "Пользователи telemt синхронизированы"})
 	}
 }
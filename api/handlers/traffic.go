@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTrafficQuery разбирает ?from=&to=&granularity=. Даты — RFC3339 или YYYY-MM-DD.
+// По умолчанию: последние 24 часа по часам или последние 30 дней по суткам.
+func parseTrafficQuery(c *gin.Context) (from, to time.Time, granularity string, err error) {
+	granularity = c.DefaultQuery("granularity", service.GranularityHour)
+	if granularity != service.GranularityHour && granularity != service.GranularityDay {
+		return from, to, "", fmt.Errorf("granularity must be 'hour' or 'day'")
+	}
+
+	to = time.Now()
+	if v := c.Query("to"); v != "" {
+		if to, err = parseTimeParam(v); err != nil {
+			return from, to, "", fmt.Errorf("invalid 'to': %w", err)
+		}
+	}
+
+	if granularity == service.GranularityHour {
+		from = to.Add(-24 * time.Hour)
+	} else {
+		from = to.AddDate(0, 0, -30)
+	}
+	if v := c.Query("from"); v != "" {
+		if from, err = parseTimeParam(v); err != nil {
+			return from, to, "", fmt.Errorf("invalid 'from': %w", err)
+		}
+	}
+
+	if !from.Before(to) {
+		return from, to, "", fmt.Errorf("'from' must be before 'to'")
+	}
+	return from, to, granularity, nil
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// GET /api/users/:id/traffic — временной ряд трафика юзера
+func GetUserTraffic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		from, to, granularity, err := parseTrafficQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":     user.ID,
+			"from":        from,
+			"to":          to,
+			"granularity": granularity,
+			"points":      service.GetTrafficSeries(user.ID, "", from, to, granularity),
+		})
+	}
+}
+
+// GET /api/inbounds/:id/traffic — временной ряд трафика инбаунда (все юзеры)
+func GetInboundTraffic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ib database.InboundConfig
+		if err := database.DB.First(&ib, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Inbound not found"})
+			return
+		}
+
+		from, to, granularity, err := parseTrafficQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"inbound_tag": ib.Tag,
+			"from":        from,
+			"to":          to,
+			"granularity": granularity,
+			"points":      service.GetTrafficSeries(0, ib.Tag, from, to, granularity),
+		})
+	}
+}
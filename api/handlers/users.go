@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 	"vpnbot/bot"
 	"vpnbot/database"
 	"vpnbot/service"
@@ -41,11 +42,10 @@ func UpdateUserStatus() gin.HandlerFunc {
 			return
 		}
 
-		user.Status = input.Status
-		database.DB.Save(&user)
-		service.GenerateAndReload()
-		service.SyncTelemetUsers()
-		service.GenerateAndReloadTelemet()
+		if err := service.SetUserStatus(&user, input.Status, reloadTrigger(c)); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
 		c.JSON(200, user)
 	}
@@ -73,22 +73,267 @@ func UpdateUserLimit() gin.HandlerFunc {
 			return
 		}
 
-		user.TrafficLimit = input.Limit
+		if err := service.SetUserTrafficLimit(&user, input.Limit, reloadTrigger(c)); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, user)
+	}
+}
+
+// PUT /api/users/:id/expiry — задать, продлить или снять дату окончания подписки.
+// Ровно одно из: {"extend_days": 30} продлевает, {"expiry_date": "..."} задаёт дату, {"clear": true} снимает ограничение.
+func UpdateUserExpiry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		var user database.User
+		if err := database.DB.First(&user, id).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			ExpiryDate *time.Time `json:"expiry_date"`
+			ExtendDays int        `json:"extend_days"`
+			Clear      bool       `json:"clear"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+		if input.ExtendDays < 0 {
+			c.JSON(400, gin.H{"error": "extend_days must be positive"})
+			return
+		}
 
-		if user.Status == "expired" {
-			if user.TrafficLimit == 0 || user.TrafficUsed < user.TrafficLimit {
-				user.Status = "active"
-				service.GenerateAndReload()
-				service.SyncTelemetUsers()
-				service.GenerateAndReloadTelemet()
+		// Пустой запрос не должен молча снимать срок
+		given := 0
+		for _, set := range []bool{input.ExpiryDate != nil, input.ExtendDays > 0, input.Clear} {
+			if set {
+				given++
 			}
 		}
+		if given != 1 {
+			c.JSON(400, gin.H{"error": "Specify exactly one of expiry_date, extend_days or clear"})
+			return
+		}
+
+		switch {
+		case input.ExtendDays > 0:
+			err = service.ExtendUserExpiry(&user, input.ExtendDays)
+		case input.Clear:
+			err = service.SetUserExpiry(&user, nil)
+		default:
+			err = service.SetUserExpiry(&user, input.ExpiryDate)
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update expiry"})
+			return
+		}
+
+		c.JSON(200, user)
+	}
+}
+
+// PUT /api/users/:id/reset-policy — расписание сброса трафика
+func UpdateUserResetPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		var user database.User
+		if err := database.DB.First(&user, id).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			ResetPeriod string `json:"reset_period"`
+			ResetDay    int    `json:"reset_day"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		if err := service.ValidateResetPolicy(input.ResetPeriod, input.ResetDay); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
+		if input.ResetPeriod == "" {
+			input.ResetPeriod = service.ResetNone
+		}
+		user.ResetPeriod = input.ResetPeriod
+		user.ResetDay = input.ResetDay
 		database.DB.Save(&user)
+
+		c.JSON(200, gin.H{
+			"user":       user,
+			"next_reset": service.NextTrafficReset(user),
+		})
+	}
+}
+
+// POST /api/users/:id/reset-traffic — сбросить трафик вручную и начать новый период
+func ResetUserTraffic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		var user database.User
+		if err := database.DB.First(&user, id).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := service.ResetUserTraffic(&user); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to reset traffic"})
+			return
+		}
+
+		c.JSON(200, user)
+	}
+}
+
+// POST /api/users/:id/rotate — перевыпустить UUID, токен подписки и/или секрет telemt.
+// Тело: {"uuid": true, "subscription_token": true, "telemt_secret": true}; пустое тело — всё сразу.
+func RotateUserCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		var user database.User
+		if err := database.DB.First(&user, id).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		var parts service.CredentialParts
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&parts); err != nil {
+				c.JSON(400, gin.H{"error": "Invalid input"})
+				return
+			}
+		}
+		if !parts.Any() {
+			parts = service.AllCredentialParts
+		}
+
+		if err := service.RotateUserCredentials(&user, parts, reloadTrigger(c)); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Credentials rotated but config reload failed: " + err.Error(), "user": user})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"user":             user,
+			"rotated":          parts,
+			"subscription_url": service.SubscriptionURL(user.SubscriptionToken),
+		})
+	}
+}
+
+// PUT /api/users/:id/max-devices — лимит разных IP за окно (0 = без лимита)
+func UpdateUserMaxDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			MaxDevices int `json:"max_devices"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil || input.MaxDevices < 0 {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		database.DB.Model(&user).Update("max_devices", input.MaxDevices)
+		c.JSON(200, user)
+	}
+}
+
+// PUT /api/users/:id/notifications — отключить или включить предупреждения о трафике и сроке
+func UpdateUserNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		var input struct {
+			NotificationsOff bool `json:"notifications_off"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		database.DB.Model(&user).Update("notifications_off", input.NotificationsOff)
+		c.JSON(200, user)
+	}
+}
+
+// GET /api/users/:id/devices — IP юзера за текущее окно и состояние блокировки
+func GetUserDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"max_devices":     user.MaxDevices,
+			"suspended_until": user.SuspendedUntil,
+			"devices":         service.ActiveDevices(user.ID),
+		})
+	}
+}
+
+// DELETE /api/users/:id/suspension — досрочно снять блокировку по лимиту устройств
+func LiftUserSuspension() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user database.User
+		if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		service.LiftDeviceSuspension(user.ID)
+		service.RequestReload(reloadTrigger(c))
+		database.DB.First(&user, user.ID)
 		c.JSON(200, user)
 	}
 }
 
+// GET /api/users/:id/periods — архив расчётных периодов
+func GetUserTrafficPeriods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var periods []database.TrafficPeriod
+		database.DB.Where("user_id = ?", c.Param("id")).Order("period_end desc").Find(&periods)
+		c.JSON(200, periods)
+	}
+}
+
 func DeleteUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, err := strconv.Atoi(c.Param("id"))
@@ -104,9 +349,7 @@ func DeleteUser() gin.HandlerFunc {
 		}
 
 		database.DB.Delete(&user)
-		service.GenerateAndReload()
-		service.SyncTelemetUsers()
-		service.GenerateAndReloadTelemet()
+		service.RequestReload(reloadTrigger(c))
 
 		c.JSON(200, gin.H{"message": "User deleted"})
 	}
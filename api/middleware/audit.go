@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"vpnbot/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditBodyLimit — сколько байт тела запроса сохраняется в журнал
+const auditBodyLimit = 2048
+
+// auditSecretKeys — подстроки имён полей, значения которых в журнал не попадают
+var auditSecretKeys = []string{"password", "secret", "token", "key", "code", "private"}
+
+// Audit пишет в AuditEvent каждый мутирующий вызов API: кто, откуда, какой маршрут,
+// тело запроса без секретов и итоговый статус.
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		var raw []byte
+		if c.Request.Body != nil {
+			// Лимит — только на то, что попадёт в журнал; хендлер получает тело целиком
+			body := c.Request.Body
+			raw, _ = io.ReadAll(io.LimitReader(body, 1<<20))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(raw), body), body}
+		}
+
+		start := time.Now()
+		c.Next()
+
+		event := database.AuditEvent{
+			AccountID:  c.GetUint(ContextAdminID),
+			Username:   c.GetString(ContextAdminUsername),
+			IP:         c.ClientIP(),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			Path:       c.Request.URL.Path,
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		body, fields := redactAuditBody(raw)
+		event.Body = body
+		// До Auth (вход, refresh) пользователь известен только из тела запроса
+		if event.Username == "" {
+			if name, ok := fields["username"].(string); ok {
+				event.Username = strings.TrimSpace(name)
+			}
+		}
+		if event.Route == "" {
+			event.Route = event.Path
+		}
+
+		if err := database.DB.Create(&event).Error; err != nil {
+			log.Println("Failed to write audit event:", err)
+		}
+	}
+}
+
+// redactAuditBody заменяет секретные поля JSON на "***" и обрезает результат.
+// Вторым значением возвращает верхний уровень объекта (если тело — объект).
+func redactAuditBody(raw []byte) (string, map[string]interface{}) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return "", nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Sprintf("(non-JSON body, %d bytes)", len(raw)), nil
+	}
+	v = redactAuditValue(v)
+
+	out, _ := json.Marshal(v)
+	s := string(out)
+	if len(s) > auditBodyLimit {
+		s = s[:auditBodyLimit] + "…"
+	}
+	fields, _ := v.(map[string]interface{})
+	return s, fields
+}
+
+func redactAuditValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if isAuditSecretKey(k) {
+				t[k] = "***"
+				continue
+			}
+			t[k] = redactAuditValue(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactAuditValue(t[i])
+		}
+	}
+	return v
+}
+
+func isAuditSecretKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, s := range auditSecretKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
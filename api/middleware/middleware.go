@@ -3,7 +3,10 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"vpnbot/database"
+	"vpnbot/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -25,6 +28,22 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// AdminClaims — содержимое JWT админки: sub — ID аккаунта, jti — сессия, role — роль на момент входа
+type AdminClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Ключи gin.Context, которые Auth заполняет для следующих обработчиков
+const (
+	ContextSessionID     = "admin_session_id"
+	ContextAdminID       = "admin_id"
+	ContextAdminUsername = "admin_username"
+	ContextAdminRole     = "admin_role"
+)
+
+// Auth проверяет JWT и что аккаунт существует и не отключён.
+// Роль берётся из БД, чтобы её изменение действовало сразу, а не после перелогина.
 func Auth(jwtSecret []byte) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -34,7 +53,8 @@ func Auth(jwtSecret []byte) gin.HandlerFunc {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		claims := &AdminClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
@@ -46,6 +66,23 @@ func Auth(jwtSecret []byte) gin.HandlerFunc {
 			return
 		}
 
+		// jti должен принадлежать живой сессии — так отозванный или заменённый токен перестаёт работать
+		session, err := service.ActiveAdminSession(claims.ID)
+		if err != nil || claims.Subject != strconv.FormatUint(uint64(session.AccountID), 10) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			return
+		}
+
+		var account database.AdminAccount
+		if err := database.DB.First(&account, session.AccountID).Error; err != nil || account.Disabled {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Account not found or disabled"})
+			return
+		}
+
+		c.Set(ContextSessionID, session.ID)
+		c.Set(ContextAdminID, account.ID)
+		c.Set(ContextAdminUsername, account.Username)
+		c.Set(ContextAdminRole, account.Role)
 		c.Next()
 	}
 }
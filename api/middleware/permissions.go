@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"vpnbot/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission — право на группу маршрутов API
+type Permission string
+
+const (
+	PermUsersRead     Permission = "users:read"     // список юзеров, трафик, статистика
+	PermUsersWrite    Permission = "users:write"    // статус, лимиты, сроки, удаление
+	PermTrafficReset  Permission = "users:reset"    // ручной сброс квоты
+	PermInfraRead     Permission = "infra:read"     // инбаунды, ноды, сеть, telemt, TURN, история конфигов
+	PermInfraWrite    Permission = "infra:write"    // изменение всего перечисленного
+	PermAccountsAdmin Permission = "accounts:admin" // управление аккаунтами админки
+)
+
+// RolePermissions — права каждой роли
+var RolePermissions = map[string][]Permission{
+	service.RoleOwner:    {PermUsersRead, PermUsersWrite, PermTrafficReset, PermInfraRead, PermInfraWrite, PermAccountsAdmin},
+	service.RoleOperator: {PermUsersRead, PermUsersWrite, PermTrafficReset, PermInfraRead, PermInfraWrite},
+	service.RoleSupport:  {PermUsersRead, PermTrafficReset},
+}
+
+// HasPermission проверяет, есть ли у роли право perm
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range RolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Require пускает запрос дальше, только если у роли текущего аккаунта есть право perm.
+// Должен стоять после Auth.
+func Require(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c.GetString(ContextAdminRole), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required": perm})
+			return
+		}
+		c.Next()
+	}
+}
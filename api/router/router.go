@@ -13,73 +13,171 @@ func SetupRouter(r *gin.Engine) {
 	if len(jwtSecret) == 0 {
 		jwtSecret = []byte("default-secret-key-change-me")
 	}
+	botToken := os.Getenv("BOT_TOKEN")
 
 	r.Use(middleware.CORS())
 
 	api := r.Group("/api")
+	api.Use(middleware.Audit())
 	{
 		api.POST("/login", handlers.Login(jwtSecret))
+		api.POST("/login/telegram", handlers.TelegramLogin(jwtSecret, botToken))
+		api.POST("/login/magic", handlers.MagicLinkLogin(jwtSecret))
+		api.POST("/token/refresh", handlers.RefreshToken(jwtSecret))
 
 		auth := api.Group("/")
 		auth.Use(middleware.Auth(jwtSecret))
+
+		usersRead := middleware.Require(middleware.PermUsersRead)
+		usersWrite := middleware.Require(middleware.PermUsersWrite)
+		trafficReset := middleware.Require(middleware.PermTrafficReset)
+		infraRead := middleware.Require(middleware.PermInfraRead)
+		infraWrite := middleware.Require(middleware.PermInfraWrite)
+		accountsAdmin := middleware.Require(middleware.PermAccountsAdmin)
 		{
+			// Current account
+			auth.GET("/me", handlers.GetMe())
+			auth.PUT("/me/password", handlers.ChangeMyPassword())
+			auth.POST("/logout", handlers.Logout())
+			auth.POST("/logout-all", handlers.LogoutAll())
+			auth.GET("/me/sessions", handlers.GetMySessions())
+			auth.DELETE("/me/sessions/:id", handlers.RevokeMySession())
+			auth.POST("/me/totp/setup", handlers.SetupTOTP())
+			auth.POST("/me/totp/enable", handlers.EnableTOTP())
+			auth.POST("/me/totp/disable", handlers.DisableTOTP())
+			auth.POST("/me/totp/recovery-codes", handlers.RegenerateRecoveryCodes())
+
+			// Admin accounts
+			auth.GET("/accounts", accountsAdmin, handlers.GetAccounts())
+			auth.POST("/accounts", accountsAdmin, handlers.CreateAccount())
+			auth.PUT("/accounts/:id", accountsAdmin, handlers.UpdateAccount())
+			auth.DELETE("/accounts/:id", accountsAdmin, handlers.DeleteAccount())
+			auth.POST("/accounts/:id/revoke-sessions", accountsAdmin, handlers.RevokeAccountSessions())
+
+			// Audit log
+			auth.GET("/audit", accountsAdmin, handlers.GetAuditEvents())
+
 			// Users
-			auth.GET("/users", handlers.GetUsers())
-			auth.PUT("/users/:id/status", handlers.UpdateUserStatus())
-			auth.PUT("/users/:id/limit", handlers.UpdateUserLimit())
-			auth.DELETE("/users/:id", handlers.DeleteUser())
-			auth.POST("/users/sync", handlers.SyncUsers())
+			auth.GET("/users", usersRead, handlers.GetUsers())
+			auth.PUT("/users/:id/status", usersWrite, handlers.UpdateUserStatus())
+			auth.PUT("/users/:id/limit", usersWrite, handlers.UpdateUserLimit())
+			auth.PUT("/users/:id/expiry", usersWrite, handlers.UpdateUserExpiry())
+			auth.GET("/users/:id/traffic", usersRead, handlers.GetUserTraffic())
+			auth.PUT("/users/:id/reset-policy", usersWrite, handlers.UpdateUserResetPolicy())
+			auth.POST("/users/:id/reset-traffic", trafficReset, handlers.ResetUserTraffic())
+			auth.POST("/users/:id/rotate", usersWrite, handlers.RotateUserCredentials())
+			auth.PUT("/users/:id/max-devices", usersWrite, handlers.UpdateUserMaxDevices())
+			auth.PUT("/users/:id/notifications", usersWrite, handlers.UpdateUserNotifications())
+			auth.GET("/users/:id/devices", usersRead, handlers.GetUserDevices())
+			auth.DELETE("/users/:id/suspension", usersWrite, handlers.LiftUserSuspension())
+			auth.GET("/users/:id/connections", usersRead, handlers.GetUserConnections())
+			auth.PUT("/users/:id/plan", usersWrite, handlers.UpdateUserPlan())
+			auth.GET("/users/:id/periods", usersRead, handlers.GetUserTrafficPeriods())
+			auth.DELETE("/users/:id", usersWrite, handlers.DeleteUser())
+			auth.POST("/users/sync", usersWrite, handlers.SyncUsers())
+
+			// Plans
+			auth.GET("/plans", usersRead, handlers.GetPlans())
+			auth.POST("/plans", usersWrite, handlers.CreatePlan())
+			auth.PUT("/plans/:id", usersWrite, handlers.UpdatePlan())
+			auth.DELETE("/plans/:id", usersWrite, handlers.DeletePlan())
 
 			// Config reload
-			auth.POST("/reload", handlers.ReloadConfig())
+			auth.POST("/reload", infraWrite, handlers.ReloadConfig())
+
+			// Config history
+			auth.GET("/config/revisions", infraRead, handlers.GetConfigRevisions())
+			auth.GET("/config/revisions/:id", infraRead, handlers.GetConfigRevision())
+			auth.GET("/config/revisions/:id/diff", infraRead, handlers.DiffConfigRevisions())
+			auth.POST("/config/revisions/:id/restore", infraWrite, handlers.RestoreConfigRevision())
 
 			// Inbounds
-			auth.GET("/inbounds/sni-presets", handlers.GetSNIPresets())
-			auth.GET("/inbounds/rules", handlers.GetInboundRules())
-			auth.GET("/inbounds", handlers.GetInbounds())
-			auth.POST("/inbounds", handlers.CreateInbound())
-			auth.PUT("/inbounds/:id", handlers.UpdateInbound())
-			auth.DELETE("/inbounds/:id", handlers.DeleteInbound())
-			auth.PUT("/inbounds/:id/toggle", handlers.ToggleInbound())
-			auth.GET("/inbounds/validate-sni", handlers.ValidateSNI())
+			auth.GET("/inbounds/sni-presets", infraRead, handlers.GetSNIPresets())
+			auth.GET("/inbounds/rules", infraRead, handlers.GetInboundRules())
+			auth.GET("/inbounds", infraRead, handlers.GetInbounds())
+			auth.POST("/inbounds", infraWrite, handlers.CreateInbound())
+			auth.PUT("/inbounds/:id", infraWrite, handlers.UpdateInbound())
+			auth.DELETE("/inbounds/:id", infraWrite, handlers.DeleteInbound())
+			auth.PUT("/inbounds/:id/toggle", infraWrite, handlers.ToggleInbound())
+			auth.GET("/inbounds/:id/traffic", infraRead, handlers.GetInboundTraffic())
+			auth.GET("/inbounds/validate-sni", infraRead, handlers.ValidateSNI())
+
+			// Nodes (remote sing-box servers)
+			auth.GET("/nodes", infraRead, handlers.GetNodes())
+			auth.POST("/nodes", infraWrite, handlers.CreateNode())
+			auth.PUT("/nodes/:id", infraWrite, handlers.UpdateNode())
+			auth.DELETE("/nodes/:id", infraWrite, handlers.DeleteNode())
+			auth.PUT("/nodes/:id/toggle", infraWrite, handlers.ToggleNode())
+			auth.POST("/nodes/:id/check", infraWrite, handlers.CheckNode())
+
+			// Connection history
+			auth.GET("/connections", usersRead, handlers.GetConnections())
+
+			// Payments
+			auth.GET("/payments", usersRead, handlers.GetPayments())
+			auth.POST("/payments/:id/refund", usersWrite, handlers.RefundPayment())
+			auth.POST("/users/:id/credit", usersWrite, handlers.CreditUser())
+
+			// Invite codes and referrals
+			auth.GET("/invites", usersRead, handlers.GetInvites())
+			auth.POST("/invites", usersWrite, handlers.CreateInvite())
+			auth.DELETE("/invites/:id", usersWrite, handlers.RevokeInvite())
+			auth.GET("/users/:id/referrals", usersRead, handlers.GetUserReferrals())
+
+			// Broadcasts to bot users
+			auth.GET("/broadcasts", usersRead, handlers.GetBroadcasts())
+			auth.POST("/broadcasts", usersWrite, handlers.CreateBroadcast())
+			auth.GET("/broadcasts/:id", usersRead, handlers.GetBroadcast())
+			auth.GET("/broadcasts/:id/deliveries", usersRead, handlers.GetBroadcastDeliveries())
+			auth.POST("/broadcasts/:id/preview", usersWrite, handlers.PreviewBroadcast())
+			auth.POST("/broadcasts/:id/send", usersWrite, handlers.SendBroadcast())
+			auth.POST("/broadcasts/:id/cancel", usersWrite, handlers.CancelBroadcast())
+
+			// Access requests from the bot
+			auth.GET("/access-requests", usersRead, handlers.GetAccessRequests())
+			auth.DELETE("/access-requests/:id", usersWrite, handlers.DeleteAccessRequest())
 
 			// Stats
-			auth.GET("/stats", handlers.GetStats())
+			auth.GET("/stats", usersRead, handlers.GetStats())
 
 			// Network (Firewall + Port Forwarding + Connectivity)
-			auth.GET("/network/status", handlers.GetNetworkStatus())
-			auth.GET("/network/firewall/info", handlers.GetFirewallInfo())
-			auth.GET("/network/firewall/rules", handlers.GetFirewallRules())
-			auth.POST("/network/firewall/rules", handlers.OpenFirewallPort())
-			auth.DELETE("/network/firewall/rules", handlers.CloseFirewallPort())
-			auth.GET("/network/forwards/info", handlers.GetPortForwardInfo())
-			auth.GET("/network/forwards/rules", handlers.GetForwardRules())
-			auth.POST("/network/forwards/rules", handlers.AddForwardRule())
-			auth.DELETE("/network/forwards/rules", handlers.RemoveForwardRule())
-			auth.POST("/network/ping", handlers.PingPort())
-			auth.GET("/network/check-all", handlers.CheckAllPorts())
+			auth.GET("/network/status", infraRead, handlers.GetNetworkStatus())
+			auth.GET("/network/firewall/info", infraRead, handlers.GetFirewallInfo())
+			auth.GET("/network/firewall/rules", infraRead, handlers.GetFirewallRules())
+			auth.POST("/network/firewall/rules", infraWrite, handlers.OpenFirewallPort())
+			auth.DELETE("/network/firewall/rules", infraWrite, handlers.CloseFirewallPort())
+			auth.GET("/network/forwards/info", infraRead, handlers.GetPortForwardInfo())
+			auth.GET("/network/forwards/rules", infraRead, handlers.GetForwardRules())
+			auth.POST("/network/forwards/rules", infraWrite, handlers.AddForwardRule())
+			auth.DELETE("/network/forwards/rules", infraWrite, handlers.RemoveForwardRule())
+			auth.POST("/network/ping", infraWrite, handlers.PingPort())
+			auth.GET("/network/check-all", infraRead, handlers.CheckAllPorts())
 
 			// VK TURN Tunnel
-			auth.GET("/turn/config", handlers.GetTurnConfig())
-			auth.PUT("/turn/config", handlers.UpdateTurnConfig())
-			auth.POST("/turn/setup", handlers.SetupTurn())
-			auth.POST("/turn/start", handlers.StartTurn())
-			auth.POST("/turn/stop", handlers.StopTurn())
-			auth.GET("/turn/status", handlers.GetTurnStatus())
-			auth.POST("/turn/create-call", handlers.CreateVKCall())
-			auth.POST("/turn/test-creds", handlers.TestTurnCreds())
+			auth.GET("/turn/config", infraRead, handlers.GetTurnConfig())
+			auth.PUT("/turn/config", infraWrite, handlers.UpdateTurnConfig())
+			auth.POST("/turn/setup", infraWrite, handlers.SetupTurn())
+			auth.POST("/turn/start", infraWrite, handlers.StartTurn())
+			auth.POST("/turn/stop", infraWrite, handlers.StopTurn())
+			auth.GET("/turn/status", infraRead, handlers.GetTurnStatus())
+			auth.POST("/turn/create-call", infraWrite, handlers.CreateVKCall())
+			auth.POST("/turn/test-creds", infraWrite, handlers.TestTurnCreds())
 
 			// Telemt (MTProto proxy)
-			auth.GET("/telemt/config", handlers.GetTelemetConfig())
-			auth.POST("/telemt/config", handlers.UpdateTelemetConfig())
-			auth.POST("/telemt/setup", handlers.SetupTelemet())
-			auth.POST("/telemt/stop", handlers.StopTelemet())
-			auth.GET("/telemt/status", handlers.GetTelemetStatus())
-			auth.GET("/telemt/users", handlers.GetTelemetUsers())
-			auth.POST("/telemt/sync", handlers.SyncTelemetUsers())
+			auth.GET("/telemt/config", infraRead, handlers.GetTelemetConfig())
+			auth.POST("/telemt/config", infraWrite, handlers.UpdateTelemetConfig())
+			auth.POST("/telemt/setup", infraWrite, handlers.SetupTelemet())
+			auth.POST("/telemt/stop", infraWrite, handlers.StopTelemet())
+			auth.GET("/telemt/status", infraRead, handlers.GetTelemetStatus())
+			auth.GET("/telemt/users", infraRead, handlers.GetTelemetUsers())
+			auth.POST("/telemt/sync", infraWrite, handlers.SyncTelemetUsers())
 		}
 	}
 
 	// Public subscription endpoint
 	r.GET("/sub/:token", handlers.GetSubscription())
+
+	// Public user portal (доступ по токену подписки)
+	r.GET("/portal/:token", handlers.GetPortal())
+	r.POST("/portal/:token/rotate", handlers.RotatePortalCredentials())
 }
@@ -0,0 +1,507 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// adminPageSize — юзеров на странице списка в /admin
+const adminPageSize = 8
+
+// adminFilters — фильтры списка юзеров по статусу
+var adminFilters = []struct {
+	Code, Label, Status string
+}{
+	{"all", "Все", ""},
+	{"active", "🟢 Активные", "active"},
+	{"expired", "🟡 Истёкшие", "expired"},
+	{"banned", "🔴 Бан", "banned"},
+}
+
+// Готовые значения для лимита (GB, 0 — безлимит) и продления (дни, 0 — снять срок)
+var (
+	adminLimitPresets  = []int64{10, 30, 50, 100, 0}
+	adminExpiryPresets = []int{7, 30, 90, 0}
+)
+
+// adminActions — действия над юзером, требующие подтверждения
+var adminActions = map[string]string{
+	"ban":    "🚫 Забанить",
+	"unban":  "✅ Разбанить",
+	"reset":  "🔄 Сбросить трафик",
+	"rotate": "🔐 Перевыпустить ключи",
+}
+
+// cardRef — юзер и страница списка, куда вернуться из карточки. В кнопках: id|filter|page
+type cardRef struct {
+	ID     uint
+	Filter string
+	Page   int
+}
+
+func (r cardRef) args() []string {
+	return []string{strconv.FormatUint(uint64(r.ID), 10), r.Filter, strconv.Itoa(r.Page)}
+}
+
+func parseCardRef(args []string) (cardRef, bool) {
+	if len(args) < 3 {
+		return cardRef{}, false
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	page, err2 := strconv.Atoi(args[2])
+	if err != nil || err2 != nil {
+		return cardRef{}, false
+	}
+	return cardRef{ID: uint(id), Filter: args[1], Page: page}, true
+}
+
+// setupAdminConsole регистрирует /admin: списки юзеров, поиск и карточки с действиями.
+// Действия вызывают те же функции service, что и /api/users.
+func setupAdminConsole(b *tele.Bot) {
+	b.Handle("/admin", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return nil
+		}
+		text, rm := adminHome()
+		return c.Send(text, rm, tele.ModeMarkdown)
+	})
+
+	// /find <@username|ID|часть имени> — поиск юзера
+	b.Handle("/find", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return nil
+		}
+		query := strings.TrimSpace(c.Message().Payload)
+		if query == "" {
+			return c.Send("Использование: `/find <@username | ID | часть имени>`", tele.ModeMarkdown)
+		}
+
+		users := findUsers(query)
+		switch len(users) {
+		case 0:
+			return c.Send("🔍 Никого не нашлось.")
+		case 1:
+			text, rm := adminUserCard(users[0], cardRef{ID: users[0].ID, Filter: "all"})
+			return c.Send(text, rm, tele.ModeMarkdown)
+		}
+
+		rm := &tele.ReplyMarkup{}
+		rows := []tele.Row{}
+		for _, u := range users {
+			ref := cardRef{ID: u.ID, Filter: "all"}
+			rows = append(rows, rm.Row(rm.Data(userButtonLabel(u), "adm_user", ref.args()...)))
+		}
+		rm.Inline(rows...)
+		return c.Send(fmt.Sprintf("🔍 Найдено: %d", len(users)), rm)
+	})
+
+	handleAdmin := func(unique string, fn func(c tele.Context) error) {
+		b.Handle(&tele.Btn{Unique: unique}, func(c tele.Context) error {
+			if c.Sender().ID != AdminID {
+				return c.Respond(&tele.CallbackResponse{Text: "⛔ Только для администратора"})
+			}
+			return fn(c)
+		})
+	}
+
+	// handleCard — кнопки карточки: юзер по id из данных кнопки
+	handleCard := func(unique string, fn func(c tele.Context, user database.User, ref cardRef) error) {
+		handleAdmin(unique, func(c tele.Context) error {
+			ref, ok := parseCardRef(c.Args())
+			if !ok {
+				return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+			}
+			var user database.User
+			if err := database.DB.First(&user, ref.ID).Error; err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: "Пользователь не найден"})
+			}
+			return fn(c, user, ref)
+		})
+	}
+
+	handleAdmin("adm_noop", func(c tele.Context) error {
+		return c.Respond()
+	})
+
+	handleAdmin("adm_home", func(c tele.Context) error {
+		c.Respond()
+		text, rm := adminHome()
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	handleAdmin("adm_search", func(c tele.Context) error {
+		return c.Respond(&tele.CallbackResponse{Text: "Отправьте /find @username, ID или часть имени", ShowAlert: true})
+	})
+
+	handleAdmin("adm_pending", func(c tele.Context) error {
+		c.Respond()
+		return sendPendingRequests(c)
+	})
+
+	handleAdmin("adm_users", func(c tele.Context) error {
+		args := c.Args()
+		filter, page := "all", 0
+		if len(args) == 2 {
+			filter = args[0]
+			page, _ = strconv.Atoi(args[1])
+		}
+		c.Respond()
+		text, rm := adminUserList(filter, page)
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_user", func(c tele.Context, user database.User, ref cardRef) error {
+		c.Respond()
+		text, rm := adminUserCard(user, ref)
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	// Подтверждение действия: adm_ask|id|filter|page|action
+	handleCard("adm_ask", func(c tele.Context, user database.User, ref cardRef) error {
+		args := c.Args()
+		if len(args) != 4 || adminActions[args[3]] == "" {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+		action := args[3]
+		c.Respond()
+
+		text, _ := adminUserCard(user, ref)
+		rm := &tele.ReplyMarkup{}
+		rm.Inline(rm.Row(
+			rm.Data("✅ Да", "adm_do", append(ref.args(), action)...),
+			rm.Data("↩️ Отмена", "adm_user", ref.args()...),
+		))
+		return c.Edit(text+"\n\nПодтвердите: "+adminActions[action], rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_do", func(c tele.Context, user database.User, ref cardRef) error {
+		args := c.Args()
+		if len(args) != 4 {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+		trigger := fmt.Sprintf("bot admin: %s %s", args[3], user.Username)
+
+		var err error
+		switch args[3] {
+		case "ban":
+			err = service.SetUserStatus(&user, "banned", trigger)
+		case "unban":
+			err = service.SetUserStatus(&user, "active", trigger)
+		case "reset":
+			err = service.ResetUserTraffic(&user)
+		case "rotate":
+			err = service.RotateUserCredentials(&user, service.AllCredentialParts, trigger)
+		default:
+			return c.Respond(&tele.CallbackResponse{Text: "Неизвестное действие"})
+		}
+		if err != nil {
+			log.Printf("Bot admin %s for %s failed: %v", args[3], user.Username, err)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка: " + err.Error(), ShowAlert: true})
+		}
+
+		c.Respond(&tele.CallbackResponse{Text: "✅ Готово"})
+		database.DB.First(&user, user.ID)
+		text, rm := adminUserCard(user, ref)
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_limit", func(c tele.Context, user database.User, ref cardRef) error {
+		c.Respond()
+		rm := &tele.ReplyMarkup{}
+		buttons := []tele.Btn{}
+		for _, gb := range adminLimitPresets {
+			label := fmt.Sprintf("%d GB", gb)
+			if gb == 0 {
+				label = "∞"
+			}
+			buttons = append(buttons, rm.Data(label, "adm_setlimit", append(ref.args(), strconv.FormatInt(gb, 10))...))
+		}
+		rm.Inline(rm.Row(buttons...), rm.Row(rm.Data("↩️ Назад", "adm_user", ref.args()...)))
+
+		text, _ := adminUserCard(user, ref)
+		return c.Edit(text+"\n\nНовый лимит трафика (другое значение — в веб-панели):", rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_setlimit", func(c tele.Context, user database.User, ref cardRef) error {
+		args := c.Args()
+		gb, err := strconv.ParseInt(args[len(args)-1], 10, 64)
+		if len(args) != 4 || err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+		if err := service.SetUserTrafficLimit(&user, gb*1024*1024*1024, "bot admin: limit "+user.Username); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка: " + err.Error(), ShowAlert: true})
+		}
+		c.Respond(&tele.CallbackResponse{Text: "✅ Лимит изменён"})
+		text, rm := adminUserCard(user, ref)
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_expiry", func(c tele.Context, user database.User, ref cardRef) error {
+		c.Respond()
+		rm := &tele.ReplyMarkup{}
+		buttons := []tele.Btn{}
+		for _, days := range adminExpiryPresets {
+			label := fmt.Sprintf("+%d дн.", days)
+			if days == 0 {
+				label = "Бессрочно"
+			}
+			buttons = append(buttons, rm.Data(label, "adm_setexp", append(ref.args(), strconv.Itoa(days))...))
+		}
+		rm.Inline(rm.Row(buttons...), rm.Row(rm.Data("↩️ Назад", "adm_user", ref.args()...)))
+
+		text, _ := adminUserCard(user, ref)
+		return c.Edit(text+"\n\nПродлить подписку (точная дата — `/expiry`):", rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_setexp", func(c tele.Context, user database.User, ref cardRef) error {
+		args := c.Args()
+		days, err := strconv.Atoi(args[len(args)-1])
+		if len(args) != 4 || err != nil || days < 0 {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+		if days == 0 {
+			err = service.SetUserExpiry(&user, nil)
+		} else {
+			err = service.ExtendUserExpiry(&user, days)
+		}
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка: " + err.Error(), ShowAlert: true})
+		}
+		c.Respond(&tele.CallbackResponse{Text: "✅ Подписка: " + formatExpiry(user.ExpiryDate)})
+		text, rm := adminUserCard(user, ref)
+		return c.Edit(text, rm, tele.ModeMarkdown)
+	})
+
+	handleCard("adm_usage", func(c tele.Context, user database.User, ref cardRef) error {
+		c.Respond()
+		rm := &tele.ReplyMarkup{}
+		rm.Inline(rm.Row(rm.Data("↩️ Назад", "adm_user", ref.args()...)))
+		return c.Edit(adminUsageText(user), rm, tele.ModeMarkdown)
+	})
+}
+
+// adminHome — главный экран /admin
+func adminHome() (string, *tele.ReplyMarkup) {
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	database.DB.Model(&database.User{}).Select("status, COUNT(*) AS count").Group("status").Scan(&counts)
+	byStatus := map[string]int64{}
+	var total int64
+	for _, row := range counts {
+		byStatus[row.Status] = row.Count
+		total += row.Count
+	}
+	_, pending := service.PendingAccessRequests(0)
+
+	text := fmt.Sprintf("🛠 **Админ-панель**\n\n👥 Юзеров: %d (🟢 %d, 🟡 %d, 🔴 %d)\n📝 Заявок в очереди: %d",
+		total, byStatus["active"], byStatus["expired"], byStatus["banned"], pending)
+
+	rm := &tele.ReplyMarkup{}
+	rm.Inline(
+		rm.Row(rm.Data("👥 Пользователи", "adm_users", "all", "0"), rm.Data("🔍 Поиск", "adm_search")),
+		rm.Row(rm.Data(fmt.Sprintf("📝 Заявки (%d)", pending), "adm_pending")),
+	)
+	return text, rm
+}
+
+// adminUserList — страница списка юзеров с фильтром по статусу
+func adminUserList(filter string, page int) (string, *tele.ReplyMarkup) {
+	label, status := adminFilters[0].Label, ""
+	for _, f := range adminFilters {
+		if f.Code == filter {
+			label, status = f.Label, f.Status
+		}
+	}
+	if status == "" {
+		filter = "all"
+	}
+
+	query := database.DB.Model(&database.User{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+
+	pages := max(int((total+adminPageSize-1)/adminPageSize), 1)
+	page = min(max(page, 0), pages-1)
+
+	var users []database.User
+	query.Order("id desc").Limit(adminPageSize).Offset(page * adminPageSize).Find(&users)
+
+	rm := &tele.ReplyMarkup{}
+	rows := []tele.Row{}
+	for _, u := range users {
+		ref := cardRef{ID: u.ID, Filter: filter, Page: page}
+		rows = append(rows, rm.Row(rm.Data(userButtonLabel(u), "adm_user", ref.args()...)))
+	}
+
+	filters := []tele.Btn{}
+	for _, f := range adminFilters {
+		text := f.Label
+		if f.Code == filter {
+			text = "• " + text
+		}
+		filters = append(filters, rm.Data(text, "adm_users", f.Code, "0"))
+	}
+	rows = append(rows, rm.Row(filters...))
+
+	nav := []tele.Btn{}
+	if page > 0 {
+		nav = append(nav, rm.Data("◀️", "adm_users", filter, strconv.Itoa(page-1)))
+	}
+	nav = append(nav, rm.Data(fmt.Sprintf("%d/%d", page+1, pages), "adm_noop"))
+	if page < pages-1 {
+		nav = append(nav, rm.Data("▶️", "adm_users", filter, strconv.Itoa(page+1)))
+	}
+	rows = append(rows, rm.Row(nav...), rm.Row(rm.Data("🏠 Меню", "adm_home")))
+	rm.Inline(rows...)
+
+	return fmt.Sprintf("👥 **Пользователи** — %s: %d", label, total), rm
+}
+
+// adminUserCard — карточка юзера с кнопками действий
+func adminUserCard(user database.User, ref cardRef) (string, *tele.ReplyMarkup) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "👤 **%s**", escapeMarkdown(user.Username))
+	if user.TelegramUsername != "" {
+		fmt.Fprintf(&sb, " (@%s)", escapeMarkdown(user.TelegramUsername))
+	}
+	fmt.Fprintf(&sb, "\nID: %d · Telegram: `%d`", user.ID, user.TelegramID)
+
+	status := statusIcon(user.Status) + " " + user.Status
+	if user.ExpiredReason != "" {
+		status += " (" + user.ExpiredReason + ")"
+	}
+	fmt.Fprintf(&sb, "\nСтатус: %s", status)
+
+	if user.PlanID != 0 {
+		var plan database.Plan
+		if database.DB.First(&plan, user.PlanID).Error == nil {
+			fmt.Fprintf(&sb, "\nТариф: %s", escapeMarkdown(plan.Name))
+		}
+	}
+
+	limit := "∞"
+	if user.TrafficLimit > 0 {
+		limit = formatBytes(user.TrafficLimit)
+	}
+	fmt.Fprintf(&sb, "\nТрафик: %s / %s", formatBytes(user.TrafficUsed), limit)
+	if next := service.NextTrafficReset(user); next != nil {
+		fmt.Fprintf(&sb, " (сброс %s)", next.Format("02.01.2006"))
+	}
+	fmt.Fprintf(&sb, "\nПодписка: %s", formatExpiry(user.ExpiryDate))
+
+	devices := len(service.ActiveDevices(user.ID))
+	if user.MaxDevices > 0 {
+		fmt.Fprintf(&sb, "\nУстройства: %d из %d", devices, user.MaxDevices)
+	} else {
+		fmt.Fprintf(&sb, "\nУстройства: %d", devices)
+	}
+	if user.SuspendedUntil != nil && user.SuspendedUntil.After(time.Now()) {
+		fmt.Fprintf(&sb, "\n⏸ Приостановлен до %s (лимит устройств)", user.SuspendedUntil.Format("15:04 02.01"))
+	}
+	if user.ReferredByID != 0 {
+		var referrer database.User
+		if database.DB.First(&referrer, user.ReferredByID).Error == nil {
+			fmt.Fprintf(&sb, "\nПригласил: %s", escapeMarkdown(referrer.Username))
+		}
+	}
+	fmt.Fprintf(&sb, "\nСоздан: %s", user.CreatedAt.Format("02.01.2006"))
+
+	args := ref.args()
+	ask := func(action string) tele.Btn {
+		return (&tele.ReplyMarkup{}).Data(adminActions[action], "adm_ask", append(ref.args(), action)...)
+	}
+	banBtn := ask("ban")
+	if user.Status == "banned" {
+		banBtn = ask("unban")
+	}
+
+	rm := &tele.ReplyMarkup{}
+	rm.Inline(
+		rm.Row(banBtn, ask("reset")),
+		rm.Row(rm.Data("📶 Лимит", "adm_limit", args...), rm.Data("📅 Срок", "adm_expiry", args...)),
+		rm.Row(ask("rotate"), rm.Data("📈 Трафик", "adm_usage", args...)),
+		rm.Row(rm.Data("🔄 Обновить", "adm_user", args...), rm.Data("↩️ К списку", "adm_users", ref.Filter, strconv.Itoa(ref.Page))),
+	)
+	return sb.String(), rm
+}
+
+// adminUsageText — трафик юзера по дням за неделю и текущие устройства
+func adminUsageText(user database.User) string {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -6)
+	series := service.GetTrafficSeries(user.ID, "", from, now, service.GranularityDay)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📈 **Трафик %s за 7 дней**\n", escapeMarkdown(user.Username))
+	var total int64
+	for _, p := range series {
+		if p.Total == 0 {
+			continue
+		}
+		total += p.Total
+		fmt.Fprintf(&sb, "\n%s — %s", p.BucketStart.Local().Format("02.01"), formatBytes(p.Total))
+	}
+	if total == 0 {
+		sb.WriteString("\nТрафика не было.")
+	} else {
+		fmt.Fprintf(&sb, "\n\nВсего: %s", formatBytes(total))
+	}
+
+	if devices := service.ActiveDevices(user.ID); len(devices) > 0 {
+		sb.WriteString("\n\n📱 **Сейчас подключены:**")
+		for _, d := range devices {
+			fmt.Fprintf(&sb, "\n`%s` — %s", d.IP, d.LastSeen.Format("15:04"))
+		}
+	}
+	return sb.String()
+}
+
+// findUsers ищет юзеров по @username, ID (юзера или Telegram) или части имени
+func findUsers(query string) []database.User {
+	var users []database.User
+	if id, err := strconv.ParseInt(query, 10, 64); err == nil {
+		database.DB.Where("id = ? OR telegram_id = ?", id, id).Limit(adminPageSize).Find(&users)
+		return users
+	}
+	like := "%" + strings.TrimPrefix(query, "@") + "%"
+	database.DB.Where("telegram_username LIKE ? OR username LIKE ?", like, like).
+		Order("id desc").Limit(adminPageSize).Find(&users)
+	return users
+}
+
+func statusIcon(status string) string {
+	switch status {
+	case "active":
+		return "🟢"
+	case "expired":
+		return "🟡"
+	case "banned":
+		return "🔴"
+	}
+	return "⚪"
+}
+
+// userButtonLabel — строка юзера в списке: статус, имя и трафик
+func userButtonLabel(u database.User) string {
+	name := u.Username
+	if u.TelegramUsername != "" {
+		name += " @" + u.TelegramUsername
+	}
+	limit := "∞"
+	if u.TrafficLimit > 0 {
+		limit = formatBytes(u.TrafficLimit)
+	}
+	return fmt.Sprintf("%s %s · %s/%s", statusIcon(u.Status), name, formatBytes(u.TrafficUsed), limit)
+}
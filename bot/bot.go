@@ -2,6 +2,7 @@ package bot
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,7 +12,6 @@ import (
 	"vpnbot/database"
 	"vpnbot/service"
 
-	"github.com/google/uuid"
 	"github.com/skip2/go-qrcode"
 	tele "gopkg.in/telebot.v3"
 )
@@ -43,6 +43,27 @@ func Start(token string, adminID int64) {
 
 	// Сохраняем экземпляр бота в глобальную переменную
 	Bot = b
+	service.SetBotUsername(b.Me.Username)
+
+	// Уведомления из сервисного слоя (блокировки входа, лимиты и т.п.)
+	service.SetNotifier(
+		func(text string) {
+			if AdminID == 0 {
+				return
+			}
+			if _, err := b.Send(&tele.User{ID: AdminID}, text); err != nil {
+				log.Println("Failed to notify admin:", err)
+			}
+		},
+		func(telegramID int64, text string) {
+			if _, err := b.Send(&tele.User{ID: telegramID}, text); err != nil {
+				if errors.Is(err, tele.ErrBlockedByUser) {
+					service.MarkBotBlocked(telegramID)
+				}
+				log.Printf("Failed to notify user %d: %v", telegramID, err)
+			}
+		},
+	)
 
 	// --- Menus ---
 
@@ -51,7 +72,8 @@ func Start(token string, adminID int64) {
 	btnStatus := menu.Text("📊 Статус")
 	btnConnect := menu.Text("🔑 Подключиться")
 	btnHelp := menu.Text("🆘 Помощь")
-	menu.Reply(menu.Row(btnStatus, btnConnect), menu.Row(btnHelp))
+	btnPay := menu.Text("💳 Оплата")
+	menu.Reply(menu.Row(btnStatus, btnConnect), menu.Row(btnPay, btnHelp))
 
 	// Гостевое меню
 	guestMenu := &tele.ReplyMarkup{ResizeKeyboard: true}
@@ -61,6 +83,11 @@ func Start(token string, adminID int64) {
 
 	// --- Handlers ---
 
+	setupPayments(b, btnPay)
+	setupInvites(b)
+	setupAdminConsole(b)
+	setupBroadcasts(b)
+
 	checkStatus := func(c tele.Context) error {
 		var user database.User
 		result := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user)
@@ -74,12 +101,37 @@ func Start(token string, adminID int64) {
 					return c.Send("✅ Ваш профиль администратора успешно привязан!", menu)
 				}
 			}
+
+			// /start <код> — ссылка-приглашение заводит юзера без заявки
+			if code := strings.TrimSpace(c.Message().Payload); code != "" {
+				if _, err := service.RedeemInviteCode(code, c.Sender().ID, c.Sender().Username); err != nil {
+					return sendInviteError(c, err, guestMenu)
+				}
+				return c.Send("🎉 **Доступ открыт по приглашению!**\n\nТеперь вы можете пользоваться VPN. Нажмите кнопку ниже, чтобы подключиться.", menu, tele.ModeMarkdown)
+			}
+
+			var req database.AccessRequest
+			if database.DB.Where("telegram_id = ?", c.Sender().ID).First(&req).Error == nil {
+				switch req.Status {
+				case service.RequestPending:
+					return c.Send("⏳ Ваша заявка на рассмотрении.\nМы сообщим, когда администратор примет решение.", guestMenu)
+				case service.RequestBanned:
+					return c.Send("⛔ Ваш доступ заблокирован.")
+				case service.RequestRejected:
+					if service.AccessRequestRetryAt(req).After(time.Now()) {
+						return c.Send(rejectionText(req), guestMenu)
+					}
+				}
+			}
 			return c.Send("👋 Вы не зарегистрированы в системе.\n\nНажмите **📝 Подать заявку**, чтобы запросить доступ.", guestMenu)
 		}
 
 		if user.Status == "banned" {
 			return c.Send("⛔ Ваш доступ заблокирован.")
 		}
+		if user.BotBlockedAt != nil {
+			service.ClearBotBlocked(user.TelegramID)
+		}
 
 		return c.Send("✅ Выберите действие:", menu)
 	}
@@ -93,28 +145,24 @@ func Start(token string, adminID int64) {
 			return c.Send("✅ У вас уже есть доступ!", menu)
 		}
 
-		// Красивое имя пользователя в уведомлении админу
-		userLink := c.Sender().Username
-		if userLink == "" {
-			firstName := escapeMarkdown(c.Sender().FirstName)
-			userLink = fmt.Sprintf("[%s](tg://user?id=%d)", firstName, c.Sender().ID)
-		} else {
-			userLink = "@" + escapeMarkdown(userLink)
+		req, result, err := service.SubmitAccessRequest(c.Sender().ID, c.Sender().Username, c.Sender().FirstName)
+		if err != nil {
+			log.Println("Failed to save access request:", err)
+			return c.Send("❌ Ошибка отправки заявки, попробуйте позже.")
 		}
 
-		msg := fmt.Sprintf("🔔 *Новая заявка!*\nUser: %s\nID: `%d`", userLink, c.Sender().ID)
-
-		approveBtn := &tele.ReplyMarkup{}
-		btnApprove := approveBtn.Data("✅ Одобрить", "approve", fmt.Sprintf("%d", c.Sender().ID))
-		approveBtn.Inline(approveBtn.Row(btnApprove))
-
-		targetAdmin := AdminID
-		if targetAdmin == 0 {
-			targetAdmin = 124343839
+		switch result {
+		case service.SubmitPending:
+			return c.Send("⏳ Ваша заявка уже на рассмотрении.\nМы сообщим, когда администратор примет решение.", guestMenu)
+		case service.SubmitCooldown:
+			return c.Send(rejectionText(req), guestMenu)
+		case service.SubmitBanned:
+			return c.Send("⛔ Ваш доступ заблокирован.")
+		case service.SubmitApproved:
+			return c.Send("✅ У вас уже есть доступ!", menu)
 		}
 
-		_, err := b.Send(&tele.User{ID: targetAdmin}, msg, approveBtn, tele.ModeMarkdown)
-		if err != nil {
+		if _, err := b.Send(&tele.User{ID: requestAdminID()}, requestCard(req), requestKeyboard(req.ID), tele.ModeMarkdown); err != nil {
 			log.Println("Ошибка отправки админу:", err)
 			return c.Send("❌ Ошибка отправки заявки (не настроен админ).")
 		}
@@ -125,45 +173,249 @@ func Start(token string, adminID int64) {
 	b.Handle("/request", handleRequest)
 	b.Handle(&btnRequest, handleRequest)
 
-	b.Handle(&tele.Btn{Unique: "approve"}, func(c tele.Context) error {
-		targetIDStr := c.Data()
-		targetID := parseInt(targetIDStr)
+	// --- Заявки: одобрение с выбором тарифа, отказ с причиной, бан ---
+
+	approveRequest := func(c tele.Context, req database.AccessRequest, plan *database.Plan) error {
+		user, err := service.ApproveAccessRequest(&req, plan, c.Sender().ID)
+		switch {
+		case errors.Is(err, service.ErrUserAlreadyExists):
+			return c.Edit(requestCard(req)+"\n\n⚠️ Этот пользователь уже добавлен.", tele.ModeMarkdown)
+		case errors.Is(err, service.ErrRequestAlreadyDecided):
+			return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
+		case err != nil:
+			log.Println("Failed to approve access request:", err)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка: " + err.Error(), ShowAlert: true})
+		}
+
+		planName := "30 GB/мес"
+		if plan != nil {
+			planName = plan.Name
+		}
+
+		b.Send(&tele.User{ID: req.TelegramID}, "🎉 **Поздравляем! Ваш доступ одобрен.**\n\nТеперь вы можете пользоваться VPN. Нажмите кнопку ниже, чтобы подключиться.", menu)
+
+		return c.Edit(fmt.Sprintf("✅ Пользователь %s (%s) одобрен. Тариф: %s.", user.Username, user.TelegramUsername, planName))
+	}
 
-		var exists database.User
-		if database.DB.Where("telegram_id = ?", targetID).First(&exists).Error == nil {
-			return c.Edit("⚠️ Этот пользователь уже добавлен.")
+	// showApprove — если тарифы заведены, сначала выбор тарифа
+	showApprove := func(c tele.Context, req database.AccessRequest) error {
+		if req.Status != service.RequestPending {
+			c.Respond()
+			return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
 		}
 
-		// 1. Техническое имя (для VLESS конфига) всегда user_ID
-		vlessUsername := fmt.Sprintf("user_%d", targetID)
+		var plans []database.Plan
+		database.DB.Order("id").Find(&plans)
+		if len(plans) == 0 {
+			c.Respond()
+			return approveRequest(c, req, nil)
+		}
 
-		// 2. Пытаемся узнать реальный юзернейм для админки
-		tgUsername := ""
-		chat, err := b.ChatByID(targetID)
-		if err == nil && chat.Username != "" {
-			tgUsername = chat.Username
+		reqID := strconv.FormatUint(uint64(req.ID), 10)
+		rm := &tele.ReplyMarkup{}
+		rows := []tele.Row{}
+		for _, p := range plans {
+			rows = append(rows, rm.Row(rm.Data("📦 "+p.Name, "req_plan", reqID, strconv.FormatUint(uint64(p.ID), 10))))
 		}
+		rows = append(rows, rm.Row(rm.Data("↩️ Назад", "req_back", reqID)))
+		rm.Inline(rows...)
+		c.Respond()
+		return c.Edit(requestCard(req)+"\n\nВыберите тариф:", rm, tele.ModeMarkdown)
+	}
 
-		newUser := database.User{
-			UUID:              uuid.New().String(),
-			Username:          vlessUsername,
-			TelegramUsername:  tgUsername,
-			TelegramID:        targetID,
-			Status:            "active",
-			TrafficLimit:      30 * 1024 * 1024 * 1024,
-			SubscriptionToken: database.GenerateToken(),
+	rejectRequest := func(req database.AccessRequest, reason string, adminID int64) (database.AccessRequest, error) {
+		if err := service.RejectAccessRequest(&req, reason, adminID); err != nil {
+			return req, err
 		}
+		service.NotifyUser(req.TelegramID, rejectionText(req))
+		return req, nil
+	}
 
-		database.DB.Create(&newUser)
+	b.Handle(&tele.Btn{Unique: "req_approve"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		return showApprove(c, req)
+	})
 
-		service.GenerateAndReload()
-		service.SyncTelemetUsers()
-		service.GenerateAndReloadTelemet()
+	b.Handle(&tele.Btn{Unique: "req_plan"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		args := c.Args()
+		if len(args) != 2 {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
 
-		userChat := &tele.User{ID: targetID}
-		b.Send(userChat, "🎉 **Поздравляем! Ваш доступ одобрен.**\n\nТеперь вы можете пользоваться VPN. Нажмите кнопку ниже, чтобы подключиться.", menu)
+		var plan database.Plan
+		if err := database.DB.First(&plan, args[1]).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Тариф не найден"})
+		}
+		c.Respond()
+		return approveRequest(c, req, &plan)
+	})
 
-		return c.Edit(fmt.Sprintf("✅ Пользователь %s (%s) одобрен.", vlessUsername, tgUsername))
+	b.Handle(&tele.Btn{Unique: "req_reject"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		c.Respond()
+		if req.Status != service.RequestPending {
+			return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
+		}
+
+		reqID := strconv.FormatUint(uint64(req.ID), 10)
+		rm := &tele.ReplyMarkup{}
+		rows := []tele.Row{}
+		for _, r := range rejectReasons {
+			rows = append(rows, rm.Row(rm.Data(r.Label, "req_reject_reason", reqID, r.Code)))
+		}
+		rows = append(rows, rm.Row(rm.Data("↩️ Назад", "req_back", reqID)))
+		rm.Inline(rows...)
+		return c.Edit(fmt.Sprintf("%s\n\nПричина отказа (своя: `/reject %d текст`):", requestCard(req), req.ID), rm, tele.ModeMarkdown)
+	})
+
+	b.Handle(&tele.Btn{Unique: "req_reject_reason"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		args := c.Args()
+		if len(args) != 2 {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+
+		reason := ""
+		for _, r := range rejectReasons {
+			if r.Code == args[1] {
+				reason = r.Text
+			}
+		}
+		c.Respond()
+		req, err = rejectRequest(req, reason, c.Sender().ID)
+		if err != nil && !errors.Is(err, service.ErrRequestAlreadyDecided) {
+			log.Println("Failed to reject access request:", err)
+		}
+		return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
+	})
+
+	b.Handle(&tele.Btn{Unique: "req_ban"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		c.Respond()
+
+		reqID := strconv.FormatUint(uint64(req.ID), 10)
+		rm := &tele.ReplyMarkup{}
+		rm.Inline(rm.Row(rm.Data("⛔ Да, забанить", "req_ban_confirm", reqID), rm.Data("↩️ Назад", "req_back", reqID)))
+		return c.Edit(requestCard(req)+"\n\nЗабанить? Повторные заявки будут игнорироваться.", rm, tele.ModeMarkdown)
+	})
+
+	b.Handle(&tele.Btn{Unique: "req_ban_confirm"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		c.Respond()
+		if err := service.BanAccessRequest(&req, c.Sender().ID); err == nil {
+			service.NotifyUser(req.TelegramID, "⛔ Ваша заявка отклонена, доступ заблокирован.")
+		} else if !errors.Is(err, service.ErrRequestAlreadyDecided) {
+			log.Println("Failed to ban access request:", err)
+		}
+		return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
+	})
+
+	b.Handle(&tele.Btn{Unique: "req_back"}, func(c tele.Context) error {
+		req, err := callbackRequest(c)
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		c.Respond()
+		if req.Status != service.RequestPending {
+			return c.Edit(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
+		}
+		return c.Edit(requestCard(req), requestKeyboard(req.ID), tele.ModeMarkdown)
+	})
+
+	// Кнопки из старых уведомлений: в данных Telegram ID заявителя, а не ID заявки
+	legacyRequest := func(telegramID int64) (database.AccessRequest, error) {
+		var req database.AccessRequest
+		if database.DB.Where("telegram_id = ?", telegramID).First(&req).Error == nil {
+			return req, nil
+		}
+		username, firstName := "", ""
+		if chat, err := b.ChatByID(telegramID); err == nil {
+			username, firstName = chat.Username, chat.FirstName
+		}
+		req, _, err := service.SubmitAccessRequest(telegramID, username, firstName)
+		return req, err
+	}
+
+	b.Handle(&tele.Btn{Unique: "approve"}, func(c tele.Context) error {
+		if c.Sender().ID != requestAdminID() {
+			return c.Respond(&tele.CallbackResponse{Text: "⛔ Только для администратора"})
+		}
+		req, err := legacyRequest(parseInt(c.Data()))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Заявка не найдена"})
+		}
+		return showApprove(c, req)
+	})
+
+	b.Handle(&tele.Btn{Unique: "approve_plan"}, func(c tele.Context) error {
+		if c.Sender().ID != requestAdminID() {
+			return c.Respond(&tele.CallbackResponse{Text: "⛔ Только для администратора"})
+		}
+		args := c.Args()
+		if len(args) != 2 {
+			return c.Respond(&tele.CallbackResponse{Text: "Неверные данные кнопки"})
+		}
+		req, err := legacyRequest(parseInt(args[0]))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Заявка не найдена"})
+		}
+
+		var plan database.Plan
+		if err := database.DB.First(&plan, args[1]).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Тариф не найден"})
+		}
+		c.Respond()
+		return approveRequest(c, req, &plan)
+	})
+
+	// /pending — очередь заявок (только админ)
+	b.Handle("/pending", func(c tele.Context) error {
+		if c.Sender().ID != requestAdminID() {
+			return nil
+		}
+		return sendPendingRequests(c)
+	})
+
+	// /reject <id заявки> <причина> — отказ со своей причиной (только админ)
+	b.Handle("/reject", func(c tele.Context) error {
+		if c.Sender().ID != requestAdminID() {
+			return nil
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(c.Message().Payload), " ", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return c.Send("Использование: `/reject <id заявки> <причина>`", tele.ModeMarkdown)
+		}
+
+		var req database.AccessRequest
+		if err := database.DB.First(&req, strings.TrimPrefix(parts[0], "#")).Error; err != nil {
+			return c.Send("❌ Заявка не найдена.")
+		}
+
+		req, err := rejectRequest(req, strings.TrimSpace(parts[1]), c.Sender().ID)
+		if err != nil && !errors.Is(err, service.ErrRequestAlreadyDecided) {
+			return c.Send(fmt.Sprintf("❌ Ошибка: %s", err.Error()))
+		}
+		return c.Send(requestCard(req)+"\n\n"+requestStatusText(req), tele.ModeMarkdown)
 	})
 
 	b.Handle(&btnConnect, func(c tele.Context) error {
@@ -172,8 +424,7 @@ func Start(token string, adminID int64) {
 			return c.Send("❌ Пользователь не найден.")
 		}
 
-		var inbounds []database.InboundConfig
-		database.DB.Where("enabled = ?", true).Order("sort_order").Find(&inbounds)
+		inbounds := service.ClientInboundsFor(user)
 
 		if len(inbounds) == 0 {
 			return c.Send("⚠️ Нет доступных подключений.")
@@ -223,7 +474,7 @@ func Start(token string, adminID int64) {
 		if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
 			return c.Send("❌ Пользователь не найден.")
 		}
-		subURL := buildSubURL(user.SubscriptionToken)
+		subURL := service.SubscriptionURL(user.SubscriptionToken)
 		return c.Send(fmt.Sprintf("`%s`", subURL), tele.ModeMarkdown)
 	})
 
@@ -232,7 +483,7 @@ func Start(token string, adminID int64) {
 		if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
 			return c.Send("❌ Пользователь не найден.")
 		}
-		subURL := buildSubURL(user.SubscriptionToken)
+		subURL := service.SubscriptionURL(user.SubscriptionToken)
 
 		qr, qrErr := qrcode.Encode(subURL, qrcode.Medium, 256)
 		if qrErr != nil {
@@ -307,6 +558,62 @@ func Start(token string, adminID int64) {
 		return c.Edit(msg, tele.ModeMarkdown, rm)
 	})
 
+	// Предупреждения о трафике и окончании подписки можно отключить
+	b.Handle(&tele.Btn{Unique: "notify_toggle"}, func(c tele.Context) error {
+		var user database.User
+		if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Пользователь не найден."})
+		}
+		off := !user.NotificationsOff
+		if err := database.DB.Model(&user).Update("notifications_off", off).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Не удалось сохранить настройку."})
+		}
+
+		text := "🔔 Предупреждения включены"
+		if off {
+			text = "🔕 Предупреждения отключены"
+		}
+		c.Respond(&tele.CallbackResponse{Text: text})
+
+		msg, rm := getStatusMsg(c.Sender().ID)
+		return c.Edit(msg, tele.ModeMarkdown, rm)
+	})
+
+	// Перевыпуск ключей юзером (если ссылка утекла)
+	b.Handle(&tele.Btn{Unique: "rotate_ask"}, func(c tele.Context) error {
+		rm := &tele.ReplyMarkup{}
+		rm.Inline(rm.Row(
+			rm.Data("✅ Перевыпустить", "rotate_confirm"),
+			rm.Data("❌ Отмена", "rotate_cancel"),
+		))
+		c.Respond()
+		return c.Send("🔐 **Перевыпуск ключей**\n\n"+
+			"Будут созданы новый ключ VPN, новая ссылка подписки и новый секрет Telegram Proxy. "+
+			"Старые ссылки перестанут работать на всех устройствах — их нужно будет добавить заново.\n\n"+
+			"Используйте, если ссылка попала к посторонним.", rm, tele.ModeMarkdown)
+	})
+
+	b.Handle(&tele.Btn{Unique: "rotate_cancel"}, func(c tele.Context) error {
+		c.Respond()
+		return c.Edit("Перевыпуск ключей отменён.")
+	})
+
+	b.Handle(&tele.Btn{Unique: "rotate_confirm"}, func(c tele.Context) error {
+		var user database.User
+		if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Пользователь не найден"})
+		}
+		if user.Status == "banned" {
+			return c.Respond(&tele.CallbackResponse{Text: "Доступ заблокирован"})
+		}
+
+		c.Respond(&tele.CallbackResponse{Text: "Перевыпускаю..."})
+		if err := service.RotateUserCredentials(&user, service.AllCredentialParts, "bot: user rotate "+user.Username); err != nil {
+			log.Printf("Rotate credentials for %s: %v", user.Username, err)
+		}
+		return c.Edit("✅ Ключи перевыпущены. Новые ссылки отправлены следующим сообщением.")
+	})
+
 	b.Handle(&btnHelp, func(c tele.Context) error {
 		helpMsg := `📖 **Инструкция по подключению:**
 
@@ -590,30 +897,72 @@ func Start(token string, adminID int64) {
 		return c.Send(fmt.Sprintf("✅ Credentials работают!\nTURN сервер: `%s`", turnServer), tele.ModeMarkdown)
 	})
 
-	b.Handle("/broadcast", func(c tele.Context) error {
+	// /expiry — продлить или снять срок подписки (только админ)
+	b.Handle("/expiry", func(c tele.Context) error {
 		if c.Sender().ID != AdminID {
-			return c.Send("⛔ Только администратор может отправлять рассылку.")
+			return nil
 		}
 
-		text := strings.TrimSpace(strings.TrimPrefix(c.Text(), "/broadcast"))
-		if text == "" {
-			return c.Send("Использование: `/broadcast <текст сообщения>`", tele.ModeMarkdown)
+		usage := "Использование:\n" +
+			"`/expiry <id|@username> +30` — продлить на 30 дней\n" +
+			"`/expiry <id|@username> 2025-12-31` — задать дату\n" +
+			"`/expiry <id|@username> clear` — снять ограничение"
+
+		args := c.Args()
+		if len(args) != 2 {
+			return c.Send(usage, tele.ModeMarkdown)
 		}
 
-		var users []database.User
-		database.DB.Where("telegram_id > 0").Find(&users)
+		user, err := findUserByRef(args[0])
+		if err != nil {
+			return c.Send(err.Error())
+		}
 
-		sent, failed := 0, 0
-		for _, u := range users {
-			_, err := b.Send(&tele.User{ID: u.TelegramID}, text)
-			if err != nil {
-				failed++
-			} else {
-				sent++
+		arg := args[1]
+		switch {
+		case arg == "clear":
+			err = service.SetUserExpiry(&user, nil)
+		case strings.HasPrefix(arg, "+"):
+			days, convErr := strconv.Atoi(strings.TrimPrefix(arg, "+"))
+			if convErr != nil || days <= 0 {
+				return c.Send(usage, tele.ModeMarkdown)
+			}
+			err = service.ExtendUserExpiry(&user, days)
+		default:
+			date, parseErr := time.ParseInLocation("2006-01-02", arg, time.Local)
+			if parseErr != nil {
+				return c.Send(usage, tele.ModeMarkdown)
 			}
+			// Подписка действует до конца указанного дня
+			expiry := date.AddDate(0, 0, 1).Add(-time.Second)
+			err = service.SetUserExpiry(&user, &expiry)
+		}
+		if err != nil {
+			return c.Send(fmt.Sprintf("❌ Ошибка: %s", err.Error()))
+		}
+
+		return c.Send(fmt.Sprintf("✅ %s: подписка %s, статус %s", user.Username, formatExpiry(user.ExpiryDate), user.Status))
+	})
+
+	// Одноразовая ссылка входа в веб-панель для привязанных аккаунтов админки
+	b.Handle("/panel", func(c tele.Context) error {
+		if c.Chat().Type != tele.ChatPrivate {
+			return nil
+		}
+		account, err := service.AdminAccountByTelegramID(c.Sender().ID)
+		if err != nil {
+			return c.Send("⛔ Ваш Telegram не привязан к аккаунту админ-панели.")
 		}
 
-		return c.Send(fmt.Sprintf("📨 Рассылка завершена.\n✅ Отправлено: %d\n❌ Ошибок: %d", sent, failed))
+		token, err := service.CreatePanelLoginToken(account.ID)
+		if err != nil {
+			log.Println("Failed to create panel login token:", err)
+			return c.Send("❌ Не удалось создать ссылку входа.")
+		}
+
+		return c.Send(fmt.Sprintf("🔐 Вход в админ-панель (%s, %s)\n\n%s\n\nСсылка одноразовая и действует %d минут.",
+			account.Username, account.Role, service.PanelLoginURL(token), int(service.PanelLoginTokenTTL.Minutes())),
+			tele.NoPreview)
 	})
 
 	// Фоновая задача
@@ -641,11 +990,17 @@ func getInboundAndUser(c tele.Context) (database.InboundConfig, database.User, e
 	if err := database.DB.First(&ib, id).Error; err != nil {
 		return database.InboundConfig{}, database.User{}, fmt.Errorf("❌ Подключение не найдено.")
 	}
+	if ib.ServerAddress == "" {
+		ib.ServerAddress = service.NodeAddress(ib.NodeID, "")
+	}
 
 	var user database.User
 	if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
 		return database.InboundConfig{}, database.User{}, fmt.Errorf("❌ Пользователь не найден.")
 	}
+	if !service.UserInboundAllowed(user, ib.Tag) {
+		return database.InboundConfig{}, database.User{}, fmt.Errorf("❌ Подключение недоступно на вашем тарифе.")
+	}
 
 	return ib, user, nil
 }
@@ -664,6 +1019,16 @@ func getStatusMsg(tgID int64) (string, *tele.ReplyMarkup) {
 		limitStr = "∞ (Безлимит)"
 	}
 
+	expiryStr := formatExpiry(user.ExpiryDate)
+	if user.SuspendedUntil != nil && user.SuspendedUntil.After(time.Now()) {
+		expiryStr += fmt.Sprintf("\n⏸ Приостановлено до **%s** (лимит устройств)", user.SuspendedUntil.Format("15:04 02.01"))
+	}
+
+	resetStr := "не настроен"
+	if next := service.NextTrafficReset(user); next != nil {
+		resetStr = next.Format("02.01.2006")
+	}
+
 	// 3. Считаем ОБЩЕЕ количество пользователей
 	var totalUsers int64
 	database.DB.Model(&database.User{}).Where("status = ?", "active").Count(&totalUsers)
@@ -674,13 +1039,20 @@ func getStatusMsg(tgID int64) (string, *tele.ReplyMarkup) {
 			"👥 Активных пользователей: **%d**\n\n"+
 			"👤 **Ваш профиль:** `%s`\n"+
 			"📉 Потрачено: **%s**\n"+
-			"📈 Лимит: **%s**",
-		totalUsers, user.Username, used, limitStr,
+			"📈 Лимит: **%s**\n"+
+			"🔄 Сброс трафика: **%s**\n"+
+			"📅 Подписка: **%s**",
+		totalUsers, user.Username, used, limitStr, resetStr, expiryStr,
 	)
 
 	rm := &tele.ReplyMarkup{}
 	btnRefresh := rm.Data("🔄 Обновить", "status_refresh")
-	rm.Inline(rm.Row(btnRefresh))
+	btnRotate := rm.Data("🔐 Перевыпустить ключи", "rotate_ask")
+	btnNotify := rm.Data("🔕 Отключить предупреждения", "notify_toggle")
+	if user.NotificationsOff {
+		btnNotify = rm.Data("🔔 Включить предупреждения", "notify_toggle")
+	}
+	rm.Inline(rm.Row(btnRefresh), rm.Row(btnRotate), rm.Row(btnNotify))
 
 	return msg, rm
 }
@@ -691,6 +1063,146 @@ func getUser(tgID int64) database.User {
 	return user
 }
 
+// pendingListLimit — сколько заявок показывает /pending
+const pendingListLimit = 10
+
+// rejectReasons — готовые причины отказа; Text увидит заявитель
+var rejectReasons = []struct {
+	Code, Label, Text string
+}{
+	{"unknown", "🤷 Не знаем вас", "Администратор не смог понять, кто вы. Напишите ему напрямую."},
+	{"full", "🈵 Нет мест", "Сейчас нет свободных мест."},
+	{"none", "❌ Без причины", ""},
+}
+
+// sendPendingRequests — очередь заявок: заголовок и карточка с кнопками на каждую заявку
+func sendPendingRequests(c tele.Context) error {
+	reqs, total := service.PendingAccessRequests(pendingListLimit)
+	if total == 0 {
+		return c.Send("✅ Новых заявок нет.")
+	}
+
+	header := fmt.Sprintf("📋 Заявок в очереди: %d", total)
+	if total > int64(len(reqs)) {
+		header += fmt.Sprintf(" (показаны первые %d)", len(reqs))
+	}
+	if err := c.Send(header); err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if err := c.Send(requestCard(req), requestKeyboard(req.ID), tele.ModeMarkdown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestAdminID — кому уходят заявки
+func requestAdminID() int64 {
+	if AdminID == 0 {
+		return 124343839
+	}
+	return AdminID
+}
+
+// requestCard — текст заявки для админа (Markdown)
+func requestCard(req database.AccessRequest) string {
+	userLink := "@" + escapeMarkdown(req.TelegramUsername)
+	if req.TelegramUsername == "" {
+		userLink = fmt.Sprintf("[%s](tg://user?id=%d)", escapeMarkdown(req.FirstName), req.TelegramID)
+	}
+
+	msg := fmt.Sprintf("🔔 *Заявка #%d*\nUser: %s\nID: `%d`", req.ID, userLink, req.TelegramID)
+	if req.Attempts > 1 {
+		msg += fmt.Sprintf("\nПопыток: %d", req.Attempts)
+	}
+	return msg
+}
+
+func requestKeyboard(reqID uint) *tele.ReplyMarkup {
+	id := strconv.FormatUint(uint64(reqID), 10)
+	rm := &tele.ReplyMarkup{}
+	rm.Inline(
+		rm.Row(rm.Data("✅ Одобрить", "req_approve", id), rm.Data("❌ Отклонить", "req_reject", id)),
+		rm.Row(rm.Data("⛔ Забанить", "req_ban", id)),
+	)
+	return rm
+}
+
+// requestStatusText — итог по заявке для админа
+func requestStatusText(req database.AccessRequest) string {
+	switch req.Status {
+	case service.RequestApproved:
+		return "✅ Одобрена."
+	case service.RequestRejected:
+		if req.Reason != "" {
+			return "❌ Отклонена: " + escapeMarkdown(req.Reason)
+		}
+		return "❌ Отклонена."
+	case service.RequestBanned:
+		return "⛔ Забанен."
+	}
+	return "⏳ Ожидает решения."
+}
+
+// rejectionText — сообщение заявителю об отказе
+func rejectionText(req database.AccessRequest) string {
+	msg := "❌ Ваша заявка отклонена."
+	if req.Reason != "" {
+		msg += "\nПричина: " + req.Reason
+	}
+	if retryAt := service.AccessRequestRetryAt(req); retryAt.After(time.Now()) {
+		msg += "\n\nПовторно подать заявку можно после " + retryAt.Format("02.01.2006 15:04") + "."
+	}
+	return msg
+}
+
+// callbackRequest — заявка из данных кнопки; кнопки заявок доступны только админу
+func callbackRequest(c tele.Context) (database.AccessRequest, error) {
+	var req database.AccessRequest
+	if c.Sender().ID != requestAdminID() {
+		return req, fmt.Errorf("⛔ Только для администратора")
+	}
+	args := c.Args()
+	if len(args) == 0 {
+		return req, fmt.Errorf("Неверные данные кнопки")
+	}
+	if err := database.DB.First(&req, args[0]).Error; err != nil {
+		return req, fmt.Errorf("Заявка не найдена")
+	}
+	return req, nil
+}
+
+// findUserByRef ищет юзера по ID в базе, Telegram ID или @username
+func findUserByRef(ref string) (database.User, error) {
+	var user database.User
+	if strings.HasPrefix(ref, "@") {
+		if err := database.DB.Where("telegram_username = ?", strings.TrimPrefix(ref, "@")).First(&user).Error; err != nil {
+			return user, fmt.Errorf("❌ Пользователь %s не найден.", ref)
+		}
+		return user, nil
+	}
+
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return user, fmt.Errorf("❌ Неверный идентификатор: %s", ref)
+	}
+	if database.DB.First(&user, id).Error == nil {
+		return user, nil
+	}
+	if database.DB.Where("telegram_id = ?", id).First(&user).Error == nil {
+		return user, nil
+	}
+	return user, fmt.Errorf("❌ Пользователь %s не найден.", ref)
+}
+
+func formatExpiry(expiry *time.Time) string {
+	if expiry == nil {
+		return "бессрочно"
+	}
+	return "до " + expiry.Format("02.01.2006")
+}
+
 func parseInt(s string) int64 {
 	var i int64
 	fmt.Sscanf(s, "%d", &i)
@@ -707,14 +1219,6 @@ func escapeMarkdown(s string) string {
 	return replacer.Replace(s)
 }
 
-func buildSubURL(token string) string {
-	domain := os.Getenv("SERVER_DOMAIN")
-	if domain != "" {
-		return fmt.Sprintf("https://%s/sub/%s", domain, token)
-	}
-	return fmt.Sprintf("https://%s:8085/sub/%s", ServerIP, token)
-}
-
 // getTelemetLink возвращает ссылку tg://proxy для текущего юзера
 func getTelemetLink(c tele.Context) (string, error) {
 	var user database.User
@@ -722,38 +1226,13 @@ func getTelemetLink(c tele.Context) (string, error) {
 		return "", fmt.Errorf("❌ Пользователь не найден.")
 	}
 
-	var cfg database.TelemetConfig
-	if err := database.DB.First(&cfg).Error; err != nil || !cfg.Enabled {
+	link, err := service.TelemetLinkForUser(user, "bot")
+	if errors.Is(err, service.ErrTelemetNotConfigured) {
 		return "", fmt.Errorf("❌ Telegram Proxy не настроен.")
 	}
-
-	// Ищем или создаём TelemetUser (атомарно через FirstOrCreate)
-	var tu database.TelemetUser
-	result := database.DB.Where("user_id = ? AND telemet_config_id = ?", user.ID, cfg.ID).
-		Attrs(database.TelemetUser{
-			Label:  user.Username,
-			Secret: service.GenerateSecret(),
-		}).
-		FirstOrCreate(&tu)
-	if result.Error != nil {
+	if err != nil {
 		return "", fmt.Errorf("❌ Ошибка создания секрета прокси.")
 	}
-	if result.RowsAffected > 0 {
-		// Новый секрет создан — перегенерируем конфиг telemt
-		service.GenerateAndReloadTelemet()
-	}
-
-	serverAddr := cfg.ServerAddress
-	if serverAddr == "" {
-		serverAddr = ServerIP
-	}
-
-	tlsDomain := cfg.TLSDomain
-	if tlsDomain == "" {
-		tlsDomain = "dl.google.com"
-	}
-
-	link := service.GenerateTelemetProxyLink(serverAddr, cfg.Port, tu.Secret, tlsDomain)
 	return link, nil
 }
 
@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// broadcastsListLimit — сколько рассылок показывает /broadcasts
+const broadcastsListLimit = 10
+
+const broadcastUsage = "Использование: `/broadcast [фильтры] <текст>`\n\n" +
+	"Фильтры в начале сообщения:\n" +
+	"`status=active|expired|banned` — статус\n" +
+	"`plan=<id>` — тариф\n" +
+	"`inbound=<тег>` — доступен инбаунд\n" +
+	"`seen=<дней>` — подключались за N дней\n" +
+	"`idle=<дней>` — не подключались N дней\n" +
+	"`mode=md|html` — разметка текста\n\n" +
+	"Чтобы разослать фото, видео или файл, ответьте командой на сообщение с ним — текст станет подписью.\n" +
+	"Перед отправкой бот пришлёт превью и попросит подтверждение."
+
+// setupBroadcasts регистрирует отправителя рассылок и команды /broadcast, /broadcasts
+func setupBroadcasts(b *tele.Bot) {
+	service.SetBroadcastSender(func(telegramID int64, bc database.Broadcast) error {
+		return sendBroadcastMessage(b, telegramID, bc)
+	})
+
+	b.Handle("/broadcast", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return c.Send("⛔ Только администратор может отправлять рассылку.")
+		}
+
+		bc, err := parseBroadcastCommand(c.Message())
+		if err != nil {
+			return c.Send(broadcastUsage, tele.ModeMarkdown)
+		}
+		bc.CreatedBy = "bot"
+		if err := service.CreateBroadcast(&bc); err != nil {
+			return c.Send("❌ " + err.Error())
+		}
+
+		// Превью — ровно то сообщение, которое получат юзеры
+		if err := sendBroadcastMessage(b, c.Sender().ID, bc); err != nil {
+			service.CancelBroadcast(&bc)
+			return c.Send("❌ Не удалось отправить превью, рассылка отменена: " + err.Error())
+		}
+		text, rm := broadcastCard(bc)
+		return c.Send(text, rm)
+	})
+
+	// /broadcasts — последние рассылки с отчётами
+	b.Handle("/broadcasts", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return nil
+		}
+
+		var broadcasts []database.Broadcast
+		database.DB.Order("id desc").Limit(broadcastsListLimit).Find(&broadcasts)
+		if len(broadcasts) == 0 {
+			return c.Send("Рассылок ещё не было.")
+		}
+
+		var sb strings.Builder
+		sb.WriteString("📨 Последние рассылки\n")
+		rm := &tele.ReplyMarkup{}
+		rows := []tele.Row{}
+		for _, bc := range broadcasts {
+			fmt.Fprintf(&sb, "\n#%d %s — %s, доставлено %d из %d", bc.ID, bc.CreatedAt.Format("02.01 15:04"),
+				service.BroadcastStatusLabel(bc.Status), bc.Sent, bc.Total)
+			rows = append(rows, rm.Row(rm.Data(fmt.Sprintf("📊 #%d", bc.ID), "bc_report", strconv.FormatUint(uint64(bc.ID), 10))))
+		}
+		rm.Inline(rows...)
+		return c.Send(sb.String(), rm)
+	})
+
+	handleBroadcast := func(unique string, fn func(c tele.Context, bc *database.Broadcast) error) {
+		b.Handle(&tele.Btn{Unique: unique}, func(c tele.Context) error {
+			if c.Sender().ID != AdminID {
+				return c.Respond(&tele.CallbackResponse{Text: "⛔ Только для администратора"})
+			}
+			var bc database.Broadcast
+			if err := database.DB.First(&bc, c.Data()).Error; err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: "Рассылка не найдена"})
+			}
+			return fn(c, &bc)
+		})
+	}
+
+	handleBroadcast("bc_send", func(c tele.Context, bc *database.Broadcast) error {
+		err := service.QueueBroadcast(bc)
+		switch {
+		case errors.Is(err, service.ErrBroadcastEmpty):
+			return c.Respond(&tele.CallbackResponse{Text: "Под фильтры сейчас никто не подходит", ShowAlert: true})
+		case err != nil && !errors.Is(err, service.ErrBroadcastNotDraft):
+			return c.Respond(&tele.CallbackResponse{Text: "❌ " + err.Error(), ShowAlert: true})
+		}
+		c.Respond(&tele.CallbackResponse{Text: "✅ Рассылка в очереди"})
+		database.DB.First(bc, bc.ID)
+		text, rm := broadcastCard(*bc)
+		return c.Edit(text, rm)
+	})
+
+	handleBroadcast("bc_cancel", func(c tele.Context, bc *database.Broadcast) error {
+		if err := service.CancelBroadcast(bc); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Рассылка уже завершена"})
+		}
+		c.Respond(&tele.CallbackResponse{Text: "⏹ Рассылка отменена"})
+		text, rm := broadcastCard(*bc)
+		return c.Edit(text, rm)
+	})
+
+	handleBroadcast("bc_report", func(c tele.Context, bc *database.Broadcast) error {
+		c.Respond()
+		text, rm := broadcastCard(*bc)
+		if c.Callback().Message != nil && c.Callback().Message.Text == text {
+			return nil // ничего не изменилось — Telegram не даст отредактировать
+		}
+		if err := c.Edit(text, rm); err != nil {
+			return c.Send(text, rm)
+		}
+		return nil
+	})
+}
+
+// parseBroadcastCommand разбирает /broadcast: фильтры key=value в начале, дальше текст.
+// Медиа берётся из сообщения, на которое ответили командой.
+func parseBroadcastCommand(m *tele.Message) (database.Broadcast, error) {
+	var bc database.Broadcast
+
+	// Текст после команды целиком, с переносами строк (Payload обрезается на первой строке)
+	text := m.Text
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		text = text[i:]
+	} else {
+		text = ""
+	}
+
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		end := strings.IndexFunc(text, unicode.IsSpace)
+		if end < 0 {
+			end = len(text)
+		}
+		key, value, ok := strings.Cut(text[:end], "=")
+		if !ok || value == "" {
+			break
+		}
+
+		var err error
+		switch key {
+		case "status":
+			bc.FilterStatus = value
+		case "plan":
+			var id uint64
+			id, err = strconv.ParseUint(value, 10, 64)
+			bc.FilterPlanID = uint(id)
+		case "inbound":
+			bc.FilterInbound = value
+		case "seen":
+			bc.SeenWithinDays, err = strconv.Atoi(value)
+		case "idle":
+			bc.NotSeenForDays, err = strconv.Atoi(value)
+		case "mode":
+			switch value {
+			case "md", "markdown":
+				bc.ParseMode = "markdown"
+			case "html":
+				bc.ParseMode = "html"
+			default:
+				err = fmt.Errorf("unknown mode %q", value)
+			}
+		default:
+			// Не фильтр — значит, уже начался текст
+			ok = false
+		}
+		if err != nil {
+			return bc, err
+		}
+		if !ok {
+			break
+		}
+		text = text[end:]
+	}
+	bc.Text = strings.TrimSpace(text)
+
+	if reply := m.ReplyTo; reply != nil {
+		switch {
+		case reply.Photo != nil:
+			bc.MediaType, bc.MediaFile = "photo", reply.Photo.FileID
+		case reply.Video != nil:
+			bc.MediaType, bc.MediaFile = "video", reply.Video.FileID
+		case reply.Document != nil:
+			bc.MediaType, bc.MediaFile = "document", reply.Document.FileID
+		}
+		if bc.Text == "" {
+			bc.Text = reply.Caption
+			if bc.MediaType == "" {
+				bc.Text = reply.Text
+			}
+		}
+	}
+
+	if bc.Text == "" && bc.MediaType == "" {
+		return bc, fmt.Errorf("empty broadcast")
+	}
+	return bc, nil
+}
+
+// sendBroadcastMessage отправляет сообщение рассылки; ошибки Telegram переводит в ошибки service
+func sendBroadcastMessage(b *tele.Bot, telegramID int64, bc database.Broadcast) error {
+	opts := &tele.SendOptions{}
+	switch bc.ParseMode {
+	case "markdown":
+		opts.ParseMode = tele.ModeMarkdown
+	case "html":
+		opts.ParseMode = tele.ModeHTML
+	}
+
+	file := tele.File{FileID: bc.MediaFile}
+	if strings.HasPrefix(bc.MediaFile, "http://") || strings.HasPrefix(bc.MediaFile, "https://") {
+		file = tele.FromURL(bc.MediaFile)
+	}
+
+	var what interface{} = bc.Text
+	switch bc.MediaType {
+	case "photo":
+		what = &tele.Photo{File: file, Caption: bc.Text}
+	case "video":
+		what = &tele.Video{File: file, Caption: bc.Text}
+	case "document":
+		what = &tele.Document{File: file, Caption: bc.Text}
+	}
+
+	_, err := b.Send(&tele.User{ID: telegramID}, what, opts)
+	return broadcastError(err)
+}
+
+func broadcastError(err error) error {
+	var flood tele.FloodError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &flood):
+		return service.RetryAfterError{After: time.Duration(max(flood.RetryAfter, 1)) * time.Second}
+	case errors.Is(err, tele.ErrBlockedByUser), errors.Is(err, tele.ErrUserIsDeactivated),
+		errors.Is(err, tele.ErrNotStartedByUser), errors.Is(err, tele.ErrChatNotFound):
+		return fmt.Errorf("%w: %v", service.ErrRecipientBlocked, err)
+	}
+	return err
+}
+
+// broadcastCard — отчёт по рассылке с кнопками под её статус
+func broadcastCard(bc database.Broadcast) (string, *tele.ReplyMarkup) {
+	id := strconv.FormatUint(uint64(bc.ID), 10)
+	rm := &tele.ReplyMarkup{}
+
+	text := service.BroadcastReportText(bc)
+	switch bc.Status {
+	case service.BroadcastDraft:
+		text = "👆 Превью рассылки\n\n" + text
+		rm.Inline(rm.Row(
+			rm.Data(fmt.Sprintf("✅ Отправить (%d)", bc.Total), "bc_send", id),
+			rm.Data("❌ Отмена", "bc_cancel", id),
+		))
+	case service.BroadcastQueued, service.BroadcastSending:
+		rm.Inline(rm.Row(
+			rm.Data("🔄 Обновить", "bc_report", id),
+			rm.Data("⏹ Остановить", "bc_cancel", id),
+		))
+	}
+	return text, rm
+}
@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// invitesListLimit — сколько кодов показывает /invites
+const invitesListLimit = 20
+
+// setupInvites регистрирует команды приглашений: коды админа и личные ссылки юзеров
+func setupInvites(b *tele.Bot) {
+	// /invite — админ создаёт код, юзер получает свою реферальную ссылку
+	b.Handle("/invite", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return sendReferralLink(c)
+		}
+
+		usage := "Использование: `/invite [использований] [дней] [id тарифа]`\n" +
+			"По умолчанию одноразовый код на 7 дней без тарифа. 0 — без ограничения."
+
+		nums := []int{1, 7, 0}
+		args := c.Args()
+		if len(args) > len(nums) {
+			return c.Send(usage, tele.ModeMarkdown)
+		}
+		for i, arg := range args {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return c.Send(usage, tele.ModeMarkdown)
+			}
+			nums[i] = n
+		}
+
+		invite := database.InviteCode{MaxUses: nums[0], PlanID: uint(nums[2]), Note: "bot"}
+		if nums[1] > 0 {
+			expires := time.Now().AddDate(0, 0, nums[1])
+			invite.ExpiresAt = &expires
+		}
+		if err := service.CreateInviteCode(&invite); err != nil {
+			return c.Send(fmt.Sprintf("❌ Ошибка: %s", err.Error()))
+		}
+
+		return c.Send(fmt.Sprintf("🎟 Код `%s` (%s)\n\n%s", invite.Code, inviteSummary(invite), escapeMarkdown(inviteLinkOrCode(invite.Code))), tele.ModeMarkdown, tele.NoPreview)
+	})
+
+	// /invites — активные коды (только админ)
+	b.Handle("/invites", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return nil
+		}
+
+		var invites []database.InviteCode
+		database.DB.Where("revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > ?)", time.Now()).
+			Order("id desc").Limit(invitesListLimit).Find(&invites)
+		if len(invites) == 0 {
+			return c.Send("Активных кодов нет. Создать: `/invite`", tele.ModeMarkdown)
+		}
+
+		var sb strings.Builder
+		sb.WriteString("🎟 **Активные коды**\n")
+		for _, inv := range invites {
+			fmt.Fprintf(&sb, "\n`%s` — %s", inv.Code, inviteSummary(inv))
+			if inv.OwnerUserID != 0 {
+				fmt.Fprintf(&sb, ", реферальный (юзер %d)", inv.OwnerUserID)
+			}
+		}
+		sb.WriteString("\n\nОтозвать: `/revoke <код>`")
+		return c.Send(sb.String(), tele.ModeMarkdown)
+	})
+
+	// /revoke <код> — отозвать код (только админ)
+	b.Handle("/revoke", func(c tele.Context) error {
+		if c.Sender().ID != AdminID {
+			return nil
+		}
+		args := c.Args()
+		if len(args) != 1 {
+			return c.Send("Использование: `/revoke <код>`", tele.ModeMarkdown)
+		}
+
+		var invite database.InviteCode
+		if err := database.DB.Where("code = ?", args[0]).First(&invite).Error; err != nil {
+			return c.Send("❌ Код не найден.")
+		}
+		if err := service.RevokeInviteCode(&invite); err != nil {
+			return c.Send(fmt.Sprintf("❌ Ошибка: %s", err.Error()))
+		}
+		return c.Send(fmt.Sprintf("✅ Код `%s` отозван. Использован %d раз.", invite.Code, invite.Uses), tele.ModeMarkdown)
+	})
+}
+
+// sendReferralLink — личная ссылка юзера и сколько людей по ней пришло
+func sendReferralLink(c tele.Context) error {
+	var user database.User
+	if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
+		return c.Send("❌ Пользователь не найден.")
+	}
+
+	invite, ok, err := service.ReferralInviteCode(user)
+	if !ok {
+		return c.Send("👥 Приглашения сейчас недоступны.")
+	}
+	if errors.Is(err, service.ErrReferralInactive) {
+		return c.Send("👥 Приглашать друзей можно только с активной подпиской.")
+	}
+	if err != nil {
+		log.Println("Failed to create referral code:", err)
+		return c.Send("❌ Не удалось создать ссылку.")
+	}
+
+	var invited int64
+	database.DB.Model(&database.User{}).Where("referred_by_id = ?", user.ID).Count(&invited)
+
+	msg := fmt.Sprintf("👥 Ваша ссылка для приглашения друзей:\n\n%s\n\nПриглашено: %d", inviteLinkOrCode(invite.Code), invited)
+	if invite.MaxUses > 0 {
+		msg += fmt.Sprintf("\nОсталось приглашений по ссылке: %d", max(invite.MaxUses-invite.Uses, 0))
+	}
+	return c.Send(msg, tele.NoPreview)
+}
+
+func sendInviteError(c tele.Context, err error, guestMenu *tele.ReplyMarkup) error {
+	switch {
+	case errors.Is(err, service.ErrInviteBanned):
+		return c.Send("⛔ Ваш доступ заблокирован.")
+	case errors.Is(err, service.ErrInviteInvalid):
+		return c.Send("❌ Приглашение недействительно: код не найден, истёк или уже использован.\n\nМожно подать заявку администратору.", guestMenu)
+	default:
+		log.Println("Failed to redeem invite:", err)
+		return c.Send("❌ Не удалось активировать приглашение, попробуйте позже.", guestMenu)
+	}
+}
+
+func inviteLinkOrCode(code string) string {
+	if link := service.InviteLink(code); link != "" {
+		return link
+	}
+	return "/start " + code
+}
+
+// inviteSummary — использования, срок и тариф кода одной строкой (Markdown)
+func inviteSummary(inv database.InviteCode) string {
+	uses := fmt.Sprintf("%d/∞", inv.Uses)
+	if inv.MaxUses > 0 {
+		uses = fmt.Sprintf("%d/%d", inv.Uses, inv.MaxUses)
+	}
+	parts := []string{"использовано " + uses}
+	if inv.ExpiresAt != nil {
+		parts = append(parts, "до "+inv.ExpiresAt.Format("02.01.2006 15:04"))
+	}
+	if inv.PlanID != 0 {
+		var plan database.Plan
+		if database.DB.First(&plan, inv.PlanID).Error == nil {
+			parts = append(parts, "тариф "+escapeMarkdown(plan.Name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"vpnbot/database"
+	"vpnbot/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// starsProvider — оплата в Telegram Stars. Счёт без провайдер-токена, возврат через refundStarPayment.
+type starsProvider struct {
+	bot *tele.Bot
+}
+
+func (starsProvider) Name() string          { return "stars" }
+func (starsProvider) Currency() string      { return "XTR" }
+func (starsProvider) ProviderToken() string { return "" }
+func (starsProvider) UsesInvoice() bool     { return true }
+
+func (p starsProvider) Refund(payment database.Payment) error {
+	if payment.ChargeID == "" {
+		return fmt.Errorf("payment has no telegram charge id")
+	}
+	_, err := p.bot.Raw("refundStarPayment", map[string]interface{}{
+		"user_id":                    payment.TelegramID,
+		"telegram_payment_charge_id": payment.ChargeID,
+	})
+	return err
+}
+
+// setupPayments регистрирует Stars и обработчики покупки тарифов и докупки трафика
+func setupPayments(b *tele.Bot, btnPay tele.Btn) {
+	service.RegisterPaymentProvider(starsProvider{bot: b})
+
+	b.Handle(&btnPay, func(c tele.Context) error {
+		var user database.User
+		if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
+			return c.Send("❌ Пользователь не найден.")
+		}
+
+		var plans []database.Plan
+		database.DB.Where("price_stars > 0").Order("price_stars").Find(&plans)
+
+		rm := &tele.ReplyMarkup{}
+		rows := []tele.Row{}
+		for _, p := range plans {
+			label := fmt.Sprintf("📦 %s — %d ⭐", p.Name, p.PriceStars)
+			if p.ID == user.PlanID && p.DurationDays > 0 {
+				label = fmt.Sprintf("🔄 Продлить %s на %d дн. — %d ⭐", p.Name, p.DurationDays, p.PriceStars)
+			}
+			rows = append(rows, rm.Row(rm.Data(label, "pay_plan", strconv.FormatUint(uint64(p.ID), 10))))
+		}
+		if bytes, price, ok := service.TopUpOffer(); ok && user.TrafficLimit > 0 {
+			rows = append(rows, rm.Row(rm.Data(fmt.Sprintf("➕ %s трафика — %d ⭐", formatBytes(bytes), price), "pay_topup")))
+		}
+
+		if len(rows) == 0 {
+			return c.Send("💳 Оплата в боте пока недоступна. Для продления обратитесь к администратору.")
+		}
+		rm.Inline(rows...)
+		return c.Send("💳 Выберите, что оплатить:", rm)
+	})
+
+	b.Handle(&tele.Btn{Unique: "pay_plan"}, func(c tele.Context) error {
+		var plan database.Plan
+		if err := database.DB.Where("price_stars > 0").First(&plan, c.Data()).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Тариф не найден"})
+		}
+		c.Respond()
+
+		description := plan.Description
+		if description == "" {
+			description = planSummary(plan)
+		}
+		return sendPaymentInvoice(c, func(user database.User, provider service.PaymentProvider) (database.Payment, error) {
+			return service.CreatePlanPayment(user, plan, provider)
+		}, "Тариф "+plan.Name, description)
+	})
+
+	b.Handle(&tele.Btn{Unique: "pay_topup"}, func(c tele.Context) error {
+		bytes, _, ok := service.TopUpOffer()
+		if !ok {
+			return c.Respond(&tele.CallbackResponse{Text: "Докупка трафика недоступна"})
+		}
+		c.Respond()
+		return sendPaymentInvoice(c, service.CreateTopUpPayment,
+			"Трафик +"+formatBytes(bytes), fmt.Sprintf("Увеличение лимита трафика на %s", formatBytes(bytes)))
+	})
+
+	// mock-провайдер: оплата подтверждается кнопкой, без Telegram Payments
+	b.Handle(&tele.Btn{Unique: "pay_mock"}, func(c tele.Context) error {
+		if !service.MockPaymentsEnabled() {
+			return c.Respond(&tele.CallbackResponse{Text: "Тестовая оплата выключена"})
+		}
+		var payment database.Payment
+		if err := database.DB.Where("payload = ? AND provider = ?", c.Data(), "mock").First(&payment).Error; err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Платёж не найден"})
+		}
+		if err := service.CheckPendingPayment(payment.Payload, c.Sender().ID, payment.Amount, payment.Currency); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ " + err.Error()})
+		}
+		c.Respond()
+		payment, err := service.CompletePayment(payment.Payload, "mock-"+strconv.FormatUint(uint64(payment.ID), 10), payment.Amount, payment.Currency)
+		return c.Edit(paymentResultText(payment, err))
+	})
+
+	b.Handle(tele.OnCheckout, func(c tele.Context) error {
+		q := c.PreCheckoutQuery()
+		if err := service.CheckPendingPayment(q.Payload, q.Sender.ID, q.Total, q.Currency); err != nil {
+			log.Printf("Pre-checkout rejected for %d: %v", q.Sender.ID, err)
+			return c.Accept("Счёт устарел, оформите оплату заново.")
+		}
+		return c.Accept()
+	})
+
+	b.Handle(tele.OnPayment, func(c tele.Context) error {
+		p := c.Message().Payment
+		payment, err := service.CompletePayment(p.Payload, p.TelegramChargeID, p.Total, p.Currency)
+		return c.Send(paymentResultText(payment, err))
+	})
+}
+
+// sendPaymentInvoice заводит платёж и выставляет счёт активным провайдером
+func sendPaymentInvoice(c tele.Context, create func(database.User, service.PaymentProvider) (database.Payment, error), title, description string) error {
+	var user database.User
+	if err := database.DB.Where("telegram_id = ?", c.Sender().ID).First(&user).Error; err != nil {
+		return c.Send("❌ Пользователь не найден.")
+	}
+
+	provider, err := service.ActivePaymentProvider()
+	if err != nil {
+		log.Println("Payments:", err)
+		return c.Send("❌ Оплата временно недоступна.")
+	}
+
+	payment, err := create(user, provider)
+	if err != nil {
+		log.Println("Failed to create payment:", err)
+		return c.Send("❌ Не удалось оформить оплату.")
+	}
+
+	if !provider.UsesInvoice() {
+		rm := &tele.ReplyMarkup{}
+		rm.Inline(rm.Row(rm.Data(fmt.Sprintf("✅ Оплатить %d (тест)", payment.Amount), "pay_mock", payment.Payload)))
+		return c.Send(fmt.Sprintf("🧪 Тестовый платёж #%d: %s\n%s", payment.ID, title, description), rm)
+	}
+
+	invoice := &tele.Invoice{
+		Title:       title,
+		Description: description,
+		Payload:     payment.Payload,
+		Currency:    provider.Currency(),
+		Token:       provider.ProviderToken(),
+		Prices:      []tele.Price{{Label: title, Amount: payment.Amount}},
+		Total:       payment.Amount,
+	}
+	_, err = c.Bot().Send(c.Sender(), invoice)
+	return err
+}
+
+func paymentResultText(payment database.Payment, err error) string {
+	switch {
+	case err == nil:
+		return fmt.Sprintf("✅ Оплата получена, спасибо! Начислено: %s.", service.PaymentCreditText(payment))
+	case errors.Is(err, service.ErrPaymentNotPending):
+		return "ℹ️ Этот платёж уже обработан."
+	default:
+		log.Printf("Payment %d failed: %v", payment.ID, err)
+		return fmt.Sprintf("⚠️ Оплата получена, но начислить не удалось. Администратор уже уведомлён, платёж #%d.", payment.ID)
+	}
+}
+
+// planSummary — лимиты тарифа одной строкой для описания счёта
+func planSummary(plan database.Plan) string {
+	traffic := "безлимитный трафик"
+	if plan.TrafficLimit > 0 {
+		traffic = formatBytes(plan.TrafficLimit) + " трафика"
+	}
+	duration := "бессрочно"
+	if plan.DurationDays > 0 {
+		duration = fmt.Sprintf("%d дн.", plan.DurationDays)
+	}
+	return fmt.Sprintf("%s, %s", traffic, duration)
+}
@@ -50,23 +50,227 @@ type User struct {
 	TelegramUsername string `gorm:"index" json:"telegram_username"` // Реальный ник в Телеграм (@nick)
 	TelegramID       int64  `gorm:"index" json:"telegram_id"`       // 0 если создан вручную
 
-	Status string `gorm:"default:'active'" json:"status"` // active, banned, expired
+	Status        string `gorm:"default:'active'" json:"status"` // active, banned, expired
+	ExpiredReason string `json:"expired_reason"`                 // quota | expiry — почему юзер в статусе expired
 
 	// Трафик
-	TrafficLimit int64 `json:"traffic_limit"` // Байт. 0 = безлимит
-	TrafficUsed  int64 `json:"traffic_used"`  // Байт.
+	TrafficLimit    int64 `json:"traffic_limit"`    // Байт. 0 = безлимит
+	TrafficUsed     int64 `json:"traffic_used"`     // Байт. Uplink + Downlink за текущий период
+	TrafficUplink   int64 `json:"traffic_uplink"`   // Байт. Отправлено клиентом (upload)
+	TrafficDownlink int64 `json:"traffic_downlink"` // Байт. Получено клиентом (download)
+
+	// Сброс трафика
+	ResetPeriod string     `gorm:"default:'none'" json:"reset_period"` // none | monthly | weekly | days
+	ResetDay    int        `json:"reset_day"`                          // monthly: число (1-28), weekly: день недели (0=вс), days: длина цикла
+	LastResetAt *time.Time `json:"last_reset_at"`                      // Начало текущего периода. nil = с даты регистрации
 
 	// Подписка
 	ExpiryDate        *time.Time `json:"expiry_date"`
 	SubscriptionToken string     `gorm:"uniqueIndex" json:"subscription_token"`
+
+	// Лимит устройств: разных IP за скользящее окно. При превышении юзер временно убирается из конфига
+	MaxDevices     int        `gorm:"default:0" json:"max_devices"` // 0 = без лимита
+	SuspendedUntil *time.Time `json:"suspended_until"`
+
+	PlanID uint `gorm:"default:0;index" json:"plan_id"` // 0 = без плана, доступны все инбаунды
+
+	NotificationsOff bool `gorm:"default:false" json:"notifications_off"` // не слать предупреждения о трафике и сроке
+
+	// Приглашения
+	InviteCodeID uint `gorm:"default:0" json:"invite_code_id"`       // код, по которому юзер пришёл
+	ReferredByID uint `gorm:"default:0;index" json:"referred_by_id"` // кто пригласил (владелец кода)
+
+	BotBlockedAt *time.Time `json:"bot_blocked_at"` // юзер заблокировал бота; снимается при следующем /start
+}
+
+// InviteCode — код приглашения: /start <code> заводит юзера без заявки.
+// У кода с OwnerUserID владелец получает бонус за каждого приглашённого.
+type InviteCode struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Code        string     `gorm:"uniqueIndex;not null" json:"code"`
+	PlanID      uint       `json:"plan_id"`  // 0 — как при одобрении заявки (30 GB в месяц)
+	MaxUses     int        `json:"max_uses"` // 0 = без ограничения, 1 = одноразовый
+	Uses        int        `json:"uses"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	OwnerUserID uint       `gorm:"index" json:"owner_user_id"` // 0 — код админа
+	Note        string     `json:"note"`
+}
+
+// AccessRequest — заявка на доступ из бота. Одна строка на Telegram ID, повторная заявка переиспользует её
+type AccessRequest struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	TelegramID       int64  `gorm:"uniqueIndex" json:"telegram_id"`
+	TelegramUsername string `json:"telegram_username"`
+	FirstName        string `json:"first_name"`
+
+	Status    string     `gorm:"index;default:'pending'" json:"status"` // pending | approved | rejected | banned
+	Reason    string     `json:"reason"`                                // причина отказа, видна заявителю
+	Attempts  int        `gorm:"default:1" json:"attempts"`
+	DecidedAt *time.Time `json:"decided_at"`
+	DecidedBy int64      `json:"decided_by"` // Telegram ID админа
+	UserID    uint       `json:"user_id"`    // созданный юзер при одобрении
+}
+
+// Plan — тариф: лимиты копируются в юзера при назначении, список инбаундов действует постоянно
+type Plan struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name         string `gorm:"uniqueIndex;not null" json:"name"`
+	Description  string `json:"description"`
+	TrafficLimit int64  `json:"traffic_limit"`                      // Байт. 0 = безлимит
+	ResetPeriod  string `gorm:"default:'none'" json:"reset_period"` // как у User
+	ResetDay     int    `json:"reset_day"`                          // 0 для monthly — день назначения плана
+	MaxDevices   int    `json:"max_devices"`
+	DurationDays int    `json:"duration_days"` // Срок подписки от назначения. 0 = бессрочно
+	PriceStars   int    `json:"price_stars"`   // Цена в боте (Telegram Stars). 0 = не продаётся
+
+	AllowedInbounds JSONStringArray `gorm:"type:text" json:"allowed_inbounds"` // Теги инбаундов. Пусто = все
+}
+
+// Payment — оплата из бота или ручное начисление админом
+type Payment struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID     uint   `gorm:"index" json:"user_id"`
+	TelegramID int64  `json:"telegram_id"`
+	Provider   string `json:"provider"` // stars | mock | manual
+	Kind       string `json:"kind"`     // plan | topup | manual
+	PlanID     uint   `json:"plan_id"`
+	Amount     int    `json:"amount"`                                // в минимальных единицах валюты, для Stars — звёзды
+	Currency   string `json:"currency"`                              // XTR для Stars
+	Status     string `gorm:"index;default:'pending'" json:"status"` // pending | paid | refunded
+	Payload    string `gorm:"uniqueIndex" json:"payload"`            // payload счёта Telegram
+	ChargeID   string `json:"charge_id"`                             // telegram_payment_charge_id
+
+	// Что начислено — это же списывается при возврате
+	CreditedDays  int   `json:"credited_days"`
+	CreditedBytes int64 `json:"credited_bytes"`
+
+	// Покупка перевела юзера на другой тариф — при возврате восстанавливается прежний
+	PlanSwitched bool         `json:"plan_switched"`
+	Previous     PlanSnapshot `gorm:"embedded;embeddedPrefix:prev_" json:"previous"`
+
+	Note       string     `json:"note"`
+	PaidAt     *time.Time `json:"paid_at"`
+	RefundedAt *time.Time `json:"refunded_at"`
+}
+
+// PlanSnapshot — тариф и лимиты юзера на момент покупки другого тарифа
+type PlanSnapshot struct {
+	PlanID       uint       `json:"plan_id"`
+	TrafficLimit int64      `json:"traffic_limit"`
+	MaxDevices   int        `json:"max_devices"`
+	ResetPeriod  string     `json:"reset_period"`
+	ResetDay     int        `json:"reset_day"`
+	ExpiryDate   *time.Time `json:"expiry_date"`
+}
+
+// TrafficPeriod — архив завершённого расчётного периода трафика
+type TrafficPeriod struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID       uint      `gorm:"index" json:"user_id"`
+	PeriodStart  time.Time `json:"period_start"`
+	PeriodEnd    time.Time `json:"period_end"`
+	TrafficUsed  int64     `json:"traffic_used"`
+	Uplink       int64     `json:"uplink"`
+	Downlink     int64     `json:"downlink"`
+	TrafficLimit int64     `json:"traffic_limit"`
 }
 
 type ConnectionLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index" json:"user_id"`
+	ClientIP   string    `json:"client_ip"`
+	InboundTag string    `json:"inbound_tag"`
+	Timestamp  time.Time `gorm:"index" json:"timestamp"`
+	Reason     string    `json:"reason"` // "" — подключение, device_limit — превышен лимит устройств
+}
+
+// NotificationLog — отправленные предупреждения и события лимитов. Уникальный ключ не даёт
+// отправить одно предупреждение дважды за период
+type NotificationLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	UserID    uint   `gorm:"uniqueIndex:idx_notification" json:"user_id"`    // 0 — сводка админу
+	Kind      string `gorm:"uniqueIndex:idx_notification" json:"kind"`       // quota_80 | expiry_3d | limit_quota | limit_expiry | admin_summary
+	PeriodKey string `gorm:"uniqueIndex:idx_notification" json:"period_key"` // период трафика, дата окончания подписки или день сводки
+	Delivered bool   `json:"delivered"`                                      // false — юзер отключил уведомления или без Telegram
+}
+
+// Broadcast — рассылка в бот. Аудитория фиксируется строками BroadcastDelivery при постановке в очередь
+type Broadcast struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"index" json:"user_id"`
-	ClientIP  string    `json:"client_ip"`
-	Timestamp time.Time `gorm:"index" json:"timestamp"`
-	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"` // "" | markdown | html
+	MediaType string `json:"media_type"` // "" | photo | video | document
+	MediaFile string `json:"media_file"` // file_id Telegram или URL
+
+	// Аудитория: пустое/0 — без фильтра
+	FilterStatus   string `json:"filter_status"`     // active | expired | banned
+	FilterPlanID   uint   `json:"filter_plan_id"`    // тариф
+	FilterInbound  string `json:"filter_inbound"`    // тег инбаунда, доступного по тарифу
+	SeenWithinDays int    `json:"seen_within_days"`  // подключался за последние N дней
+	NotSeenForDays int    `json:"not_seen_for_days"` // не подключался N дней
+
+	Status     string     `gorm:"index;default:'draft'" json:"status"` // draft | queued | sending | done | cancelled
+	CreatedBy  string     `json:"created_by"`
+	Total      int        `json:"total"`
+	Sent       int        `json:"sent"`
+	Failed     int        `json:"failed"`
+	Blocked    int        `json:"blocked"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// BroadcastDelivery — доставка рассылки одному юзеру
+type BroadcastDelivery struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	BroadcastID uint       `gorm:"uniqueIndex:idx_broadcast_user" json:"broadcast_id"`
+	UserID      uint       `gorm:"uniqueIndex:idx_broadcast_user" json:"user_id"`
+	TelegramID  int64      `json:"telegram_id"`
+	Status      string     `gorm:"index;default:'pending'" json:"status"` // pending | sent | failed | blocked
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error"`
+	SentAt      *time.Time `json:"sent_at"`
+}
+
+// LogCheckpoint — до какого места прочитан лог, чтобы после рестарта продолжить, а не читать заново
+type LogCheckpoint struct {
+	Path      string `gorm:"primaryKey"`
+	Inode     uint64
+	Offset    int64
+	UpdatedAt time.Time
+}
+
+// TrafficSample — трафик за интервал (час или сутки), время бакета в UTC.
+// Статистика юзера пишется с пустым InboundTag (V2Ray API sing-box не разбивает её по инбаундам),
+// статистика инбаунда — с UserID = 0.
+type TrafficSample struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	UserID      uint      `gorm:"uniqueIndex:idx_traffic_sample" json:"user_id"`
+	InboundTag  string    `gorm:"uniqueIndex:idx_traffic_sample" json:"inbound_tag"`
+	Granularity string    `gorm:"uniqueIndex:idx_traffic_sample" json:"granularity"` // hour | day
+	BucketStart time.Time `gorm:"uniqueIndex:idx_traffic_sample;index" json:"bucket_start"`
+	Uplink      int64     `json:"uplink"`
+	Downlink    int64     `json:"downlink"`
 }
 
 // TelemetConfig — настройки MTProto прокси (синглтон, одна запись)
@@ -139,7 +343,8 @@ type InboundConfig struct {
 	IsBuiltin   bool   `gorm:"default:false" json:"is_builtin"`
 	SortOrder   int    `gorm:"default:0" json:"sort_order"`
 
-	ServerAddress string `json:"server_address"` // Адрес для ссылок (домен или IP). Пусто = SERVER_IP
+	ServerAddress string `json:"server_address"`                 // Адрес для ссылок (домен или IP). Пусто = адрес ноды / SERVER_IP
+	NodeID        uint   `gorm:"default:0;index" json:"node_id"` // 0 = локальный сервер
 
 	// Reality keys (per-inbound)
 	RealityPrivateKey string          `json:"reality_private_key"`
@@ -148,6 +353,110 @@ type InboundConfig struct {
 	Fingerprint       string          `json:"fingerprint"`
 }
 
+// Node — удалённый сервер с sing-box. Локальный сервер (NodeID = 0) в таблице не хранится.
+type Node struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name    string `gorm:"uniqueIndex;not null" json:"name"`
+	Address string `json:"address"` // Публичный IP/домен для ссылок
+	Region  string `json:"region"`
+	Enabled bool   `gorm:"default:true" json:"enabled"`
+
+	// SSH-доступ для выкладки конфига
+	SSHHost    string `json:"ssh_host"` // Пусто = Address
+	SSHPort    int    `gorm:"default:22" json:"ssh_port"`
+	SSHUser    string `gorm:"default:'root'" json:"ssh_user"`
+	SSHKeyPath string `json:"ssh_key_path"` // Пусто = ~/.ssh/id_rsa
+
+	// V2Ray API ноды, host:port. Должен быть доступен только панели (приватная сеть/WireGuard).
+	// Пусто = статистика с ноды не собирается
+	StatsAddr string `json:"stats_addr"`
+
+	// Агент (`vpnbot agent`) на ноде. Если AgentURL задан, конфиги и статистика идут через него, а не по SSH
+	AgentURL    string `json:"agent_url"` // https://10.0.0.2:9090
+	AgentSecret string `json:"-"`         // HMAC-ключ агента; в API только на запись
+}
+
+// ConfigRevision — отрендеренный конфиг sing-box или telemt, как он был выложен на ноду.
+// Подряд идущие одинаковые конфиги одной ноды не дублируются.
+type ConfigRevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Kind    string `gorm:"index:idx_config_revision_target" json:"kind"` // "singbox" | "telemt"
+	NodeID  uint   `gorm:"index:idx_config_revision_target" json:"node_id"`
+	Hash    string `json:"hash"` // sha256 содержимого
+	Trigger string `json:"trigger"`
+	Error   string `json:"error,omitempty"` // Ошибка применения, если нода отвергла конфиг
+	Content string `json:"content,omitempty"`
+}
+
+// AdminAccount — учётная запись админки. Роли: owner, operator, support
+type AdminAccount struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Username     string     `gorm:"uniqueIndex;not null" json:"username"`
+	PasswordHash string     `json:"-"` // bcrypt
+	Role         string     `gorm:"not null" json:"role"`
+	Disabled     bool       `gorm:"default:false" json:"disabled"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	TelegramID   int64      `gorm:"index" json:"telegram_id"` // Для входа через Telegram; 0 — не привязан
+
+	// TOTP (RFC 6238). Секрет задаётся при setup, но действует только после подтверждения кодом
+	TOTPSecret    string `json:"-"`
+	TOTPEnabled   bool   `gorm:"default:false" json:"totp_enabled"`
+	TOTPLastStep  int64  `json:"-"` // Последний принятый 30-секундный шаг — защита от повтора кода
+	RecoveryCodes string `json:"-"` // sha256 неиспользованных кодов восстановления через \n
+}
+
+// AdminSession — сессия админки: refresh-токен (хранится только хэш) и jti текущего access-токена
+type AdminSession struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	AccountID       uint       `gorm:"index" json:"account_id"`
+	RefreshHash     string     `gorm:"uniqueIndex" json:"-"`
+	PrevRefreshHash string     `gorm:"index" json:"-"` // Предыдущий refresh — повторное его использование означает утечку
+	AccessJTI       string     `gorm:"index" json:"-"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	IP              string     `json:"ip"`
+	UserAgent       string     `json:"user_agent"`
+}
+
+// AdminLoginToken — одноразовая ссылка входа в панель, выданная ботом по /panel
+type AdminLoginToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	AccountID uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"` // sha256 токена из ссылки
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// AuditEvent — запись о мутирующем вызове API админки
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	AccountID  uint   `gorm:"index" json:"account_id"` // 0 — без авторизации (например, /api/login)
+	Username   string `gorm:"index" json:"username"`
+	IP         string `json:"ip"`
+	Method     string `json:"method"`
+	Route      string `gorm:"index" json:"route"` // Шаблон маршрута: /api/users/:id/status
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Body       string `json:"body"` // JSON тела запроса с вырезанными секретами
+	DurationMs int64  `json:"duration_ms"`
+}
+
 // --- Init ---
 
 func Init(path string) {
@@ -158,7 +467,7 @@ func Init(path string) {
 	}
 
 	// Миграция схемы
-	err = DB.AutoMigrate(&User{}, &ConnectionLog{}, &InboundConfig{}, &TelemetConfig{}, &TelemetUser{}, &TurnConfig{})
+	err = DB.AutoMigrate(&User{}, &Plan{}, &Payment{}, &AccessRequest{}, &InviteCode{}, &ConnectionLog{}, &LogCheckpoint{}, &NotificationLog{}, &Broadcast{}, &BroadcastDelivery{}, &InboundConfig{}, &TelemetConfig{}, &TelemetUser{}, &TurnConfig{}, &TrafficSample{}, &TrafficPeriod{}, &Node{}, &ConfigRevision{}, &AdminAccount{}, &AdminSession{}, &AdminLoginToken{}, &AuditEvent{})
 	if err != nil {
 		log.Fatal("Migration failed:", err)
 	}
@@ -166,6 +475,12 @@ func Init(path string) {
 	// Одноразовая миграция: перенос Reality-ключей из system_settings в inbound_configs
 	migrateRealityKeysFromSettings()
 
+	// Одноразовая миграция: причина expired для юзеров, отключённых до появления поля
+	migrateExpiredReason()
+
+	// Одноразовая миграция: исторический TrafficUsed считаем скачанным (download)
+	migrateTrafficDirections()
+
 	// 1. Инициализация твоего существующего юзера MRiaz
 	var oldUser User
 	if result := DB.Where("username = ?", "MRiaz").First(&oldUser); result.Error != nil {
@@ -316,6 +631,26 @@ func migrateRealityKeysFromSettings() {
 		})
 }
 
+// migrateExpiredReason проставляет expired_reason юзерам, которых checkLimits отключил по квоте
+// до появления этого поля, чтобы сброс трафика мог их реактивировать.
+func migrateExpiredReason() {
+	DB.Model(&User{}).
+		Where("status = ? AND (expired_reason = '' OR expired_reason IS NULL)", "expired").
+		Where("traffic_limit > 0 AND traffic_used >= traffic_limit").
+		Update("expired_reason", "quota")
+}
+
+// migrateTrafficDirections заполняет TrafficDownlink для юзеров, чей трафик накоплен
+// до раздельного учёта направлений: весь TrafficUsed считается download.
+func migrateTrafficDirections() {
+	result := DB.Model(&User{}).
+		Where("traffic_used > 0 AND traffic_uplink = 0 AND traffic_downlink = 0").
+		Update("traffic_downlink", gorm.Expr("traffic_used"))
+	if result.RowsAffected > 0 {
+		log.Printf("Migration: backfilled traffic_downlink for %d users", result.RowsAffected)
+	}
+}
+
 // Helper: Создать токен
 func GenerateToken() string {
 	return uuid.New().String()
@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"vpnbot/agent"
 	"vpnbot/api/router"
 	"vpnbot/bot"
 	"vpnbot/database"
@@ -13,13 +15,53 @@ import (
 )
 
 func main() {
+	// `vpnbot agent` — режим агента на удалённой ноде, без БД, бота и панели
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		agent.Run()
+		return
+	}
+
 	database.Init("vpn.db")
 
-	err := service.GenerateAndReload()
+	adminID := int64(124343839)
+	adminIDFromEnv := false
+	if envAdminID := os.Getenv("ADMIN_ID"); envAdminID != "" {
+		if parsed, err := strconv.ParseInt(envAdminID, 10, 64); err == nil {
+			adminID = parsed
+			adminIDFromEnv = true
+		}
+	}
+
+	// Первый owner-аккаунт админки из ADMIN_PASSWORD; ADMIN_ID бота входит в панель как owner.
+	// Привязываем только явно заданный ADMIN_ID, не запасной по умолчанию.
+	service.EnsureAdminAccount()
+	if adminIDFromEnv {
+		service.LinkBotAdminTelegramID(adminID)
+	}
+
+	err := service.ReloadNow("startup")
 	if err != nil {
 		log.Println("Error generating initial config:", err)
 	}
 
+	// Фоновая проверка ExpiryDate
+	service.StartExpiryScheduler()
+
+	// Предупреждения о трафике и подписке, сводка админу
+	service.StartNotificationScheduler()
+
+	// Очередь рассылок в бот
+	service.StartBroadcastWorker()
+
+	// Периодический сброс трафика
+	service.StartTrafficResetScheduler()
+
+	// Свёртка и очистка истории трафика
+	service.StartTrafficMaintenance()
+
+	// Журнал подключений и лимит устройств по access.log sing-box
+	service.StartAccessLogTailer()
+
 	// Настройка telemt (MTProto proxy) если включён
 	if err := service.SetupTelemet(); err != nil {
 		log.Println("Error setting up telemt:", err)
@@ -31,12 +73,6 @@ func main() {
 	}
 
 	botToken := os.Getenv("BOT_TOKEN")
-	adminID := int64(124343839)
-	if envAdminID := os.Getenv("ADMIN_ID"); envAdminID != "" {
-		if parsed, err := strconv.ParseInt(envAdminID, 10, 64); err == nil {
-			adminID = parsed
-		}
-	}
 
 	if botToken != "" {
 		go bot.Start(botToken, adminID)
@@ -45,6 +81,17 @@ func main() {
 	}
 
 	r := gin.Default()
+	// X-Forwarded-For принимаем только от доверенных прокси (TRUSTED_PROXIES через запятую),
+	// иначе c.ClientIP() подделывается и троттлинг входа по IP обходится
+	var trustedProxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 	router.SetupRouter(r)
 
 	log.Println("Server starting on :8085")
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm/clause"
+)
+
+// AccessLogPath — лог sing-box на локальном сервере. Логи удалённых нод сюда не попадают,
+// поэтому журнал подключений и лимит устройств покрывают только локальные инбаунды.
+const AccessLogPath = "/etc/sing-box/access.log"
+
+const (
+	accessLogPollInterval     = time.Second
+	connectionCleanupInterval = time.Hour
+)
+
+// connectionLogRetention — сколько хранить ConnectionLog. CONNECTION_LOG_RETENTION_DAYS, по умолчанию 30.
+func connectionLogRetention() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("CONNECTION_LOG_RETENTION_DAYS")); err == nil && v > 0 {
+		return time.Duration(v) * 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
+
+// logTail читает файл построчно с запомненного смещения
+type logTail struct {
+	file   *os.File
+	reader *bufio.Reader
+	inode  uint64
+	offset int64
+}
+
+func (t *logTail) open(path string, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	t.close()
+	t.file, t.reader, t.inode, t.offset = f, bufio.NewReader(f), fileInode(info), offset
+	return nil
+}
+
+func (t *logTail) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// readLines отдаёт все полные строки; неполную последнюю дочитает следующий вызов
+func (t *logTail) readLines(fn func(line string)) {
+	if t.file == nil {
+		return
+	}
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			if len(line) > 0 {
+				t.file.Seek(t.offset, io.SeekStart)
+				t.reader.Reset(t.file)
+			}
+			return
+		}
+		t.offset += int64(len(line))
+		fn(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func loadLogCheckpoint(path string) (database.LogCheckpoint, bool) {
+	var cp database.LogCheckpoint
+	err := database.DB.Where("path = ?", path).First(&cp).Error
+	return cp, err == nil
+}
+
+func saveLogCheckpoint(path string, inode uint64, offset int64) {
+	cp := database.LogCheckpoint{Path: path, Inode: inode, Offset: offset}
+	if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cp).Error; err != nil {
+		log.Println("Failed to save log checkpoint:", err)
+	}
+}
+
+// resumeAccessLog открывает access.log с места, где чтение остановилось до рестарта.
+// Если файл за это время ротировали, сначала дочитывается хвост access.log.1.
+func resumeAccessLog(tail *logTail, handle func(string)) {
+	info, err := os.Stat(AccessLogPath)
+	if err != nil {
+		return
+	}
+
+	cp, ok := loadLogCheckpoint(AccessLogPath)
+	if !ok {
+		// Первый запуск: старую историю не импортируем, иначе она посчитается как текущие устройства
+		if tail.open(AccessLogPath, info.Size()) == nil {
+			saveLogCheckpoint(AccessLogPath, tail.inode, tail.offset)
+		}
+		return
+	}
+
+	if fileInode(info) == cp.Inode && cp.Offset <= info.Size() {
+		tail.open(AccessLogPath, cp.Offset)
+		return
+	}
+
+	rotated := AccessLogPath + ".1"
+	if old, err := os.Stat(rotated); err == nil && fileInode(old) == cp.Inode {
+		var prev logTail
+		if prev.open(rotated, cp.Offset) == nil {
+			prev.readLines(handle)
+			prev.close()
+			log.Printf("Access log: caught up %d bytes from rotated %s", prev.offset-cp.Offset, rotated)
+		}
+	}
+	tail.open(AccessLogPath, 0)
+}
+
+// StartAccessLogTailer читает новые строки access.log sing-box в ConnectionLog и следит за
+// лимитом устройств. Позиция чтения сохраняется в LogCheckpoint после записи строк в БД, поэтому
+// рестарт не теряет и не удваивает подключения. Ротация (новый файл) и усечение (copytruncate)
+// переживаются: старый файл дочитывается до конца, новый читается с начала.
+func StartAccessLogTailer() {
+	go func() {
+		tail := &logTail{}
+		handle := func(line string) { devices.handleLine(line, time.Now()) }
+
+		resumeAccessLog(tail, handle)
+		devices.flush(time.Now())
+
+		var savedInode uint64
+		var savedOffset int64 = -1
+		lastCleanup := time.Time{}
+
+		ticker := time.NewTicker(accessLogPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+
+			if info, err := os.Stat(AccessLogPath); err == nil {
+				switch {
+				case tail.file == nil:
+					tail.open(AccessLogPath, 0)
+				case fileInode(info) != tail.inode:
+					tail.readLines(handle)
+					tail.open(AccessLogPath, 0)
+				case info.Size() < tail.offset:
+					tail.open(AccessLogPath, 0)
+				}
+			}
+			tail.readLines(handle)
+			devices.flush(now)
+
+			if tail.file != nil && (tail.inode != savedInode || tail.offset != savedOffset) {
+				saveLogCheckpoint(AccessLogPath, tail.inode, tail.offset)
+				savedInode, savedOffset = tail.inode, tail.offset
+			}
+
+			if now.Sub(lastCleanup) >= connectionCleanupInterval {
+				lastCleanup = now
+				res := database.DB.Where("timestamp < ?", now.Add(-connectionLogRetention())).Delete(&database.ConnectionLog{})
+				if res.RowsAffected > 0 {
+					log.Printf("Connection log cleanup: removed %d rows", res.RowsAffected)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"vpnbot/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Роли админки
+const (
+	RoleOwner    = "owner"    // всё, включая управление аккаунтами
+	RoleOperator = "operator" // пользователи и инфраструктура
+	RoleSupport  = "support"  // просмотр пользователей и сброс квоты
+)
+
+// MinAdminPasswordLength — минимальная длина пароля аккаунта админки
+const MinAdminPasswordLength = 8
+
+// ValidateAdminRole проверяет, что роль известна
+func ValidateAdminRole(role string) error {
+	switch role {
+	case RoleOwner, RoleOperator, RoleSupport:
+		return nil
+	}
+	return fmt.Errorf("role must be one of owner, operator, support")
+}
+
+// HashAdminPassword хэширует пароль bcrypt'ом
+func HashAdminPassword(password string) (string, error) {
+	if len(password) < MinAdminPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinAdminPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckAdminPassword сравнивает пароль с bcrypt-хэшем аккаунта
+func CheckAdminPassword(account database.AdminAccount, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) == nil
+}
+
+// EnsureAdminAccount создаёт аккаунт owner "admin" из ADMIN_PASSWORD, если аккаунтов ещё нет.
+// После этого ADMIN_PASSWORD больше не используется — пароли живут в БД.
+func EnsureAdminAccount() {
+	var count int64
+	database.DB.Model(&database.AdminAccount{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		log.Println("No admin accounts and ADMIN_PASSWORD not set: admin panel login is unavailable")
+		return
+	}
+
+	// Старый ADMIN_PASSWORD мог быть короче минимума — для первичного аккаунта не ограничиваем
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash ADMIN_PASSWORD:", err)
+		return
+	}
+
+	account := database.AdminAccount{Username: "admin", PasswordHash: string(hash), Role: RoleOwner}
+	if err := database.DB.Create(&account).Error; err != nil {
+		log.Println("Failed to create admin account:", err)
+		return
+	}
+	log.Println("Created owner admin account 'admin' from ADMIN_PASSWORD")
+}
+
+// CountActiveOwners — сколько включённых owner-аккаунтов, кроме excludeID
+func CountActiveOwners(excludeID uint) int64 {
+	var count int64
+	database.DB.Model(&database.AdminAccount{}).
+		Where("role = ? AND disabled = ? AND id != ?", RoleOwner, false, excludeID).
+		Count(&count)
+	return count
+}
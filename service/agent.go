@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vpnbot/database"
+)
+
+// Заголовки подписи запросов панель → агент
+const (
+	AgentTimestampHeader = "X-Agent-Timestamp"
+	AgentNonceHeader     = "X-Agent-Nonce"
+	AgentSignatureHeader = "X-Agent-Signature"
+)
+
+// AgentMaxClockSkew — допустимое расхождение часов панели и агента
+const AgentMaxClockSkew = 5 * time.Minute
+
+// SignAgentRequest считает HMAC-SHA256 от метода, пути, времени, случайного nonce и sha256 тела запроса.
+// Nonce отличает одинаковые запросы, отправленные в одну секунду: по нему агент ловит повторы.
+func SignAgentRequest(secret, method, path string, ts int64, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n%s", method, path, ts, nonce, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewAgentNonce — случайный nonce для подписи запроса к агенту
+func NewAgentNonce() string {
+	return randomToken(16)
+}
+
+// VerifyAgentRequest проверяет подпись и свежесть запроса, пришедшего на агент
+func VerifyAgentRequest(secret string, r *http.Request, body []byte) error {
+	ts, err := strconv.ParseInt(r.Header.Get(AgentTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s", AgentTimestampHeader)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > AgentMaxClockSkew || skew < -AgentMaxClockSkew {
+		return fmt.Errorf("request timestamp is outside the allowed window")
+	}
+
+	nonce := r.Header.Get(AgentNonceHeader)
+	if nonce == "" || len(nonce) > 128 {
+		return fmt.Errorf("missing or invalid %s", AgentNonceHeader)
+	}
+
+	expected := SignAgentRequest(secret, r.Method, r.URL.Path, ts, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(AgentSignatureHeader))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// InstallTelemetConfig записывает конфиг telemt и перезапускает сервис
+func InstallTelemetConfig(data []byte) error {
+	if err := writeTelemetConfig(data); err != nil {
+		return err
+	}
+	return ReloadTelemet()
+}
+
+// --- Удалённая нода через агент ---
+
+type agentNode struct {
+	node database.Node
+}
+
+func (n *agentNode) ID() uint     { return n.node.ID }
+func (n *agentNode) Name() string { return n.node.Name }
+
+// StatsListen — агент сам опрашивает V2Ray API на своей машине, наружу он не торчит
+func (n *agentNode) StatsListen() string { return ApiAddr }
+
+var (
+	agentClientOnce sync.Once
+	agentClient     *http.Client
+)
+
+// agentHTTPClient собирает HTTP-клиент для агентов.
+// AGENT_CA_CERT — CA, которым подписаны сертификаты агентов (для самоподписанных),
+// AGENT_CLIENT_CERT/AGENT_CLIENT_KEY — клиентский сертификат панели для mTLS.
+func agentHTTPClient() *http.Client {
+	agentClientOnce.Do(func() {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if caPath := os.Getenv("AGENT_CA_CERT"); caPath != "" {
+			pem, err := os.ReadFile(caPath)
+			if err != nil {
+				log.Println("Failed to read AGENT_CA_CERT:", err)
+			} else {
+				pool := x509.NewCertPool()
+				pool.AppendCertsFromPEM(pem)
+				tlsConfig.RootCAs = pool
+			}
+		}
+
+		certPath, keyPath := os.Getenv("AGENT_CLIENT_CERT"), os.Getenv("AGENT_CLIENT_KEY")
+		if certPath != "" && keyPath != "" {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				log.Println("Failed to load agent client certificate:", err)
+			} else {
+				tlsConfig.Certificates = []tls.Certificate{cert}
+			}
+		}
+
+		agentClient = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+	})
+	return agentClient
+}
+
+// call выполняет подписанный запрос к агенту и декодирует JSON-ответ в out
+func (n *agentNode) call(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, n.node.AgentURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	nonce := NewAgentNonce()
+	req.Header.Set(AgentTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(AgentNonceHeader, nonce)
+	req.Header.Set(AgentSignatureHeader, SignAgentRequest(n.node.AgentSecret, method, path, ts, nonce, body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := agentHTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("агент %s недоступен: %w", n.node.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		// Агент отверг конфиг — пробрасываем вывод sing-box как есть
+		checkErr := &ConfigCheckError{}
+		if err := json.NewDecoder(resp.Body).Decode(checkErr); err != nil {
+			return fmt.Errorf("агент %s: %s", n.node.Name, resp.Status)
+		}
+		return checkErr
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(raw, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(raw))
+		}
+		return fmt.Errorf("агент %s: %s: %s", n.node.Name, resp.Status, apiErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (n *agentNode) ApplySingboxConfig(data []byte) error {
+	if err := n.call(http.MethodPost, "/v1/singbox", data, nil); err != nil {
+		return err
+	}
+	log.Printf("Sing-box config pushed to node %s via agent", n.node.Name)
+	return nil
+}
+
+func (n *agentNode) ApplyTelemetConfig(data []byte) error {
+	return n.call(http.MethodPost, "/v1/telemt", data, nil)
+}
+
+func (n *agentNode) QueryStats() ([]StatEntry, error) {
+	var stats []StatEntry
+	err := n.call(http.MethodGet, "/v1/stats", nil, &stats)
+	return stats, err
+}
+
+func (n *agentNode) Health() (NodeHealth, error) {
+	var health NodeHealth
+	err := n.call(http.MethodGet, "/v1/health", nil, &health)
+	return health, err
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"vpnbot/database"
+)
+
+const testAgentSecret = "test-agent-secret"
+
+// signedAgentRequest — запрос, подписанный так же, как его подписывает панель
+func signedAgentRequest(secret, method, path string, ts int64, nonce string, body []byte) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(string(body)))
+	r.Header.Set(AgentTimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(AgentNonceHeader, nonce)
+	r.Header.Set(AgentSignatureHeader, SignAgentRequest(secret, method, path, ts, nonce, body))
+	return r
+}
+
+func TestSignAgentRequest(t *testing.T) {
+	base := SignAgentRequest(testAgentSecret, "POST", "/v1/singbox", 1700000000, "n1", []byte("{}"))
+	if base != SignAgentRequest(testAgentSecret, "POST", "/v1/singbox", 1700000000, "n1", []byte("{}")) {
+		t.Fatal("signature is not deterministic")
+	}
+
+	// Любая подписанная часть запроса меняет подпись
+	variants := map[string]string{
+		"secret": SignAgentRequest("other", "POST", "/v1/singbox", 1700000000, "n1", []byte("{}")),
+		"method": SignAgentRequest(testAgentSecret, "GET", "/v1/singbox", 1700000000, "n1", []byte("{}")),
+		"path":   SignAgentRequest(testAgentSecret, "POST", "/v1/telemt", 1700000000, "n1", []byte("{}")),
+		"time":   SignAgentRequest(testAgentSecret, "POST", "/v1/singbox", 1700000001, "n1", []byte("{}")),
+		"nonce":  SignAgentRequest(testAgentSecret, "POST", "/v1/singbox", 1700000000, "n2", []byte("{}")),
+		"body":   SignAgentRequest(testAgentSecret, "POST", "/v1/singbox", 1700000000, "n1", []byte("{ }")),
+	}
+	for name, sig := range variants {
+		if sig == base {
+			t.Errorf("changing %s does not change the signature", name)
+		}
+	}
+
+	if NewAgentNonce() == NewAgentNonce() {
+		t.Error("NewAgentNonce returned the same nonce twice")
+	}
+}
+
+func TestVerifyAgentRequest(t *testing.T) {
+	now := time.Now().Unix()
+	skew := int64(AgentMaxClockSkew / time.Second)
+	body := []byte(`{"inbounds":[]}`)
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		wantOK bool
+	}{
+		{"valid", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+		}, true},
+		{"clock slightly behind", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now-skew+10, "nonce", body)
+		}, true},
+		{"clock slightly ahead", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now+skew-10, "nonce", body)
+		}, true},
+		{"too old", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now-skew-10, "nonce", body)
+		}, false},
+		{"too far in the future", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now+skew+10, "nonce", body)
+		}, false},
+		{"wrong secret", func() *http.Request {
+			return signedAgentRequest("other", "POST", "/v1/singbox", now, "nonce", body)
+		}, false},
+		{"missing timestamp", func() *http.Request {
+			r := signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+			r.Header.Del(AgentTimestampHeader)
+			return r
+		}, false},
+		{"missing nonce", func() *http.Request {
+			r := signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+			r.Header.Del(AgentNonceHeader)
+			return r
+		}, false},
+		{"nonce too long", func() *http.Request {
+			return signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, strings.Repeat("n", 129), body)
+		}, false},
+		{"nonce swapped", func() *http.Request {
+			r := signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+			r.Header.Set(AgentNonceHeader, "other")
+			return r
+		}, false},
+		{"signed for another path", func() *http.Request {
+			r := signedAgentRequest(testAgentSecret, "POST", "/v1/telemt", now, "nonce", body)
+			r.URL.Path = "/v1/singbox"
+			return r
+		}, false},
+		{"missing signature", func() *http.Request {
+			r := signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+			r.Header.Del(AgentSignatureHeader)
+			return r
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyAgentRequest(testAgentSecret, tt.req(), body)
+			if tt.wantOK && err != nil {
+				t.Errorf("VerifyAgentRequest() = %v, want nil", err)
+			}
+			if !tt.wantOK && err == nil {
+				t.Error("VerifyAgentRequest() = nil, want error")
+			}
+		})
+	}
+
+	// Подпись покрывает тело
+	r := signedAgentRequest(testAgentSecret, "POST", "/v1/singbox", now, "nonce", body)
+	if err := VerifyAgentRequest(testAgentSecret, r, []byte(`{"inbounds":[1]}`)); err == nil {
+		t.Error("tampered body was accepted")
+	}
+}
+
+// TestAgentNodeRoundTrip — клиент панели против сервера, проверяющего подпись как агент
+func TestAgentNodeRoundTrip(t *testing.T) {
+	var gotBody []byte
+	nonces := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifyAgentRequest(testAgentSecret, r, body); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		nonce := r.Header.Get(AgentNonceHeader)
+		if nonces[nonce] {
+			t.Errorf("nonce %q reused", nonce)
+		}
+		nonces[nonce] = true
+
+		switch r.URL.Path {
+		case "/v1/health":
+			json.NewEncoder(w).Encode(NodeHealth{SingboxActive: true, SingboxVersion: "1.0"})
+		case "/v1/singbox":
+			gotBody = body
+			if strings.Contains(string(body), "bad") {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(ConfigCheckError{Output: "decode config: bad", RolledBack: true})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"message": "Config applied"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Not found"})
+		}
+	}))
+	defer srv.Close()
+
+	node := &agentNode{node: database.Node{Name: "test", AgentURL: srv.URL, AgentSecret: testAgentSecret}}
+
+	health, err := node.Health()
+	if err != nil || !health.SingboxActive || health.SingboxVersion != "1.0" {
+		t.Fatalf("Health() = %+v, %v", health, err)
+	}
+	if _, err := node.Health(); err != nil {
+		t.Fatalf("second Health() = %v", err)
+	}
+
+	if err := node.ApplySingboxConfig([]byte(`{"ok":true}`)); err != nil {
+		t.Fatalf("ApplySingboxConfig() = %v", err)
+	}
+	if string(gotBody) != `{"ok":true}` {
+		t.Errorf("agent got body %q", gotBody)
+	}
+
+	var checkErr *ConfigCheckError
+	err = node.ApplySingboxConfig([]byte(`{"bad":true}`))
+	if !errors.As(err, &checkErr) || !checkErr.RolledBack || checkErr.Output != "decode config: bad" {
+		t.Errorf("ApplySingboxConfig(bad) = %v, want ConfigCheckError", err)
+	}
+
+	if err := node.ApplyTelemetConfig([]byte("x")); err == nil || !strings.Contains(err.Error(), "Not found") {
+		t.Errorf("ApplyTelemetConfig() = %v, want agent error", err)
+	}
+
+	wrong := &agentNode{node: database.Node{Name: "test", AgentURL: srv.URL, AgentSecret: "other"}}
+	if _, err := wrong.Health(); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Health() with a wrong secret = %v, want invalid signature", err)
+	}
+}
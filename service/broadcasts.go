@@ -0,0 +1,458 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"vpnbot/database"
+
+	"gorm.io/gorm"
+)
+
+// Статусы рассылки
+const (
+	BroadcastDraft     = "draft"
+	BroadcastQueued    = "queued"
+	BroadcastSending   = "sending"
+	BroadcastDone      = "done"
+	BroadcastCancelled = "cancelled"
+)
+
+// Статусы доставки одному юзеру
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliveryBlocked = "blocked"
+)
+
+// broadcastMaxAttempts — сколько раз отправлять одному юзеру, если Telegram просит подождать (429)
+const broadcastMaxAttempts = 5
+
+var (
+	ErrRecipientBlocked  = errors.New("recipient blocked the bot")
+	ErrBroadcastNotDraft = errors.New("broadcast is already queued or finished")
+	ErrBroadcastFinished = errors.New("broadcast is already finished")
+	ErrBroadcastEmpty    = errors.New("no recipients match the filters")
+)
+
+// RetryAfterError — Telegram ограничил частоту отправки и просит повторить через After
+type RetryAfterError struct {
+	After time.Duration
+}
+
+func (e RetryAfterError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %s", e.After)
+}
+
+// Отправка рассылок. Бот регистрирует отправителя при старте; без бота очередь ждёт.
+var (
+	broadcastMu     sync.RWMutex
+	broadcastSender func(telegramID int64, bc database.Broadcast) error
+	broadcastWake   = make(chan struct{}, 1)
+)
+
+// SetBroadcastSender регистрирует отправку сообщения рассылки одному юзеру.
+// Отправитель возвращает ErrRecipientBlocked, если юзер заблокировал бота, и RetryAfterError на 429.
+func SetBroadcastSender(send func(telegramID int64, bc database.Broadcast) error) {
+	broadcastMu.Lock()
+	broadcastSender = send
+	broadcastMu.Unlock()
+	wakeBroadcastWorker()
+}
+
+func currentBroadcastSender() func(telegramID int64, bc database.Broadcast) error {
+	broadcastMu.RLock()
+	defer broadcastMu.RUnlock()
+	return broadcastSender
+}
+
+func wakeBroadcastWorker() {
+	select {
+	case broadcastWake <- struct{}{}:
+	default:
+	}
+}
+
+// broadcastRate — сообщений в секунду. BROADCAST_RATE, по умолчанию 20 (лимит Telegram — около 30).
+func broadcastRate() int {
+	if v, err := strconv.Atoi(os.Getenv("BROADCAST_RATE")); err == nil && v > 0 {
+		return min(v, 30)
+	}
+	return 20
+}
+
+// MarkBotBlocked помечает юзера, заблокировавшего бота: рассылки его пропускают
+func MarkBotBlocked(telegramID int64) {
+	database.DB.Model(&database.User{}).Where("telegram_id = ? AND bot_blocked_at IS NULL", telegramID).
+		Update("bot_blocked_at", time.Now())
+}
+
+// ClearBotBlocked снимает пометку, когда юзер снова пишет боту
+func ClearBotBlocked(telegramID int64) {
+	database.DB.Model(&database.User{}).Where("telegram_id = ? AND bot_blocked_at IS NOT NULL", telegramID).
+		Update("bot_blocked_at", nil)
+}
+
+// validateBroadcast нормализует и проверяет текст, медиа и фильтры рассылки
+func validateBroadcast(bc *database.Broadcast) error {
+	bc.Text = strings.TrimSpace(bc.Text)
+	bc.ParseMode = strings.ToLower(strings.TrimSpace(bc.ParseMode))
+	bc.MediaType = strings.ToLower(strings.TrimSpace(bc.MediaType))
+	bc.MediaFile = strings.TrimSpace(bc.MediaFile)
+
+	switch bc.ParseMode {
+	case "", "markdown", "html":
+	default:
+		return fmt.Errorf("parse_mode must be empty, markdown or html")
+	}
+
+	maxLen := 4096
+	switch bc.MediaType {
+	case "":
+		bc.MediaFile = ""
+	case "photo", "video", "document":
+		if bc.MediaFile == "" {
+			return fmt.Errorf("media_file is required for media_type %s", bc.MediaType)
+		}
+		maxLen = 1024 // подпись к медиа
+	default:
+		return fmt.Errorf("media_type must be empty, photo, video or document")
+	}
+	if bc.Text == "" && bc.MediaType == "" {
+		return fmt.Errorf("text or media is required")
+	}
+	if utf8.RuneCountInString(bc.Text) > maxLen {
+		return fmt.Errorf("text is longer than %d characters", maxLen)
+	}
+
+	switch bc.FilterStatus {
+	case "", "active", "expired", "banned":
+	default:
+		return fmt.Errorf("filter_status must be empty, active, expired or banned")
+	}
+	if bc.FilterPlanID != 0 {
+		var plan database.Plan
+		if err := database.DB.First(&plan, bc.FilterPlanID).Error; err != nil {
+			return fmt.Errorf("plan %d not found", bc.FilterPlanID)
+		}
+	}
+	if bc.FilterInbound != "" {
+		var count int64
+		database.DB.Model(&database.InboundConfig{}).Where("tag = ?", bc.FilterInbound).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("inbound %q not found", bc.FilterInbound)
+		}
+	}
+	if bc.SeenWithinDays < 0 || bc.NotSeenForDays < 0 {
+		return fmt.Errorf("seen_within_days and not_seen_for_days must not be negative")
+	}
+	return nil
+}
+
+// BroadcastAudience — юзеры с Telegram под фильтры рассылки, кроме заблокировавших бота
+func BroadcastAudience(bc database.Broadcast) []database.User {
+	query := database.DB.Where("telegram_id > 0 AND bot_blocked_at IS NULL")
+	if bc.FilterStatus != "" {
+		query = query.Where("status = ?", bc.FilterStatus)
+	}
+	if bc.FilterPlanID != 0 {
+		query = query.Where("plan_id = ?", bc.FilterPlanID)
+	}
+	seen := "SELECT user_id FROM connection_logs WHERE reason = '' AND timestamp > ?"
+	if bc.SeenWithinDays > 0 {
+		query = query.Where("id IN ("+seen+")", time.Now().AddDate(0, 0, -bc.SeenWithinDays))
+	}
+	if bc.NotSeenForDays > 0 {
+		query = query.Where("id NOT IN ("+seen+")", time.Now().AddDate(0, 0, -bc.NotSeenForDays))
+	}
+
+	var users []database.User
+	query.Order("id").Find(&users)
+	if bc.FilterInbound != "" {
+		users = loadInboundAccess().filterUsers(users, bc.FilterInbound)
+	}
+	return users
+}
+
+// CreateBroadcast сохраняет черновик рассылки и считает размер аудитории. Отправка — QueueBroadcast.
+func CreateBroadcast(bc *database.Broadcast) error {
+	if err := validateBroadcast(bc); err != nil {
+		return err
+	}
+	bc.ID = 0
+	bc.Status = BroadcastDraft
+	bc.Sent, bc.Failed, bc.Blocked = 0, 0, 0
+	bc.StartedAt, bc.FinishedAt = nil, nil
+	bc.Total = len(BroadcastAudience(*bc))
+	return database.DB.Create(bc).Error
+}
+
+// PreviewBroadcast отправляет сообщение рассылки одному получателю — обычно админу перед подтверждением
+func PreviewBroadcast(bc database.Broadcast, telegramID int64) error {
+	send := currentBroadcastSender()
+	if send == nil {
+		return fmt.Errorf("bot is not running")
+	}
+	return send(telegramID, bc)
+}
+
+// QueueBroadcast подтверждает черновик: фиксирует аудиторию и ставит рассылку в очередь
+func QueueBroadcast(bc *database.Broadcast) error {
+	if bc.Status != BroadcastDraft {
+		return ErrBroadcastNotDraft
+	}
+	users := BroadcastAudience(*bc)
+	if len(users) == 0 {
+		return ErrBroadcastEmpty
+	}
+
+	deliveries := make([]database.BroadcastDelivery, 0, len(users))
+	for _, u := range users {
+		deliveries = append(deliveries, database.BroadcastDelivery{
+			BroadcastID: bc.ID, UserID: u.ID, TelegramID: u.TelegramID, Status: DeliveryPending,
+		})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&database.Broadcast{}).Where("id = ? AND status = ?", bc.ID, BroadcastDraft).
+			Updates(map[string]interface{}{"status": BroadcastQueued, "total": len(deliveries)})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrBroadcastNotDraft
+		}
+		return tx.CreateInBatches(deliveries, 200).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.Status = BroadcastQueued
+	bc.Total = len(deliveries)
+	log.Printf("Broadcast %d queued for %d users", bc.ID, bc.Total)
+	wakeBroadcastWorker()
+	return nil
+}
+
+// CancelBroadcast отменяет черновик или останавливает отправку. Уже доставленное не отзывается.
+func CancelBroadcast(bc *database.Broadcast) error {
+	now := time.Now()
+	res := database.DB.Model(&database.Broadcast{}).
+		Where("id = ? AND status IN ?", bc.ID, []string{BroadcastDraft, BroadcastQueued, BroadcastSending}).
+		Updates(map[string]interface{}{"status": BroadcastCancelled, "finished_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrBroadcastFinished
+	}
+	refreshBroadcastCounts(bc)
+	return database.DB.First(bc, bc.ID).Error
+}
+
+// refreshBroadcastCounts пересчитывает счётчики доставки по BroadcastDelivery
+func refreshBroadcastCounts(bc *database.Broadcast) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	database.DB.Model(&database.BroadcastDelivery{}).Select("status, COUNT(*) AS count").
+		Where("broadcast_id = ?", bc.ID).Group("status").Scan(&rows)
+
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	bc.Sent, bc.Failed, bc.Blocked = counts[DeliverySent], counts[DeliveryFailed], counts[DeliveryBlocked]
+	database.DB.Model(&database.Broadcast{}).Where("id = ?", bc.ID).
+		Updates(map[string]interface{}{"sent": bc.Sent, "failed": bc.Failed, "blocked": bc.Blocked})
+}
+
+// StartBroadcastWorker — фоновая отправка рассылок по очереди. После рестарта незаконченные продолжаются.
+func StartBroadcastWorker() {
+	go func() {
+		for {
+			runQueuedBroadcasts()
+			select {
+			case <-broadcastWake:
+			case <-time.After(time.Minute):
+			}
+		}
+	}()
+}
+
+func runQueuedBroadcasts() {
+	for {
+		send := currentBroadcastSender()
+		if send == nil {
+			return
+		}
+		var bc database.Broadcast
+		err := database.DB.Where("status IN ?", []string{BroadcastQueued, BroadcastSending}).Order("id").First(&bc).Error
+		if err != nil {
+			return
+		}
+		runBroadcast(&bc, send)
+	}
+}
+
+func broadcastCancelled(id uint) bool {
+	var bc database.Broadcast
+	return database.DB.Select("status").First(&bc, id).Error != nil || bc.Status == BroadcastCancelled
+}
+
+// runBroadcast отправляет ожидающие доставки с ограничением частоты
+func runBroadcast(bc *database.Broadcast, send func(telegramID int64, bc database.Broadcast) error) {
+	if bc.StartedAt == nil {
+		now := time.Now()
+		bc.StartedAt = &now
+	}
+	database.DB.Model(&database.Broadcast{}).Where("id = ? AND status IN ?", bc.ID, []string{BroadcastQueued, BroadcastSending}).
+		Updates(map[string]interface{}{"status": BroadcastSending, "started_at": bc.StartedAt})
+	log.Printf("Broadcast %d: sending", bc.ID)
+
+	ticker := time.NewTicker(time.Second / time.Duration(broadcastRate()))
+	defer ticker.Stop()
+
+	for {
+		var batch []database.BroadcastDelivery
+		database.DB.Where("broadcast_id = ? AND status = ?", bc.ID, DeliveryPending).Order("id").Limit(100).Find(&batch)
+		if len(batch) == 0 {
+			break
+		}
+		for i := range batch {
+			if i%20 == 0 && broadcastCancelled(bc.ID) {
+				refreshBroadcastCounts(bc)
+				log.Printf("Broadcast %d: cancelled", bc.ID)
+				return
+			}
+			<-ticker.C
+			deliverBroadcast(bc, &batch[i], send)
+		}
+		refreshBroadcastCounts(bc)
+	}
+
+	refreshBroadcastCounts(bc)
+	now := time.Now()
+	res := database.DB.Model(&database.Broadcast{}).Where("id = ? AND status = ?", bc.ID, BroadcastSending).
+		Updates(map[string]interface{}{"status": BroadcastDone, "finished_at": now})
+	if res.RowsAffected == 0 {
+		return
+	}
+	bc.Status = BroadcastDone
+	bc.FinishedAt = &now
+	log.Printf("Broadcast %d: done, sent %d, failed %d, blocked %d", bc.ID, bc.Sent, bc.Failed, bc.Blocked)
+	NotifyAdmin(BroadcastReportText(*bc))
+}
+
+// deliverBroadcast отправляет одному юзеру; на 429 ждёт сколько просит Telegram и повторяет
+func deliverBroadcast(bc *database.Broadcast, d *database.BroadcastDelivery, send func(telegramID int64, bc database.Broadcast) error) {
+	var err error
+	for {
+		d.Attempts++
+		err = send(d.TelegramID, *bc)
+		var retry RetryAfterError
+		if !errors.As(err, &retry) || d.Attempts >= broadcastMaxAttempts {
+			break
+		}
+		log.Printf("Broadcast %d: rate limited, waiting %s", bc.ID, retry.After)
+		time.Sleep(retry.After)
+	}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		d.Status = DeliverySent
+		d.SentAt = &now
+		d.Error = ""
+	case errors.Is(err, ErrRecipientBlocked):
+		d.Status = DeliveryBlocked
+		d.Error = err.Error()
+		MarkBotBlocked(d.TelegramID)
+	default:
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+	}
+	if err := database.DB.Save(d).Error; err != nil {
+		log.Printf("Broadcast %d: failed to save delivery %d: %v", bc.ID, d.ID, err)
+	}
+}
+
+var broadcastStatusText = map[string]string{
+	BroadcastDraft:     "черновик",
+	BroadcastQueued:    "в очереди",
+	BroadcastSending:   "отправляется",
+	BroadcastDone:      "завершена",
+	BroadcastCancelled: "отменена",
+}
+
+// BroadcastStatusLabel — статус рассылки по-русски
+func BroadcastStatusLabel(status string) string {
+	if label, ok := broadcastStatusText[status]; ok {
+		return label
+	}
+	return status
+}
+
+// BroadcastFilterText — фильтры аудитории одной строкой
+func BroadcastFilterText(bc database.Broadcast) string {
+	var parts []string
+	if bc.FilterStatus != "" {
+		parts = append(parts, "статус "+bc.FilterStatus)
+	}
+	if bc.FilterPlanID != 0 {
+		var plan database.Plan
+		if database.DB.First(&plan, bc.FilterPlanID).Error == nil {
+			parts = append(parts, "тариф "+plan.Name)
+		} else {
+			parts = append(parts, fmt.Sprintf("тариф #%d", bc.FilterPlanID))
+		}
+	}
+	if bc.FilterInbound != "" {
+		parts = append(parts, "инбаунд "+bc.FilterInbound)
+	}
+	if bc.SeenWithinDays > 0 {
+		parts = append(parts, fmt.Sprintf("подключались за %d дн.", bc.SeenWithinDays))
+	}
+	if bc.NotSeenForDays > 0 {
+		parts = append(parts, fmt.Sprintf("не подключались %d дн.", bc.NotSeenForDays))
+	}
+	if len(parts) == 0 {
+		return "все пользователи"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// BroadcastReportText — отчёт о доставке рассылки
+func BroadcastReportText(bc database.Broadcast) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📨 Рассылка #%d: %s\n", bc.ID, BroadcastStatusLabel(bc.Status))
+	fmt.Fprintf(&sb, "Аудитория: %s\n", BroadcastFilterText(bc))
+	fmt.Fprintf(&sb, "Получателей: %d\n", bc.Total)
+	if bc.Status == BroadcastDraft {
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "✅ Доставлено: %d\n", bc.Sent)
+	fmt.Fprintf(&sb, "🚫 Заблокировали бота: %d\n", bc.Blocked)
+	fmt.Fprintf(&sb, "❌ Ошибок: %d", bc.Failed)
+	if pending := bc.Total - bc.Sent - bc.Blocked - bc.Failed; pending > 0 {
+		fmt.Fprintf(&sb, "\n⏳ Не отправлено: %d", pending)
+	}
+	if bc.StartedAt != nil {
+		end := time.Now()
+		if bc.FinishedAt != nil {
+			end = *bc.FinishedAt
+		}
+		fmt.Fprintf(&sb, "\n⏱ %s", end.Sub(*bc.StartedAt).Round(time.Second))
+	}
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"log"
+	"strings"
+	"vpnbot/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CredentialParts — какие учётные данные юзера перевыпустить
+type CredentialParts struct {
+	UUID              bool `json:"uuid"`               // VLESS/Hysteria2
+	SubscriptionToken bool `json:"subscription_token"` // ссылка подписки и кабинета
+	TelemetSecret     bool `json:"telemt_secret"`      // секрет MTProto-прокси
+}
+
+func (p CredentialParts) Any() bool {
+	return p.UUID || p.SubscriptionToken || p.TelemetSecret
+}
+
+// AllCredentialParts — перевыпустить всё
+var AllCredentialParts = CredentialParts{UUID: true, SubscriptionToken: true, TelemetSecret: true}
+
+// RotateUserCredentials перевыпускает выбранные учётные данные юзера, одним перезапуском
+// применяет sing-box и telemt и присылает юзеру в Telegram новые ссылки.
+// Старые ссылки перестают работать. Ошибка применения конфига возвращается, но новые
+// значения уже в БД и подхватятся следующей перезагрузкой.
+func RotateUserCredentials(user *database.User, parts CredentialParts, trigger string) error {
+	return rotateUserCredentials(user, parts, trigger, true)
+}
+
+// rotateUserCredentials — общая часть перевыпуска. wait = false ставит перезагрузку в очередь
+// и не ждёт её: ошибка применения конфига тогда не возвращается.
+func rotateUserCredentials(user *database.User, parts CredentialParts, trigger string, wait bool) error {
+	if !parts.Any() {
+		return nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{}
+		if parts.UUID {
+			updates["uuid"] = uuid.New().String()
+		}
+		if parts.SubscriptionToken {
+			updates["subscription_token"] = database.GenerateToken()
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(user).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if parts.TelemetSecret {
+			var tus []database.TelemetUser
+			tx.Where("user_id = ?", user.ID).Find(&tus)
+			for _, tu := range tus {
+				if err := tx.Model(&tu).Update("secret", GenerateSecret()).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	database.DB.First(user, user.ID)
+
+	var reloadErr error
+	if parts.UUID || parts.TelemetSecret {
+		if wait {
+			reloadErr = ReloadNow(trigger)
+		} else {
+			RequestReload(trigger)
+		}
+	}
+	log.Printf("Rotated credentials for %s (uuid=%v token=%v telemt=%v): %s",
+		user.Username, parts.UUID, parts.SubscriptionToken, parts.TelemetSecret, trigger)
+
+	notifyNewCredentials(*user, parts)
+	return reloadErr
+}
+
+// notifyNewCredentials присылает юзеру новые ссылки после перевыпуска
+func notifyNewCredentials(user database.User, parts CredentialParts) {
+	if user.TelegramID == 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("🔄 Ваши ключи доступа перевыпущены, старые ссылки больше не работают.\n\n")
+	if parts.UUID || parts.SubscriptionToken {
+		b.WriteString("⭐ Новая ссылка авто-подключения:\n" + SubscriptionURL(user.SubscriptionToken) + "\n\n")
+	}
+	if parts.UUID {
+		b.WriteString("Обновите подписку в приложении или заново добавьте серверы через «🔑 Подключиться».\n\n")
+	}
+	var secrets int64
+	database.DB.Model(&database.TelemetUser{}).Where("user_id = ?", user.ID).Count(&secrets)
+	if parts.TelemetSecret && secrets > 0 {
+		if link, err := TelemetLinkForUser(user, "rotate"); err == nil {
+			b.WriteString("📡 Новая ссылка Telegram Proxy:\n" + link + "\n\n")
+		}
+	}
+	NotifyUser(user.TelegramID, strings.TrimSpace(b.String()))
+}
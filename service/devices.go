@@ -0,0 +1,297 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"vpnbot/database"
+)
+
+const (
+	// connectionLogInterval — не чаще одной записи ConnectionLog на (юзер, IP, инбаунд) за интервал
+	connectionLogInterval = time.Minute
+	// pendingConnTTL — сколько ждать строку с именем юзера после строки "connection from"
+	pendingConnTTL = time.Minute
+)
+
+// deviceLimitSettings — окно подсчёта разных IP и длительность блокировки при превышении.
+// DEVICE_WINDOW_MINUTES (по умолчанию 10) и DEVICE_SUSPEND_MINUTES (по умолчанию 30).
+func deviceLimitSettings() (window, suspend time.Duration) {
+	window, suspend = 10*time.Minute, 30*time.Minute
+	if v, err := strconv.Atoi(os.Getenv("DEVICE_WINDOW_MINUTES")); err == nil && v > 0 {
+		window = time.Duration(v) * time.Minute
+	}
+	if v, err := strconv.Atoi(os.Getenv("DEVICE_SUSPEND_MINUTES")); err == nil && v > 0 {
+		suspend = time.Duration(v) * time.Minute
+	}
+	return window, suspend
+}
+
+// Строки sing-box вида:
+//
+//	+0000 2024-01-02 15:04:05 INFO [3170718837 0ms] inbound/vless[vless-in]: inbound connection from 1.2.3.4:5678
+//	+0000 2024-01-02 15:04:05 INFO [3170718837 2ms] inbound/vless[vless-in]: [user_1] inbound connection to example.com:443
+//
+// IP клиента и имя юзера приходят разными строками одного соединения (общий ID в квадратных скобках).
+var (
+	accessLogLineRe = regexp.MustCompile(`\[(\d+) [^\]]*\] inbound/[\w-]+\[([^\]]+)\]: (?:\[([^\]]+)\] )?inbound (?:packet )?connection (from|to) (\S+)`)
+	ansiEscapeRe    = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+const accessLogTimeLayout = "-0700 2006-01-02 15:04:05"
+
+// accessLogLine — разобранная строка лога подключения
+type accessLogLine struct {
+	At         time.Time
+	ConnID     string
+	InboundTag string
+	Username   string // обычно только в строке "connection to"
+	Direction  string // from | to
+	Addr       string
+}
+
+// parseAccessLogLine разбирает строку access.log sing-box; ok=false для посторонних строк.
+// Время берётся из начала строки, без него — fallback.
+func parseAccessLogLine(line string, fallback time.Time) (accessLogLine, bool) {
+	line = ansiEscapeRe.ReplaceAllString(line, "")
+	m := accessLogLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return accessLogLine{}, false
+	}
+	at := fallback
+	if len(line) >= len(accessLogTimeLayout) {
+		if t, err := time.Parse(accessLogTimeLayout, line[:len(accessLogTimeLayout)]); err == nil {
+			at = t.Local()
+		}
+	}
+	return accessLogLine{At: at, ConnID: m[1], InboundTag: m[2], Username: m[3], Direction: m[4], Addr: m[5]}, true
+}
+
+type pendingConn struct {
+	ip, tag string
+	at      time.Time
+}
+
+type deviceUser struct {
+	id             uint
+	telegramID     int64
+	maxDevices     int
+	suspendedUntil *time.Time
+}
+
+// deviceTracker хранит IP юзеров за окно и принимает решение о блокировке
+type deviceTracker struct {
+	mu sync.Mutex
+
+	pending map[string]pendingConn        // connID → откуда пришло соединение
+	seen    map[uint]map[string]time.Time // userID → IP → последнее подключение
+	logged  map[string]time.Time          // userID|ip|tag → последняя запись ConnectionLog
+	users   map[string]deviceUser         // username → данные юзера
+	batch   []database.ConnectionLog
+
+	usersLoadedAt   time.Time
+	lastSuspendScan time.Time
+}
+
+var devices = &deviceTracker{
+	pending: map[string]pendingConn{},
+	seen:    map[uint]map[string]time.Time{},
+	logged:  map[string]time.Time{},
+	users:   map[string]deviceUser{},
+}
+
+func (t *deviceTracker) loadUsers(now time.Time) {
+	if now.Sub(t.usersLoadedAt) < 30*time.Second {
+		return
+	}
+	var users []database.User
+	database.DB.Select("id", "username", "telegram_id", "max_devices", "suspended_until").Find(&users)
+	t.users = make(map[string]deviceUser, len(users))
+	for _, u := range users {
+		t.users[u.Username] = deviceUser{id: u.ID, telegramID: u.TelegramID, maxDevices: u.MaxDevices, suspendedUntil: u.SuspendedUntil}
+	}
+	t.usersLoadedAt = now
+}
+
+// handleLine учитывает одну строку лога
+func (t *deviceTracker) handleLine(line string, now time.Time) {
+	p, ok := parseAccessLogLine(line, now)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.Direction == "from" {
+		host, _, err := net.SplitHostPort(p.Addr)
+		if err != nil {
+			host = p.Addr
+		}
+		if p.Username == "" {
+			t.pending[p.ConnID] = pendingConn{ip: host, tag: p.InboundTag, at: p.At}
+			return
+		}
+		t.record(p.Username, host, p.InboundTag, p.At, now)
+		return
+	}
+
+	if p.Username == "" {
+		return
+	}
+	conn, ok := t.pending[p.ConnID]
+	if !ok {
+		return
+	}
+	delete(t.pending, p.ConnID)
+	t.record(p.Username, conn.ip, conn.tag, conn.at, now)
+}
+
+// record учитывает подключение юзера в момент at. Строки старше окна (дочитывание после
+// рестарта) попадают в журнал, но в подсчёте устройств не участвуют.
+func (t *deviceTracker) record(username, ip, tag string, at, now time.Time) {
+	t.loadUsers(now)
+	u, ok := t.users[username]
+	if !ok {
+		return
+	}
+
+	key := fmt.Sprintf("%d|%s|%s", u.id, ip, tag)
+	if last, ok := t.logged[key]; !ok || at.Sub(last) >= connectionLogInterval {
+		t.logged[key] = at
+		t.batch = append(t.batch, database.ConnectionLog{UserID: u.id, ClientIP: ip, InboundTag: tag, Timestamp: at})
+	}
+
+	window, suspend := deviceLimitSettings()
+	if now.Sub(at) > window {
+		return
+	}
+
+	ips := t.seen[u.id]
+	if ips == nil {
+		ips = map[string]time.Time{}
+		t.seen[u.id] = ips
+	}
+	if at.After(ips[ip]) {
+		ips[ip] = at
+	}
+
+	if u.maxDevices <= 0 || (u.suspendedUntil != nil && u.suspendedUntil.After(now)) {
+		return
+	}
+
+	active := 0
+	for _, at := range ips {
+		if now.Sub(at) <= window {
+			active++
+		}
+	}
+	if active > u.maxDevices {
+		until := now.Add(suspend)
+		u.suspendedUntil = &until
+		t.users[username] = u
+		delete(t.seen, u.id)
+		go suspendForDevices(username, u, active, until)
+	}
+}
+
+// suspendForDevices убирает юзера из конфига до until и сообщает ему об этом
+func suspendForDevices(username string, u deviceUser, active int, until time.Time) {
+	database.DB.Model(&database.User{}).Where("id = ?", u.id).Update("suspended_until", until)
+	database.DB.Create(&database.ConnectionLog{UserID: u.id, Timestamp: time.Now(), Reason: "device_limit"})
+	log.Printf("Device limit: %s used %d IPs (max %d), suspended until %s", username, active, u.maxDevices, until.Format(time.RFC3339))
+
+	RequestReload(fmt.Sprintf("device limit: %s", username))
+	NotifyUser(u.telegramID, fmt.Sprintf(
+		"⚠️ Превышен лимит устройств: подключения с %d разных адресов при лимите %d.\n\n"+
+			"Доступ приостановлен до %s. Если ссылка попала к посторонним — перевыпустите ключи в меню «📊 Статус».",
+		active, u.maxDevices, until.Format("15:04 02.01.2006")))
+}
+
+// flush пишет накопленные ConnectionLog и чистит устаревшее состояние
+func (t *deviceTracker) flush(now time.Time) {
+	t.mu.Lock()
+	batch := t.batch
+	t.batch = nil
+
+	window, _ := deviceLimitSettings()
+	for id, conn := range t.pending {
+		if now.Sub(conn.at) > pendingConnTTL {
+			delete(t.pending, id)
+		}
+	}
+	for userID, ips := range t.seen {
+		for ip, at := range ips {
+			if now.Sub(at) > window {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(t.seen, userID)
+		}
+	}
+	for key, at := range t.logged {
+		if now.Sub(at) > connectionLogInterval {
+			delete(t.logged, key)
+		}
+	}
+
+	// Закончившиеся блокировки возвращают юзера в конфиг
+	scanFrom := t.lastSuspendScan
+	t.lastSuspendScan = now
+	t.mu.Unlock()
+
+	if len(batch) > 0 {
+		if err := database.DB.CreateInBatches(batch, 100).Error; err != nil {
+			log.Println("Failed to write connection log:", err)
+		}
+	}
+
+	if !scanFrom.IsZero() {
+		var ended int64
+		database.DB.Model(&database.User{}).
+			Where("suspended_until > ? AND suspended_until <= ?", scanFrom, now).Count(&ended)
+		if ended > 0 {
+			RequestReload("device suspension ended")
+		}
+	}
+}
+
+// ActiveDevices — IP юзера, с которых были подключения за текущее окно, от свежих к старым
+func ActiveDevices(userID uint) []DeviceInfo {
+	window, _ := deviceLimitSettings()
+	now := time.Now()
+
+	devices.mu.Lock()
+	defer devices.mu.Unlock()
+
+	result := []DeviceInfo{}
+	for ip, at := range devices.seen[userID] {
+		if now.Sub(at) <= window {
+			result = append(result, DeviceInfo{IP: ip, LastSeen: at})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastSeen.After(result[j].LastSeen) })
+	return result
+}
+
+// DeviceInfo — IP клиента и время последнего подключения с него
+type DeviceInfo struct {
+	IP       string    `json:"ip"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// LiftDeviceSuspension снимает блокировку по лимиту устройств
+func LiftDeviceSuspension(userID uint) {
+	database.DB.Model(&database.User{}).Where("id = ?", userID).Update("suspended_until", nil)
+	devices.mu.Lock()
+	delete(devices.seen, userID)
+	devices.usersLoadedAt = time.Time{}
+	devices.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext — сколько строк контекста вокруг изменений в unified diff
+const diffContext = 3
+
+// maxDiffCells — предел размера таблицы LCS; для больших изменений diff вырождается в «всё заменено»
+const maxDiffCells = 16 << 20
+
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	text string
+}
+
+// UnifiedDiff строит построчный unified diff между a и b. Пустая строка — изменений нет.
+func UnifiedDiff(a, b, fromName, toName string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// aAt[k]/bAt[k] — сколько строк a/b пройдено до операции k
+	aAt := make([]int, len(ops)+1)
+	bAt := make([]int, len(ops)+1)
+	var changes []int
+	for k, op := range ops {
+		aAt[k+1], bAt[k+1] = aAt[k], bAt[k]
+		if op.kind != '+' {
+			aAt[k+1]++
+		}
+		if op.kind != '-' {
+			bAt[k+1]++
+		}
+		if op.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		// Склеиваем изменения, между которыми не больше 2*diffContext общих строк
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext+1 {
+			j++
+		}
+		start := max(0, changes[i]-diffContext)
+		end := min(len(ops), changes[j]+diffContext+1)
+
+		aCount, bCount := aAt[end]-aAt[start], bAt[end]-bAt[start]
+		aStart, bStart := aAt[start]+1, bAt[start]+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			sb.WriteByte('\n')
+		}
+
+		i = j + 1
+	}
+
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines — классический LCS по строкам после отрезания общего начала и конца
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:pre] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	n, m := len(am), len(bm)
+	if n*m > maxDiffCells {
+		for _, line := range am {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range bm {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		// lcs[i*w+j] — длина LCS для am[i:] и bm[j:]
+		w := m + 1
+		lcs := make([]int32, (n+1)*w)
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else {
+					lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n && j < m {
+			switch {
+			case am[i] == bm[j]:
+				ops = append(ops, diffOp{' ', am[i]})
+				i++
+				j++
+			case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+				ops = append(ops, diffOp{'-', am[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', bm[j]})
+				j++
+			}
+		}
+		for ; i < n; i++ {
+			ops = append(ops, diffOp{'-', am[i]})
+		}
+		for ; j < m; j++ {
+			ops = append(ops, diffOp{'+', bm[j]})
+		}
+	}
+
+	for _, line := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
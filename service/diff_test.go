@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// numberedLines — строки "1".."n", по одной на строку
+func numberedLines(n int, replace map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		line := fmt.Sprint(i)
+		if r, ok := replace[i]; ok {
+			line = r
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+func hunkHeaders(diff string) []string {
+	var headers []string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			headers = append(headers, line)
+		}
+	}
+	return headers
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"both empty", "", "", ""},
+		{"identical", "a\nb\nc\n", "a\nb\nc\n", ""},
+		{"trailing newline ignored", "a\nb", "a\nb\n", ""},
+		{"insert into empty", "", "x\ny\n", "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{"delete everything", "x\ny\n", "", "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{"pure insert", "1\n2\n3\n", "1\n2\nX\n3\n", "--- old\n+++ new\n@@ -1,3 +1,4 @@\n 1\n 2\n+X\n 3\n"},
+		{"pure delete", "1\n2\nX\n3\n", "1\n2\n3\n", "--- old\n+++ new\n@@ -1,4 +1,3 @@\n 1\n 2\n-X\n 3\n"},
+		{"replace", "a\nb\nc\n", "a\nB\nc\n", "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{
+			"context trimmed",
+			numberedLines(10, nil),
+			numberedLines(10, map[int]string{5: "five"}),
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff(tt.a, tt.b, "old", "new"); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffHunkBoundaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace map[int]string
+		want    []string
+	}{
+		// Между изменениями 6 общих строк — контексты смыкаются, один блок
+		{"merged", map[int]string{5: "five", 12: "twelve"}, []string{"@@ -2,14 +2,14 @@"}},
+		// 7 общих строк — между контекстами остаётся строка, два блока
+		{"split", map[int]string{5: "five", 13: "thirteen"}, []string{"@@ -2,7 +2,7 @@", "@@ -10,7 +10,7 @@"}},
+		{"first and last line", map[int]string{1: "one", 20: "twenty"}, []string{"@@ -1,4 +1,4 @@", "@@ -17,4 +17,4 @@"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := UnifiedDiff(numberedLines(20, nil), numberedLines(20, tt.replace), "old", "new")
+			if got := hunkHeaders(diff); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hunks = %q, want %q\n%s", got, tt.want, diff)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []diffOp
+	}{
+		{"both empty", nil, nil, []diffOp{}},
+		{"identical", []string{"a", "b"}, []string{"a", "b"}, []diffOp{{' ', "a"}, {' ', "b"}}},
+		{"insert only", nil, []string{"a", "b"}, []diffOp{{'+', "a"}, {'+', "b"}}},
+		{"delete only", []string{"a", "b"}, nil, []diffOp{{'-', "a"}, {'-', "b"}}},
+		{
+			"common prefix and suffix",
+			[]string{"a", "b", "c", "d"},
+			[]string{"a", "x", "c", "d"},
+			[]diffOp{{' ', "a"}, {'-', "b"}, {'+', "x"}, {' ', "c"}, {' ', "d"}},
+		},
+		{
+			"lcs in the middle",
+			[]string{"s", "a", "b", "c", "e"},
+			[]string{"s", "b", "c", "d", "e"},
+			[]diffOp{{' ', "s"}, {'-', "a"}, {' ', "b"}, {' ', "c"}, {'+', "d"}, {' ', "e"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
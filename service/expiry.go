@@ -0,0 +1,104 @@
+package service
+
+import (
+	"log"
+	"time"
+	"vpnbot/database"
+)
+
+// ExpiryCheckInterval — как часто планировщик ищет подписки с истёкшим ExpiryDate
+const ExpiryCheckInterval = time.Minute
+
+// StartExpiryScheduler запускает фоновую проверку ExpiryDate
+func StartExpiryScheduler() {
+	go func() {
+		ExpireOverdueUsers()
+
+		ticker := time.NewTicker(ExpiryCheckInterval)
+		for range ticker.C {
+			ExpireOverdueUsers()
+		}
+	}()
+}
+
+// ExpireOverdueUsers переводит в expired активных юзеров, у которых прошла дата окончания подписки.
+// Конфиги sing-box и telemt перегенерируются один раз на всю пачку. Возвращает число истёкших юзеров.
+func ExpireOverdueUsers() int {
+	var users []database.User
+	database.DB.Where("status = ? AND expiry_date IS NOT NULL AND expiry_date <= ?", "active", time.Now()).Find(&users)
+	if len(users) == 0 {
+		return 0
+	}
+
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	if err := database.DB.Model(&database.User{}).Where("id IN ?", ids).
+		Updates(map[string]interface{}{"status": "expired", "expired_reason": "expiry"}).Error; err != nil {
+		log.Println("Expiry: failed to update users:", err)
+		return 0
+	}
+
+	for _, u := range users {
+		log.Printf("User %s expired: subscription ended %s", u.Username, u.ExpiryDate.Format(time.RFC3339))
+		recordLimitHit(u, LimitExpiry)
+	}
+
+	RequestReload("scheduler: subscription expiry")
+
+	return len(users)
+}
+
+// SetUserExpiry задаёт дату окончания подписки (nil — бессрочно).
+// Истёкший юзер с новой датой в будущем и неисчерпанным трафиком реактивируется,
+// активный юзер с датой в прошлом сразу переводится в expired.
+func SetUserExpiry(user *database.User, expiry *time.Time) error {
+	if expiry != nil {
+		// SQLite сравнивает даты как строки — храним в одной таймзоне с time.Now()
+		local := expiry.Local()
+		expiry = &local
+	}
+	user.ExpiryDate = expiry
+
+	now := time.Now()
+	statusChanged := false
+	switch user.Status {
+	case "expired":
+		quotaLeft := user.TrafficLimit == 0 || user.TrafficUsed < user.TrafficLimit
+		if quotaLeft && (expiry == nil || expiry.After(now)) {
+			user.Status = "active"
+			user.ExpiredReason = ""
+			statusChanged = true
+		}
+	case "active":
+		if expiry != nil && !expiry.After(now) {
+			user.Status = "expired"
+			user.ExpiredReason = "expiry"
+			statusChanged = true
+		}
+	}
+
+	if err := database.DB.Save(user).Error; err != nil {
+		return err
+	}
+
+	if statusChanged {
+		log.Printf("User %s is now %s after expiry change", user.Username, user.Status)
+		RequestReload("expiry change: " + user.Username)
+	}
+
+	return nil
+}
+
+// ExtendUserExpiry продлевает подписку на days дней. Отсчёт идёт от текущей даты окончания,
+// а если она не задана или уже прошла — от текущего момента.
+func ExtendUserExpiry(user *database.User, days int) error {
+	base := time.Now()
+	if user.ExpiryDate != nil && user.ExpiryDate.After(base) {
+		base = *user.ExpiryDate
+	}
+	expiry := base.AddDate(0, 0, days)
+	return SetUserExpiry(user, &expiry)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInviteInvalid    = errors.New("invite code is invalid, expired or used up")
+	ErrInviteBanned     = errors.New("access for this Telegram ID is banned")
+	ErrReferralInactive = errors.New("referral links are only available to active users")
+)
+
+// inviteCodeRe — что Telegram пропускает в параметре /start
+var inviteCodeRe = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// inviteAlphabet без похожих символов (0/o, 1/l/i)
+const inviteAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var (
+	botUsernameMu sync.RWMutex
+	botUsername   string
+)
+
+// SetBotUsername запоминает имя бота для ссылок-приглашений
+func SetBotUsername(name string) {
+	botUsernameMu.Lock()
+	defer botUsernameMu.Unlock()
+	botUsername = name
+}
+
+// InviteLink — ссылка t.me на бота с кодом; пустая, если бот ещё не запущен
+func InviteLink(code string) string {
+	botUsernameMu.RLock()
+	defer botUsernameMu.RUnlock()
+	if botUsername == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s", botUsername, code)
+}
+
+// referralSettings — личные ссылки юзеров: REFERRAL_MAX_USES приглашений на ссылку (0 — ссылок нет),
+// REFERRAL_BONUS_GB трафика владельцу ссылки за каждого приглашённого.
+func referralSettings() (maxUses int, bonus int64) {
+	maxUses, _ = strconv.Atoi(os.Getenv("REFERRAL_MAX_USES"))
+	gb, _ := strconv.Atoi(os.Getenv("REFERRAL_BONUS_GB"))
+	return max(maxUses, 0), int64(max(gb, 0)) * 1024 * 1024 * 1024
+}
+
+func generateInviteCode() string {
+	b := make([]byte, 10)
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(inviteAlphabet))))
+		b[i] = inviteAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// CreateInviteCode проверяет и сохраняет код. Пустой Code генерируется.
+func CreateInviteCode(invite *database.InviteCode) error {
+	invite.ID = 0
+	invite.Uses = 0
+	invite.RevokedAt = nil
+	invite.Code = strings.TrimSpace(invite.Code)
+	if invite.Code == "" {
+		invite.Code = generateInviteCode()
+	}
+	if !inviteCodeRe.MatchString(invite.Code) {
+		return fmt.Errorf("code must be 3-64 characters: letters, digits, _ or -")
+	}
+	if invite.MaxUses < 0 {
+		return fmt.Errorf("max_uses must not be negative")
+	}
+	if invite.ExpiresAt != nil {
+		local := invite.ExpiresAt.Local()
+		invite.ExpiresAt = &local
+	}
+	if invite.PlanID != 0 {
+		var plan database.Plan
+		if err := database.DB.First(&plan, invite.PlanID).Error; err != nil {
+			return fmt.Errorf("plan %d not found", invite.PlanID)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&database.InviteCode{}).Where("code = ?", invite.Code).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("code %q already exists", invite.Code)
+	}
+	return database.DB.Create(invite).Error
+}
+
+// RevokeInviteCode отключает код; уже заведённые по нему юзеры остаются
+func RevokeInviteCode(invite *database.InviteCode) error {
+	if invite.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	invite.RevokedAt = &now
+	return database.DB.Model(invite).Update("revoked_at", now).Error
+}
+
+// revokeOwnedInviteCodes отзывает личные ссылки юзера (например, при бане)
+func revokeOwnedInviteCodes(userID uint) error {
+	return database.DB.Model(&database.InviteCode{}).
+		Where("owner_user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// referralOwnerActive — приглашать и получать бонус может только активный владелец ссылки
+func referralOwnerActive(ownerID uint) bool {
+	var owner database.User
+	return database.DB.Select("status").First(&owner, ownerID).Error == nil && owner.Status == "active"
+}
+
+// InviteCodeActive — можно ли ещё воспользоваться кодом
+func InviteCodeActive(invite database.InviteCode) bool {
+	return invite.RevokedAt == nil &&
+		(invite.MaxUses == 0 || invite.Uses < invite.MaxUses) &&
+		(invite.ExpiresAt == nil || invite.ExpiresAt.After(time.Now()))
+}
+
+// ReferralInviteCode — личная ссылка юзера для приглашения друзей; создаётся при первом запросе.
+// ok = false, если личные ссылки выключены. Неактивным юзерам — ErrReferralInactive.
+func ReferralInviteCode(user database.User) (database.InviteCode, bool, error) {
+	var invite database.InviteCode
+	maxUses, _ := referralSettings()
+	if maxUses == 0 {
+		return invite, false, nil
+	}
+	if user.Status != "active" {
+		return invite, true, ErrReferralInactive
+	}
+	err := database.DB.Where("owner_user_id = ? AND revoked_at IS NULL", user.ID).Order("id desc").First(&invite).Error
+	if err == nil {
+		return invite, true, nil
+	}
+	invite = database.InviteCode{OwnerUserID: user.ID, MaxUses: maxUses, Note: "referral: " + user.Username}
+	return invite, true, CreateInviteCode(&invite)
+}
+
+// RedeemInviteCode заводит юзера по коду приглашения без участия админа.
+// Открытая заявка этого Telegram ID закрывается как одобренная, забаненным код не помогает.
+func RedeemInviteCode(code string, telegramID int64, telegramUsername string) (database.User, error) {
+	var user database.User
+	if database.DB.Where("telegram_id = ?", telegramID).First(&user).Error == nil {
+		return user, ErrUserAlreadyExists
+	}
+
+	var req database.AccessRequest
+	hasRequest := database.DB.Where("telegram_id = ?", telegramID).First(&req).Error == nil
+	if hasRequest && req.Status == RequestBanned {
+		return user, ErrInviteBanned
+	}
+
+	var invite database.InviteCode
+	if err := database.DB.Where("code = ?", code).First(&invite).Error; err != nil {
+		return user, ErrInviteInvalid
+	}
+	// Личная ссылка забаненного или истёкшего юзера не работает
+	if invite.OwnerUserID != 0 && !referralOwnerActive(invite.OwnerUserID) {
+		return user, ErrInviteInvalid
+	}
+
+	// Использование засчитывается атомарно, чтобы одноразовый код не сработал дважды
+	now := time.Now()
+	res := database.DB.Model(&database.InviteCode{}).
+		Where("id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > ?)", invite.ID, now).
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return user, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return user, ErrInviteInvalid
+	}
+
+	var plan *database.Plan
+	if invite.PlanID != 0 {
+		var p database.Plan
+		if database.DB.First(&p, invite.PlanID).Error == nil {
+			plan = &p
+		}
+	}
+
+	user, err := createBotUser(telegramID, telegramUsername, plan, func(u *database.User) {
+		u.InviteCodeID = invite.ID
+		u.ReferredByID = invite.OwnerUserID
+	})
+	if err != nil {
+		database.DB.Model(&database.InviteCode{}).Where("id = ?", invite.ID).Update("uses", gorm.Expr("uses - 1"))
+		return user, err
+	}
+
+	if hasRequest && req.Status == RequestPending {
+		decideAccessRequest(&req, RequestApproved, "invite "+invite.Code, 0, user.ID)
+	}
+
+	log.Printf("User %s created by invite %s", user.Username, invite.Code)
+	RequestReload("invite: " + user.Username)
+
+	if invite.OwnerUserID != 0 {
+		creditReferrer(invite.OwnerUserID, user)
+	}
+	return user, nil
+}
+
+// creditReferrer начисляет владельцу ссылки бонусный трафик (записывается как платёж kind=referral)
+func creditReferrer(referrerID uint, invited database.User) {
+	var referrer database.User
+	if err := database.DB.First(&referrer, referrerID).Error; err != nil {
+		return
+	}
+
+	if referrer.Status != "active" {
+		return
+	}
+
+	_, bonus := referralSettings()
+	if bonus == 0 || referrer.TrafficLimit == 0 {
+		NotifyUser(referrer.TelegramID, "👥 По вашей ссылке подключился новый пользователь. Спасибо!")
+		return
+	}
+
+	now := time.Now()
+	payment := database.Payment{
+		UserID:        referrer.ID,
+		TelegramID:    referrer.TelegramID,
+		Provider:      "manual",
+		Kind:          PaymentKindReferral,
+		Status:        PaymentPaid,
+		Payload:       database.GenerateToken(),
+		CreditedBytes: bonus,
+		Note:          "referral: " + invited.Username,
+		PaidAt:        &now,
+	}
+	if err := database.DB.Create(&payment).Error; err != nil {
+		log.Println("Failed to record referral bonus:", err)
+		return
+	}
+	if err := creditUser(&referrer, 0, bonus); err != nil {
+		log.Printf("Failed to credit referral bonus to %s: %v", referrer.Username, err)
+		return
+	}
+	NotifyUser(referrer.TelegramID, fmt.Sprintf("👥 По вашей ссылке подключился новый пользователь. Бонус: +%s трафика.", formatGB(bonus)))
+}
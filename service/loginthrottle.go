@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Параметры защиты входа в админку
+const (
+	// loginFreeAttempts — сколько неудачных попыток с одного IP допускается без задержки
+	loginFreeAttempts = 5
+	// loginBaseBackoff — первая блокировка IP, дальше удваивается с каждой неудачей
+	loginBaseBackoff = 30 * time.Second
+	loginMaxBackoff  = time.Hour
+	// loginFailureTTL — через сколько без неудач счётчик IP забывается
+	loginFailureTTL = 24 * time.Hour
+
+	// Глобальный порог: больше loginGlobalLimit неудач со всех IP за loginGlobalWindow —
+	// вход закрывается для всех на растущее время
+	loginGlobalLimit  = 30
+	loginGlobalWindow = time.Minute
+)
+
+type ipLoginState struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type loginThrottle struct {
+	mu sync.Mutex
+
+	perIP map[string]*ipLoginState
+
+	globalFailures    []time.Time
+	globalLockedUntil time.Time
+	globalTrips       int // подряд идущие глобальные блокировки — для удвоения
+	lastGlobalTrip    time.Time
+}
+
+var adminLoginThrottle = &loginThrottle{perIP: map[string]*ipLoginState{}}
+
+func loginBackoff(step int) time.Duration {
+	d := loginBaseBackoff
+	for i := 0; i < step && d < loginMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, loginMaxBackoff)
+}
+
+// LoginAllowed сообщает, можно ли сейчас пробовать вход с ip, и если нет — сколько ждать.
+// Глобальная блокировка здесь не учитывается: с верными данными войти можно и во время неё
+// (см. GlobalLoginLock), иначе подборщик запирал бы всех админов.
+func LoginAllowed(ip string) (time.Duration, bool) {
+	t := adminLoginThrottle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if st := t.perIP[ip]; st != nil && now.Before(st.lockedUntil) {
+		return st.lockedUntil.Sub(now), false
+	}
+	return 0, true
+}
+
+// GlobalLoginLock — действует ли глобальная блокировка входа и сколько она ещё продлится.
+// Пока она действует, неверные данные отклоняются как при блокировке, без подсказки 401.
+func GlobalLoginLock() (time.Duration, bool) {
+	t := adminLoginThrottle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now := time.Now(); now.Before(t.globalLockedUntil) {
+		return t.globalLockedUntil.Sub(now), true
+	}
+	return 0, false
+}
+
+// RecordLoginFailure учитывает неудачный вход и при необходимости блокирует IP или вход целиком.
+// О блокировках сообщает админу в бот.
+func RecordLoginFailure(ip, username string) {
+	t := adminLoginThrottle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	// Чистим забытые IP, чтобы карта не росла бесконечно
+	for k, st := range t.perIP {
+		if now.Sub(st.lastFailure) > loginFailureTTL {
+			delete(t.perIP, k)
+		}
+	}
+
+	st := t.perIP[ip]
+	if st == nil {
+		st = &ipLoginState{}
+		t.perIP[ip] = st
+	}
+	st.failures++
+	st.lastFailure = now
+	if st.failures >= loginFreeAttempts {
+		d := loginBackoff(st.failures - loginFreeAttempts)
+		st.lockedUntil = now.Add(d)
+		if st.failures == loginFreeAttempts {
+			NotifyAdmin(fmt.Sprintf("⚠️ Подбор пароля админки: %d неудачных попыток с IP %s (логин %q). IP заблокирован на %s.",
+				st.failures, ip, username, d))
+		}
+	}
+
+	// Глобальное окно
+	cutoff := now.Add(-loginGlobalWindow)
+	kept := t.globalFailures[:0]
+	for _, at := range t.globalFailures {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	t.globalFailures = append(kept, now)
+
+	if len(t.globalFailures) > loginGlobalLimit && !now.Before(t.globalLockedUntil) {
+		if now.Sub(t.lastGlobalTrip) > loginMaxBackoff {
+			t.globalTrips = 0
+		}
+		d := loginBackoff(t.globalTrips)
+		t.globalTrips++
+		t.lastGlobalTrip = now
+		t.globalLockedUntil = now.Add(d)
+		t.globalFailures = nil
+		NotifyAdmin(fmt.Sprintf("🚨 Массовый подбор пароля админки: больше %d неудачных входов за %s. Вход с неверными данными закрыт для всех на %s.",
+			loginGlobalLimit, loginGlobalWindow, d))
+	}
+}
+
+// RecordLoginSuccess сбрасывает счётчик неудач для ip
+func RecordLoginSuccess(ip string) {
+	t := adminLoginThrottle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.perIP, ip)
+}
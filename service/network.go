@@ -1,8 +1,8 @@
 package service
 
 import (
-	"fmt"
 	"net"
+	"strconv"
 	"time"
 	"vpnbot/database"
 )
@@ -33,7 +33,7 @@ type NetworkStatus struct {
 // --- Public API ---
 
 func CheckPort(host string, port int, timeout time.Duration) (bool, int64, error) {
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	start := time.Now()
 	conn, err := net.DialTimeout("tcp", addr, timeout)
 	latency := time.Since(start).Milliseconds()
@@ -46,7 +46,7 @@ func CheckPort(host string, port int, timeout time.Duration) (bool, int64, error
 
 func CheckAllInboundPorts() []PortCheck {
 	var inbounds []database.InboundConfig
-	database.DB.Where("enabled = ?", true).Order("sort_order").Find(&inbounds)
+	database.DB.Where("enabled = ? AND node_id = ?", true, 0).Order("sort_order").Find(&inbounds)
 
 	ruvdsIP := GetRuVDSIP()
 	hetznerIP := GetHetznerServerIP()
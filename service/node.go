@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+
+	"github.com/v2fly/v2ray-core/v4/app/stats/command"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// StatEntry — один счётчик V2Ray API (user>>>name>>>traffic>>>uplink и т.п.)
+type StatEntry struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// NodeClient — сервер, на который выкладывается конфиг sing-box и с которого читается статистика
+type NodeClient interface {
+	ID() uint // 0 — локальный сервер
+	Name() string
+	// StatsListen — адрес v2ray_api, который прописывается в конфиг ноды
+	StatsListen() string
+	ApplySingboxConfig(data []byte) error
+	ApplyTelemetConfig(data []byte) error
+	QueryStats() ([]StatEntry, error)
+	Health() (NodeHealth, error)
+}
+
+// NodeHealth — состояние сервисов на ноде
+type NodeHealth struct {
+	SingboxActive  bool   `json:"singbox_active"`
+	TelemetActive  bool   `json:"telemt_active"`
+	SingboxVersion string `json:"singbox_version,omitempty"`
+}
+
+// NodeClients возвращает локальный сервер и все включённые удалённые ноды
+func NodeClients() []NodeClient {
+	clients := []NodeClient{localNode{}}
+
+	var nodes []database.Node
+	database.DB.Where("enabled = ?", true).Order("id").Find(&nodes)
+	for _, n := range nodes {
+		clients = append(clients, NewNodeClient(n))
+	}
+	return clients
+}
+
+// NewNodeClient создаёт клиент для удалённой ноды: через агент, если задан AgentURL, иначе по SSH
+func NewNodeClient(n database.Node) NodeClient {
+	if n.AgentURL != "" {
+		return &agentNode{node: n}
+	}
+	return &sshNode{node: n}
+}
+
+// NodeClientByID возвращает клиент ноды по ID; 0 — локальный сервер
+func NodeClientByID(id uint) (NodeClient, error) {
+	if id == 0 {
+		return localNode{}, nil
+	}
+	var n database.Node
+	if err := database.DB.First(&n, id).Error; err != nil {
+		return nil, fmt.Errorf("node %d not found", id)
+	}
+	return NewNodeClient(n), nil
+}
+
+// NodeAddress возвращает публичный адрес ноды для ссылок; для локального сервера — fallback
+func NodeAddress(nodeID uint, fallback string) string {
+	if nodeID == 0 {
+		return fallback
+	}
+	var n database.Node
+	if database.DB.First(&n, nodeID).Error != nil || n.Address == "" {
+		return fallback
+	}
+	return n.Address
+}
+
+// ClientInbounds возвращает включённые инбаунды на локальном сервере и включённых нодах.
+// Пустой ServerAddress заполняется адресом ноды, чтобы ссылки вели на нужный сервер.
+func ClientInbounds() []database.InboundConfig {
+	var nodes []database.Node
+	database.DB.Where("enabled = ?", true).Find(&nodes)
+	nodeAddr := map[uint]string{}
+	for _, n := range nodes {
+		nodeAddr[n.ID] = n.Address
+	}
+
+	var inbounds []database.InboundConfig
+	database.DB.Where("enabled = ?", true).Order("sort_order").Find(&inbounds)
+
+	result := []database.InboundConfig{}
+	for _, ib := range inbounds {
+		if ib.NodeID != 0 {
+			addr, ok := nodeAddr[ib.NodeID]
+			if !ok {
+				continue
+			}
+			if ib.ServerAddress == "" {
+				ib.ServerAddress = addr
+			}
+		}
+		result = append(result, ib)
+	}
+	return result
+}
+
+// QueryV2RayStats читает все счётчики V2Ray API по адресу addr
+func QueryV2RayStats(addr string) ([]StatEntry, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := command.NewStatsServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Запрашиваем всё, но фильтруем в коде
+	resp, err := client.QueryStats(ctx, &command.QueryStatsRequest{
+		Pattern: "",
+		Reset_:  false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]StatEntry, 0, len(resp.Stat))
+	for _, stat := range resp.Stat {
+		stats = append(stats, StatEntry{Name: stat.Name, Value: stat.Value})
+	}
+	return stats, nil
+}
+
+// --- Локальный сервер ---
+
+type localNode struct{}
+
+func (localNode) ID() uint            { return 0 }
+func (localNode) Name() string        { return "local" }
+func (localNode) StatsListen() string { return ApiAddr }
+
+func (localNode) ApplySingboxConfig(data []byte) error {
+	return InstallSingboxConfig(data)
+}
+
+func (localNode) ApplyTelemetConfig(data []byte) error {
+	return InstallTelemetConfig(data)
+}
+
+func (localNode) QueryStats() ([]StatEntry, error) {
+	return QueryV2RayStats(ApiAddr)
+}
+
+func (localNode) Health() (NodeHealth, error) {
+	return LocalNodeHealth(), nil
+}
+
+// LocalNodeHealth проверяет sing-box и telemt на текущей машине
+func LocalNodeHealth() NodeHealth {
+	health := NodeHealth{
+		SingboxActive: exec.Command("systemctl", "is-active", "--quiet", "sing-box").Run() == nil,
+		TelemetActive: IsTelemetRunning(),
+	}
+	if out, err := exec.Command("sing-box", "version").Output(); err == nil {
+		health.SingboxVersion = firstLine(string(out))
+	}
+	return health
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// --- Удалённая нода по SSH ---
+
+type sshNode struct {
+	node database.Node
+}
+
+func (n *sshNode) ID() uint     { return n.node.ID }
+func (n *sshNode) Name() string { return n.node.Name }
+
+func (n *sshNode) StatsListen() string {
+	if n.node.StatsAddr != "" {
+		return n.node.StatsAddr
+	}
+	return ApiAddr
+}
+
+func (n *sshNode) dial() (*ssh.Client, error) {
+	host := n.node.SSHHost
+	if host == "" {
+		host = n.node.Address
+	}
+	port := n.node.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	user := n.node.SSHUser
+	if user == "" {
+		user = "root"
+	}
+	return sshDial(host, strconv.Itoa(port), user, n.node.SSHKeyPath)
+}
+
+// push передаёт data на stdin команды cmd на ноде и возвращает её вывод
+func (n *sshNode) push(data []byte, cmd string) (string, error) {
+	client, err := n.dial()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать SSH сессию: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdin = bytes.NewReader(data)
+	output, err := session.CombinedOutput(cmd)
+	return strings.TrimSpace(string(output)), err
+}
+
+// Коды выхода скрипта выкладки, по которым панель отличает отказ sing-box от прочих ошибок
+const (
+	sshExitCheckFailed = 42
+	sshExitRolledBack  = 43
+)
+
+// sshApplySingboxScript — то же, что InstallSingboxConfig, но на стороне ноды в sh
+const sshApplySingboxScript = `cfg='%[1]s'; backups='%[2]s'
+cat > "$cfg.tmp" || exit 1
+if ! out=$(sing-box check -c "$cfg.tmp" 2>&1); then echo "$out"; rm -f "$cfg.tmp"; exit %[4]d; fi
+[ -f "$cfg" ] && cp "$cfg" "$cfg.prev"
+mv "$cfg.tmp" "$cfg" || exit 1
+if systemctl reload sing-box && sleep %[3]d && systemctl is-active --quiet sing-box; then
+  mkdir -p "$backups"
+  cp "$cfg" "$backups/config-$(date +%%Y%%m%%d-%%H%%M%%S).json"
+  ls -1 "$backups"/config-*.json | sort | head -n -%[6]d | xargs -r rm -f
+  exit 0
+fi
+[ -f "$cfg.prev" ] && mv "$cfg.prev" "$cfg" && { systemctl reload sing-box || systemctl restart sing-box; }
+echo "sing-box is not active after reload"
+exit %[5]d`
+
+func (n *sshNode) ApplySingboxConfig(data []byte) error {
+	script := fmt.Sprintf(sshApplySingboxScript, ConfigPath, ConfigBackupDir,
+		int(SingboxActiveCheckDelay/time.Second), sshExitCheckFailed, sshExitRolledBack, configBackupKeep())
+	output, err := n.push(data, script)
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitStatus() {
+			case sshExitCheckFailed:
+				return &ConfigCheckError{Output: output}
+			case sshExitRolledBack:
+				return &ConfigCheckError{Output: output, RolledBack: true}
+			}
+		}
+		return fmt.Errorf("ошибка выкладки конфига на %s: %w: %s", n.node.Name, err, output)
+	}
+	log.Printf("Sing-box config pushed to node %s", n.node.Name)
+	return nil
+}
+
+func (n *sshNode) ApplyTelemetConfig(data []byte) error {
+	cmd := fmt.Sprintf("mkdir -p '%[1]s' && cat > '%[2]s.tmp' && mv '%[2]s.tmp' '%[2]s' && systemctl restart telemt", TelemetConfigDir, TelemetConfigPath)
+	if output, err := n.push(data, cmd); err != nil {
+		return fmt.Errorf("ошибка выкладки конфига telemt на %s: %w: %s", n.node.Name, err, output)
+	}
+	return nil
+}
+
+func (n *sshNode) QueryStats() ([]StatEntry, error) {
+	if n.node.StatsAddr == "" {
+		return nil, nil
+	}
+	return QueryV2RayStats(n.node.StatsAddr)
+}
+
+func (n *sshNode) Health() (NodeHealth, error) {
+	client, err := n.dial()
+	if err != nil {
+		return NodeHealth{}, err
+	}
+	defer client.Close()
+
+	var health NodeHealth
+	_, err = runSSH(client, "systemctl is-active --quiet sing-box")
+	health.SingboxActive = err == nil
+	_, err = runSSH(client, "systemctl is-active --quiet telemt")
+	health.TelemetActive = err == nil
+	if out, err := runSSH(client, "sing-box version"); err == nil {
+		health.SingboxVersion = firstLine(out)
+	}
+	return health, nil
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm/clause"
+)
+
+// NotificationCheckInterval — как часто планировщик ищет истекающие подписки и время сводки
+const NotificationCheckInterval = 10 * time.Minute
+
+// События лимитов для сводки админу
+const (
+	LimitQuota  = "limit_quota"
+	LimitExpiry = "limit_expiry"
+)
+
+// parseIntList разбирает список вида "80,95,100"; значения вне [lo, hi] отбрасываются
+func parseIntList(v string, lo, hi int) []int {
+	var result []int
+	seen := map[int]bool{}
+	for _, part := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < lo || n > hi || seen[n] {
+			continue
+		}
+		seen[n] = true
+		result = append(result, n)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// trafficWarnThresholds — пороги в процентах от TrafficLimit. TRAFFIC_WARN_THRESHOLDS, по умолчанию 80,95,100.
+func trafficWarnThresholds() []int {
+	if v, ok := os.LookupEnv("TRAFFIC_WARN_THRESHOLDS"); ok {
+		return parseIntList(v, 1, 100)
+	}
+	return []int{80, 95, 100}
+}
+
+// expiryWarnDays — за сколько дней до ExpiryDate предупреждать. EXPIRY_WARN_DAYS, по умолчанию 3,1.
+func expiryWarnDays() []int {
+	if v, ok := os.LookupEnv("EXPIRY_WARN_DAYS"); ok {
+		return parseIntList(v, 1, 365)
+	}
+	return []int{1, 3}
+}
+
+// adminSummaryHour — час отправки ежедневной сводки админу. ADMIN_SUMMARY_HOUR, по умолчанию 21; -1 — не слать.
+func adminSummaryHour() int {
+	if v, err := strconv.Atoi(os.Getenv("ADMIN_SUMMARY_HOUR")); err == nil && v >= -1 && v <= 23 {
+		return v
+	}
+	return 21
+}
+
+// claimNotification записывает событие в NotificationLog. false — такое уже было в этом периоде.
+func claimNotification(userID uint, kind, periodKey string, delivered bool) bool {
+	entry := database.NotificationLog{UserID: userID, Kind: kind, PeriodKey: periodKey, Delivered: delivered}
+	res := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+	if res.Error != nil {
+		log.Println("Failed to write notification log:", res.Error)
+		return false
+	}
+	return res.RowsAffected > 0
+}
+
+func wantsNotifications(user database.User) bool {
+	return user.TelegramID != 0 && !user.NotificationsOff
+}
+
+// quotaPeriodKey — период трафика и лимит: после сброса или смены лимита пороги срабатывают заново
+func quotaPeriodKey(user database.User) string {
+	return fmt.Sprintf("%d:%d", currentPeriodStart(user).Unix(), user.TrafficLimit)
+}
+
+func expiryKey(expiry time.Time) string {
+	return strconv.FormatInt(expiry.Unix(), 10)
+}
+
+func formatGB(b int64) string {
+	return fmt.Sprintf("%.2f GB", float64(b)/(1024*1024*1024))
+}
+
+// CheckQuotaNotifications предупреждает юзера о пересечении порогов трафика.
+// Если за раз пересечено несколько порогов, приходит одно сообщение по старшему.
+func CheckQuotaNotifications(user database.User) {
+	if user.TrafficLimit <= 0 || user.Status == "banned" {
+		return
+	}
+
+	percent := int(user.TrafficUsed * 100 / user.TrafficLimit)
+	key := quotaPeriodKey(user)
+	crossed := 0
+	for _, t := range trafficWarnThresholds() {
+		if percent >= t && claimNotification(user.ID, fmt.Sprintf("quota_%d", t), key, wantsNotifications(user)) {
+			crossed = t
+		}
+	}
+	if crossed == 0 || !wantsNotifications(user) {
+		return
+	}
+
+	next := NextTrafficReset(user)
+	if crossed >= 100 {
+		resume := "Чтобы продолжить, обратитесь к администратору."
+		if next != nil {
+			resume = "Доступ восстановится после сброса трафика " + next.Format("02.01.2006") + "."
+		}
+		NotifyUser(user.TelegramID, fmt.Sprintf("⛔ Трафик закончился: %s из %s.\n\n%s",
+			formatGB(user.TrafficUsed), formatGB(user.TrafficLimit), resume))
+		return
+	}
+
+	msg := fmt.Sprintf("⚠️ Использовано %d%% трафика: %s из %s.", percent, formatGB(user.TrafficUsed), formatGB(user.TrafficLimit))
+	if next != nil {
+		msg += "\nСброс трафика: " + next.Format("02.01.2006") + "."
+	}
+	NotifyUser(user.TelegramID, msg)
+}
+
+// CheckExpiryNotifications предупреждает юзеров, у которых скоро заканчивается подписка
+func CheckExpiryNotifications(now time.Time) {
+	days := expiryWarnDays()
+	if len(days) == 0 {
+		return
+	}
+
+	var users []database.User
+	database.DB.Where("status = ? AND expiry_date IS NOT NULL AND expiry_date > ? AND expiry_date <= ?",
+		"active", now, now.AddDate(0, 0, days[len(days)-1])).Find(&users)
+
+	for _, user := range users {
+		left := user.ExpiryDate.Sub(now)
+		key := expiryKey(*user.ExpiryDate)
+		claimed := false
+		for _, d := range days {
+			if left <= time.Duration(d)*24*time.Hour && claimNotification(user.ID, fmt.Sprintf("expiry_%dd", d), key, wantsNotifications(user)) {
+				claimed = true
+			}
+		}
+		if !claimed || !wantsNotifications(user) {
+			continue
+		}
+
+		daysLeft := int((left + 24*time.Hour - 1) / (24 * time.Hour))
+		NotifyUser(user.TelegramID, fmt.Sprintf(
+			"⏳ Подписка заканчивается %s (осталось дней: %d).\n\nЧтобы продлить, обратитесь к администратору.",
+			user.ExpiryDate.Format("02.01.2006 15:04"), daysLeft))
+	}
+}
+
+// recordLimitHit отмечает, что юзер упёрся в лимит (для сводки админу).
+// При окончании подписки юзер получает сообщение; про трафик сообщает порог 100%.
+func recordLimitHit(user database.User, kind string) {
+	switch kind {
+	case LimitQuota:
+		claimNotification(user.ID, kind, quotaPeriodKey(user), false)
+	case LimitExpiry:
+		if user.ExpiryDate == nil {
+			return
+		}
+		if claimNotification(user.ID, kind, expiryKey(*user.ExpiryDate), wantsNotifications(user)) && wantsNotifications(user) {
+			NotifyUser(user.TelegramID, "⛔ Подписка закончилась, доступ приостановлен.\n\nЧтобы продлить, обратитесь к администратору.")
+		}
+	}
+}
+
+// SendAdminSummary отправляет админу сводку: кто за последние сутки исчерпал трафик, подписку
+// или лимит устройств. Сводка уходит раз в день, поэтому сутки стыкуются без пропусков. Пустая не отправляется.
+func SendAdminSummary(now time.Time) {
+	since := now.Add(-24 * time.Hour)
+
+	var limitRows []struct {
+		UserID uint
+		Kind   string
+	}
+	database.DB.Model(&database.NotificationLog{}).Select("DISTINCT user_id, kind").
+		Where("kind IN ? AND created_at >= ?", []string{LimitQuota, LimitExpiry}, since).Scan(&limitRows)
+
+	var deviceIDs []uint
+	database.DB.Model(&database.ConnectionLog{}).Distinct("user_id").
+		Where("reason = ? AND timestamp >= ?", "device_limit", since).Pluck("user_id", &deviceIDs)
+
+	groups := map[string][]uint{}
+	for _, row := range limitRows {
+		groups[row.Kind] = append(groups[row.Kind], row.UserID)
+	}
+	groups["device_limit"] = deviceIDs
+
+	sections := []struct{ kind, title string }{
+		{LimitQuota, "📉 Исчерпали трафик"},
+		{LimitExpiry, "⏰ Закончилась подписка"},
+		{"device_limit", "📱 Превысили лимит устройств"},
+	}
+
+	var b strings.Builder
+	for _, s := range sections {
+		ids := groups[s.kind]
+		if len(ids) == 0 {
+			continue
+		}
+		var users []database.User
+		database.DB.Where("id IN ?", ids).Order("username").Find(&users)
+		names := make([]string, 0, len(users))
+		for _, u := range users {
+			name := u.Username
+			if u.TelegramUsername != "" {
+				name += " (@" + u.TelegramUsername + ")"
+			}
+			names = append(names, name)
+		}
+		fmt.Fprintf(&b, "\n\n%s (%d):\n%s", s.title, len(names), strings.Join(names, "\n"))
+	}
+
+	if b.Len() == 0 {
+		return
+	}
+	NotifyAdmin("📋 Лимиты за сутки, " + now.Format("02.01.2006 15:04") + b.String())
+}
+
+// StartNotificationScheduler запускает предупреждения о подписке и ежедневную сводку админу.
+// Предупреждения о трафике отправляются сразу при обновлении статистики (checkLimits).
+// Первая проверка — через интервал после старта, когда бот уже зарегистрировал отправителей.
+func StartNotificationScheduler() {
+	go func() {
+		ticker := time.NewTicker(NotificationCheckInterval)
+		for range ticker.C {
+			now := time.Now()
+			CheckExpiryNotifications(now)
+
+			if hour := adminSummaryHour(); hour >= 0 && now.Hour() >= hour &&
+				claimNotification(0, "admin_summary", now.Format("2006-01-02"), true) {
+				SendAdminSummary(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"log"
+	"sync"
+)
+
+// Уведомления из сервисного слоя. Бот регистрирует отправителей при старте;
+// без бота уведомления только пишутся в лог.
+var (
+	notifierMu    sync.RWMutex
+	adminNotifier func(text string)
+	userNotifier  func(telegramID int64, text string)
+)
+
+// SetNotifier регистрирует функции отправки сообщений админу и пользователю
+func SetNotifier(admin func(text string), user func(telegramID int64, text string)) {
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+	adminNotifier = admin
+	userNotifier = user
+}
+
+// NotifyAdmin отправляет сообщение админу
+func NotifyAdmin(text string) {
+	notifierMu.RLock()
+	send := adminNotifier
+	notifierMu.RUnlock()
+
+	if send == nil {
+		log.Println("Admin notification (bot not running):", text)
+		return
+	}
+	go send(text)
+}
+
+// NotifyUser отправляет сообщение пользователю в Telegram
+func NotifyUser(telegramID int64, text string) {
+	notifierMu.RLock()
+	send := userNotifier
+	notifierMu.RUnlock()
+
+	if send == nil || telegramID == 0 {
+		return
+	}
+	go send(telegramID, text)
+}
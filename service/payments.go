@@ -0,0 +1,398 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"vpnbot/database"
+)
+
+// Статусы и виды платежей
+const (
+	PaymentPending  = "pending"
+	PaymentPaid     = "paid"
+	PaymentRefunded = "refunded"
+
+	PaymentKindPlan     = "plan"
+	PaymentKindTopUp    = "topup"
+	PaymentKindManual   = "manual"
+	PaymentKindReferral = "referral" // бонус за приглашённого
+)
+
+var (
+	ErrPaymentNotFound   = errors.New("payment not found")
+	ErrPaymentMismatch   = errors.New("payment does not match the invoice")
+	ErrPaymentNotPending = errors.New("payment is not pending")
+	ErrPaymentNotPaid    = errors.New("payment is not paid")
+	ErrTopUpUnavailable  = errors.New("traffic top-up is not available")
+)
+
+// PaymentProvider — способ оплаты в боте. Провайдеры с UsesInvoice выставляют счёт Telegram
+// (Stars или платёжный провайдер с токеном), остальные подтверждают оплату сами — так работает mock.
+type PaymentProvider interface {
+	Name() string
+	Currency() string
+	ProviderToken() string // токен платёжного провайдера Telegram; для Stars пустой
+	UsesInvoice() bool
+	Refund(payment database.Payment) error
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]PaymentProvider{"mock": mockProvider{}}
+)
+
+// MockPaymentsEnabled — mock выдаёт тарифы без оплаты, поэтому работает только при явном
+// PAYMENT_MOCK=1 (разработка и тесты)
+func MockPaymentsEnabled() bool {
+	return os.Getenv("PAYMENT_MOCK") == "1"
+}
+
+// RegisterPaymentProvider добавляет провайдера; Stars регистрирует бот, потому что возврат идёт через Bot API
+func RegisterPaymentProvider(p PaymentProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// ActivePaymentProvider — провайдер из PAYMENT_PROVIDER (по умолчанию stars)
+func ActivePaymentProvider() (PaymentProvider, error) {
+	name := os.Getenv("PAYMENT_PROVIDER")
+	if name == "" {
+		name = "stars"
+	}
+	return paymentProvider(name)
+}
+
+func paymentProvider(name string) (PaymentProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok || (name == "mock" && !MockPaymentsEnabled()) {
+		return nil, fmt.Errorf("payment provider %q is not available", name)
+	}
+	return p, nil
+}
+
+// mockProvider — оплата без денег для локальной проверки: бот подтверждает её кнопкой
+type mockProvider struct{}
+
+func (mockProvider) Name() string                  { return "mock" }
+func (mockProvider) Currency() string              { return "XTR" }
+func (mockProvider) ProviderToken() string         { return "" }
+func (mockProvider) UsesInvoice() bool             { return false }
+func (mockProvider) Refund(database.Payment) error { return nil }
+
+// TopUpOffer — докупка трафика: TOPUP_GB за TOPUP_PRICE_STARS. Выключена, если что-то из них не задано.
+func TopUpOffer() (bytes int64, price int, ok bool) {
+	gb, _ := strconv.Atoi(os.Getenv("TOPUP_GB"))
+	price, _ = strconv.Atoi(os.Getenv("TOPUP_PRICE_STARS"))
+	if gb <= 0 || price <= 0 {
+		return 0, 0, false
+	}
+	return int64(gb) * 1024 * 1024 * 1024, price, true
+}
+
+// CreatePlanPayment заводит платёж за тариф в статусе pending
+func CreatePlanPayment(user database.User, plan database.Plan, provider PaymentProvider) (database.Payment, error) {
+	if plan.PriceStars <= 0 {
+		return database.Payment{}, fmt.Errorf("plan %q is not for sale", plan.Name)
+	}
+	payment := newPayment(user, provider, PaymentKindPlan, plan.PriceStars)
+	payment.PlanID = plan.ID
+	return payment, database.DB.Create(&payment).Error
+}
+
+// CreateTopUpPayment заводит платёж за докупку трафика в статусе pending
+func CreateTopUpPayment(user database.User, provider PaymentProvider) (database.Payment, error) {
+	_, price, ok := TopUpOffer()
+	if !ok || user.TrafficLimit == 0 {
+		return database.Payment{}, ErrTopUpUnavailable
+	}
+	payment := newPayment(user, provider, PaymentKindTopUp, price)
+	return payment, database.DB.Create(&payment).Error
+}
+
+func newPayment(user database.User, provider PaymentProvider, kind string, amount int) database.Payment {
+	return database.Payment{
+		UserID:     user.ID,
+		TelegramID: user.TelegramID,
+		Provider:   provider.Name(),
+		Kind:       kind,
+		Amount:     amount,
+		Currency:   provider.Currency(),
+		Status:     PaymentPending,
+		Payload:    database.GenerateToken(),
+	}
+}
+
+// CheckPendingPayment проверяет счёт перед списанием (pre_checkout_query)
+func CheckPendingPayment(payload string, telegramID int64, amount int, currency string) error {
+	var payment database.Payment
+	if err := database.DB.Where("payload = ?", payload).First(&payment).Error; err != nil {
+		return ErrPaymentNotFound
+	}
+	if payment.Status != PaymentPending {
+		return ErrPaymentNotPending
+	}
+	if payment.TelegramID != telegramID || payment.Amount != amount || payment.Currency != currency {
+		return ErrPaymentMismatch
+	}
+	if payment.Kind == PaymentKindPlan {
+		var plan database.Plan
+		if err := database.DB.First(&plan, payment.PlanID).Error; err != nil {
+			return fmt.Errorf("plan is no longer available")
+		}
+	}
+	return nil
+}
+
+// CompletePayment отмечает платёж оплаченным и начисляет тариф или трафик.
+// Повторный вызов с тем же payload ничего не начисляет и возвращает ErrPaymentNotPending.
+func CompletePayment(payload, chargeID string, amount int, currency string) (database.Payment, error) {
+	var payment database.Payment
+	if err := database.DB.Where("payload = ?", payload).First(&payment).Error; err != nil {
+		log.Printf("Payment with unknown payload %q paid: %d %s, charge %s", payload, amount, currency, chargeID)
+		NotifyAdmin(fmt.Sprintf("⚠️ Получена оплата по неизвестному счёту: %d %s, charge %s", amount, currency, chargeID))
+		return payment, ErrPaymentNotFound
+	}
+	if payment.Amount != amount || payment.Currency != currency {
+		log.Printf("Payment %d: paid %d %s, expected %d %s", payment.ID, amount, currency, payment.Amount, payment.Currency)
+		NotifyAdmin(fmt.Sprintf("⚠️ Платёж #%d: оплачено %d %s вместо %d %s, начисление не выполнено",
+			payment.ID, amount, currency, payment.Amount, payment.Currency))
+		return payment, ErrPaymentMismatch
+	}
+
+	now := time.Now()
+	res := database.DB.Model(&database.Payment{}).
+		Where("id = ? AND status = ?", payment.ID, PaymentPending).
+		Updates(map[string]interface{}{"status": PaymentPaid, "charge_id": chargeID, "paid_at": now})
+	if res.Error != nil {
+		return payment, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return payment, ErrPaymentNotPending
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, payment.UserID).Error; err != nil {
+		return payment, err
+	}
+
+	var days int
+	var bytes int64
+	var previous *database.PlanSnapshot
+	var err error
+	switch payment.Kind {
+	case PaymentKindPlan:
+		var plan database.Plan
+		if err = database.DB.First(&plan, payment.PlanID).Error; err == nil {
+			days, previous, err = creditPlan(&user, plan)
+		}
+	case PaymentKindTopUp:
+		bytes, _, _ = TopUpOffer()
+		err = creditUser(&user, 0, bytes)
+	}
+	if err != nil {
+		// Деньги списаны, а начислить не вышло — админ разберётся по логу и начислит вручную
+		log.Printf("Payment %d: paid but credit failed: %v", payment.ID, err)
+		NotifyAdmin(fmt.Sprintf("⚠️ Платёж #%d оплачен, но начислить не удалось: %v", payment.ID, err))
+		return payment, err
+	}
+
+	database.DB.First(&payment, payment.ID)
+	payment.CreditedDays = days
+	payment.CreditedBytes = bytes
+	if previous != nil {
+		payment.PlanSwitched = true
+		payment.Previous = *previous
+	}
+	database.DB.Save(&payment)
+	log.Printf("Payment %d: %s paid %d %s (%s)", payment.ID, user.Username, payment.Amount, payment.Currency, payment.Kind)
+	return payment, nil
+}
+
+// creditPlan продлевает текущий тариф на его срок или переводит юзера на новый тариф.
+// Возвращает начисленные дни и, если тариф сменился, прежние тариф и лимиты для возврата.
+func creditPlan(user *database.User, plan database.Plan) (int, *database.PlanSnapshot, error) {
+	if user.PlanID == plan.ID && plan.DurationDays > 0 && user.ExpiryDate != nil {
+		return plan.DurationDays, nil, ExtendUserExpiry(user, plan.DurationDays)
+	}
+	previous := database.PlanSnapshot{
+		PlanID:       user.PlanID,
+		TrafficLimit: user.TrafficLimit,
+		MaxDevices:   user.MaxDevices,
+		ResetPeriod:  user.ResetPeriod,
+		ResetDay:     user.ResetDay,
+		ExpiryDate:   user.ExpiryDate,
+	}
+	if err := ApplyPlan(user, plan); err != nil {
+		return 0, nil, err
+	}
+	RequestReload("payment: plan " + user.Username)
+	return plan.DurationDays, &previous, nil
+}
+
+// creditUser продлевает подписку на days дней (если она ограничена) и добавляет bytes к лимиту трафика.
+// Юзер, заблокированный за квоту, реактивируется, если лимит больше не исчерпан.
+func creditUser(user *database.User, days int, bytes int64) error {
+	if days > 0 && user.ExpiryDate != nil {
+		if err := ExtendUserExpiry(user, days); err != nil {
+			return err
+		}
+	}
+	if bytes == 0 || user.TrafficLimit == 0 {
+		return nil
+	}
+
+	user.TrafficLimit += bytes
+	reactivated := false
+	if user.Status == "expired" && user.ExpiredReason == "quota" && user.TrafficUsed < user.TrafficLimit &&
+		(user.ExpiryDate == nil || user.ExpiryDate.After(time.Now())) {
+		user.Status = "active"
+		user.ExpiredReason = ""
+		reactivated = true
+	}
+	if err := database.DB.Save(user).Error; err != nil {
+		return err
+	}
+	if reactivated {
+		RequestReload("payment: traffic top-up " + user.Username)
+	}
+	return nil
+}
+
+// CreditUserManually — ручное начисление админом, записывается как платёж provider=manual
+func CreditUserManually(user *database.User, days int, bytes int64, note string) (database.Payment, error) {
+	now := time.Now()
+	payment := database.Payment{
+		UserID:        user.ID,
+		TelegramID:    user.TelegramID,
+		Provider:      "manual",
+		Kind:          PaymentKindManual,
+		Status:        PaymentPaid,
+		Payload:       database.GenerateToken(),
+		CreditedDays:  days,
+		CreditedBytes: bytes,
+		Note:          note,
+		PaidAt:        &now,
+	}
+	if user.ExpiryDate == nil {
+		payment.CreditedDays = 0
+	}
+	if user.TrafficLimit == 0 {
+		payment.CreditedBytes = 0
+	}
+
+	if err := database.DB.Create(&payment).Error; err != nil {
+		return payment, err
+	}
+	if err := creditUser(user, days, bytes); err != nil {
+		return payment, err
+	}
+
+	NotifyUser(user.TelegramID, fmt.Sprintf("🎁 Администратор начислил вам: %s.", PaymentCreditText(payment)))
+	return payment, nil
+}
+
+// RefundPayment возвращает деньги через провайдера и списывает начисленное.
+// Платёж сначала атомарно помечается возвращённым, поэтому параллельный второй возврат ничего не спишет.
+func RefundPayment(payment *database.Payment, note string) error {
+	now := time.Now()
+	res := database.DB.Model(&database.Payment{}).
+		Where("id = ? AND status = ?", payment.ID, PaymentPaid).
+		Updates(map[string]interface{}{"status": PaymentRefunded, "refunded_at": now, "note": note})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPaymentNotPaid
+	}
+	if err := database.DB.First(payment, payment.ID).Error; err != nil {
+		return err
+	}
+
+	if payment.Provider != "manual" {
+		provider, err := paymentProvider(payment.Provider)
+		if err == nil {
+			err = provider.Refund(*payment)
+		}
+		if err != nil {
+			// Деньги не вернулись — платёж снова оплачен, начисленное остаётся
+			database.DB.Model(&database.Payment{}).Where("id = ? AND status = ?", payment.ID, PaymentRefunded).
+				Updates(map[string]interface{}{"status": PaymentPaid, "refunded_at": nil})
+			database.DB.First(payment, payment.ID)
+			return err
+		}
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, payment.UserID).Error; err != nil {
+		return nil
+	}
+	if payment.PlanSwitched {
+		revertPlan(&user, payment)
+	} else {
+		revertCredit(&user, payment)
+	}
+
+	log.Printf("Payment %d refunded (%s)", payment.ID, payment.Provider)
+	if payment.Provider != "manual" {
+		NotifyUser(user.TelegramID, fmt.Sprintf("↩️ Платёж #%d возвращён: %d %s.", payment.ID, payment.Amount, payment.Currency))
+	}
+	return nil
+}
+
+// revertPlan возвращает юзеру тариф, лимиты и срок, которые были до покупки
+func revertPlan(user *database.User, payment *database.Payment) {
+	prev := payment.Previous
+	user.PlanID = prev.PlanID
+	user.TrafficLimit = prev.TrafficLimit
+	user.MaxDevices = prev.MaxDevices
+	user.ResetPeriod = prev.ResetPeriod
+	user.ResetDay = prev.ResetDay
+	if err := SetUserExpiry(user, prev.ExpiryDate); err != nil {
+		log.Printf("Refund %d: failed to restore plan: %v", payment.ID, err)
+		return
+	}
+	checkLimits(user.Username)
+	RequestReload("refund: plan " + user.Username)
+}
+
+// revertCredit списывает начисленные платежом дни и трафик
+func revertCredit(user *database.User, payment *database.Payment) {
+	if payment.CreditedDays > 0 && user.ExpiryDate != nil {
+		expiry := user.ExpiryDate.AddDate(0, 0, -payment.CreditedDays)
+		if err := SetUserExpiry(user, &expiry); err != nil {
+			log.Printf("Refund %d: failed to shorten expiry: %v", payment.ID, err)
+		}
+	}
+	if payment.CreditedBytes > 0 && user.TrafficLimit > 0 {
+		// 0 означает безлимит, поэтому лимит не опускается ниже 1 байта — трафика просто не остаётся
+		limit := max(user.TrafficLimit-payment.CreditedBytes, 1)
+		database.DB.Model(user).Update("traffic_limit", limit)
+		checkLimits(user.Username)
+	}
+}
+
+// PaymentCreditText — что начислено платежом, для сообщений юзеру
+func PaymentCreditText(p database.Payment) string {
+	text := ""
+	if p.CreditedDays > 0 {
+		text = fmt.Sprintf("%d дн. подписки", p.CreditedDays)
+	}
+	if p.CreditedBytes > 0 {
+		if text != "" {
+			text += " и "
+		}
+		text += formatGB(p.CreditedBytes) + " трафика"
+	}
+	if text == "" {
+		text = "тариф"
+	}
+	return text
+}
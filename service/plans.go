@@ -0,0 +1,144 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"vpnbot/database"
+)
+
+// ValidatePlan проверяет и нормализует тариф перед сохранением
+func ValidatePlan(plan *database.Plan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if plan.TrafficLimit < 0 || plan.MaxDevices < 0 || plan.DurationDays < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if plan.ResetPeriod == "" {
+		plan.ResetPeriod = ResetNone
+	}
+	// ResetDay = 0 для monthly означает «день назначения плана», его проверит ApplyPlan
+	if !(plan.ResetPeriod == ResetMonthly && plan.ResetDay == 0) {
+		if err := ValidateResetPolicy(plan.ResetPeriod, plan.ResetDay); err != nil {
+			return err
+		}
+	}
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range plan.AllowedInbounds {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		var count int64
+		database.DB.Model(&database.InboundConfig{}).Where("tag = ?", tag).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("unknown inbound tag %q", tag)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	plan.AllowedInbounds = tags
+	return nil
+}
+
+// ApplyPlan назначает юзеру тариф: копирует лимиты и политику сброса, срок считается от сейчас.
+// Сохраняет юзера, но не перезагружает конфиг — это делает вызывающий.
+func ApplyPlan(user *database.User, plan database.Plan) error {
+	now := time.Now()
+
+	user.PlanID = plan.ID
+	user.TrafficLimit = plan.TrafficLimit
+	user.MaxDevices = plan.MaxDevices
+	user.ResetPeriod = plan.ResetPeriod
+	user.ResetDay = plan.ResetDay
+	if plan.ResetPeriod == ResetMonthly && plan.ResetDay == 0 {
+		user.ResetDay = min(now.Day(), 28)
+	}
+	if plan.DurationDays > 0 {
+		expiry := now.AddDate(0, 0, plan.DurationDays)
+		user.ExpiryDate = &expiry
+	} else {
+		user.ExpiryDate = nil
+	}
+
+	// Новый тариф снимает блокировку за лимит/срок, если она больше не актуальна
+	if user.Status == "expired" &&
+		(user.TrafficLimit == 0 || user.TrafficUsed < user.TrafficLimit) &&
+		(user.ExpiryDate == nil || user.ExpiryDate.After(now)) {
+		user.Status = "active"
+		user.ExpiredReason = ""
+	}
+
+	return database.DB.Save(user).Error
+}
+
+// inboundAccess — какие инбаунды доступны юзерам по их тарифам
+type inboundAccess map[uint]map[string]bool // planID → разрешённые теги; нет записи = все
+
+func loadInboundAccess() inboundAccess {
+	var plans []database.Plan
+	database.DB.Find(&plans)
+	access := inboundAccess{}
+	for _, p := range plans {
+		if len(p.AllowedInbounds) == 0 {
+			continue
+		}
+		tags := map[string]bool{}
+		for _, tag := range p.AllowedInbounds {
+			tags[tag] = true
+		}
+		access[p.ID] = tags
+	}
+	return access
+}
+
+func (a inboundAccess) allowed(user database.User, tag string) bool {
+	tags, ok := a[user.PlanID]
+	return !ok || tags[tag]
+}
+
+func (a inboundAccess) filterUsers(users []database.User, tag string) []database.User {
+	if len(a) == 0 {
+		return users
+	}
+	result := make([]database.User, 0, len(users))
+	for _, u := range users {
+		if a.allowed(u, tag) {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// UserInboundAllowed — доступен ли инбаунд юзеру по его тарифу
+func UserInboundAllowed(user database.User, tag string) bool {
+	if user.PlanID == 0 {
+		return true
+	}
+	var plan database.Plan
+	if database.DB.First(&plan, user.PlanID).Error != nil || len(plan.AllowedInbounds) == 0 {
+		return true
+	}
+	for _, t := range plan.AllowedInbounds {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientInboundsFor — ClientInbounds, доступные юзеру по его тарифу
+func ClientInboundsFor(user database.User) []database.InboundConfig {
+	access := loadInboundAccess()
+	result := []database.InboundConfig{}
+	for _, ib := range ClientInbounds() {
+		if access.allowed(user, ib.Tag) {
+			result = append(result, ib)
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"vpnbot/database"
+)
+
+var ErrTelemetNotConfigured = errors.New("telemt proxy is not configured")
+
+// DefaultServerIP — адрес сервера для ссылок, если у инбаунда/ноды не задан свой
+func DefaultServerIP() string {
+	if ip := os.Getenv("SERVER_IP"); ip != "" {
+		return ip
+	}
+	return "49.13.201.110"
+}
+
+// SubscriptionURL — публичная ссылка подписки юзера
+func SubscriptionURL(token string) string {
+	if domain := os.Getenv("SERVER_DOMAIN"); domain != "" {
+		return fmt.Sprintf("https://%s/sub/%s", domain, token)
+	}
+	return fmt.Sprintf("https://%s:8085/sub/%s", DefaultServerIP(), token)
+}
+
+// PortalURL — ссылка на личный кабинет юзера (API /portal/:token)
+func PortalURL(token string) string {
+	if domain := os.Getenv("SERVER_DOMAIN"); domain != "" {
+		return fmt.Sprintf("https://%s/portal/%s", domain, token)
+	}
+	return fmt.Sprintf("https://%s:8085/portal/%s", DefaultServerIP(), token)
+}
+
+// ExistingTelemetLink возвращает ссылку tg://proxy юзера, если секрет уже заведён.
+// Ничего не пишет: секреты активным юзерам заводит SyncTelemetUsers при перегенерации.
+func ExistingTelemetLink(user database.User) (string, error) {
+	var cfg database.TelemetConfig
+	if err := database.DB.First(&cfg).Error; err != nil || !cfg.Enabled {
+		return "", ErrTelemetNotConfigured
+	}
+
+	var tu database.TelemetUser
+	if err := database.DB.Where("user_id = ? AND telemet_config_id = ?", user.ID, cfg.ID).First(&tu).Error; err != nil {
+		return "", err
+	}
+	return telemetLink(cfg, tu.Secret), nil
+}
+
+// TelemetLinkForUser возвращает ссылку tg://proxy юзера, при необходимости заводя ему секрет
+func TelemetLinkForUser(user database.User, trigger string) (string, error) {
+	var cfg database.TelemetConfig
+	if err := database.DB.First(&cfg).Error; err != nil || !cfg.Enabled {
+		return "", ErrTelemetNotConfigured
+	}
+
+	// Ищем или создаём TelemetUser (атомарно через FirstOrCreate)
+	var tu database.TelemetUser
+	result := database.DB.Where("user_id = ? AND telemet_config_id = ?", user.ID, cfg.ID).
+		Attrs(database.TelemetUser{
+			Label:  user.Username,
+			Secret: GenerateSecret(),
+		}).
+		FirstOrCreate(&tu)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected > 0 {
+		// Новый секрет создан — перегенерируем конфиг telemt
+		ReloadNow(trigger + ": telemt secret for " + user.Username)
+	}
+
+	return telemetLink(cfg, tu.Secret), nil
+}
+
+func telemetLink(cfg database.TelemetConfig, secret string) string {
+	serverAddr := cfg.ServerAddress
+	if serverAddr == "" {
+		serverAddr = DefaultServerIP()
+	}
+
+	tlsDomain := cfg.TLSDomain
+	if tlsDomain == "" {
+		tlsDomain = "dl.google.com"
+	}
+
+	return GenerateTelemetProxyLink(serverAddr, cfg.Port, secret, tlsDomain)
+}
+
+// PortalRotateCooldown — как часто юзер может перевыпускать ключи из кабинета
+const PortalRotateCooldown = 10 * time.Minute
+
+var (
+	portalRotateMu   sync.Mutex
+	portalRotateLast = map[uint]time.Time{}
+)
+
+// PortalRotateAllowed отмечает перевыпуск из кабинета и разрешает его не чаще PortalRotateCooldown.
+// Ключ — юзер, а не токен: после перевыпуска токена кулдаун не должен сбрасываться.
+// Если ещё рано, возвращает, сколько осталось ждать.
+func PortalRotateAllowed(userID uint) (time.Duration, bool) {
+	portalRotateMu.Lock()
+	defer portalRotateMu.Unlock()
+
+	now := time.Now()
+	for id, at := range portalRotateLast {
+		if now.Sub(at) >= PortalRotateCooldown {
+			delete(portalRotateLast, id)
+		}
+	}
+	if at, ok := portalRotateLast[userID]; ok {
+		return PortalRotateCooldown - now.Sub(at), false
+	}
+	portalRotateLast[userID] = now
+	return 0, true
+}
+
+// RotatePortalCredentials — перевыпуск из кабинета: как RotateUserCredentials, но перезагрузка
+// только ставится в очередь, чтобы публичный эндпоинт её не ждал
+func RotatePortalCredentials(user *database.User, parts CredentialParts, trigger string) error {
+	return rotateUserCredentials(user, parts, trigger, false)
+}
@@ -178,7 +178,21 @@ func sshConnect() (*ssh.Client, error) {
 		return nil, fmt.Errorf("RUVDS_IP не задан")
 	}
 
-	keyPath := os.Getenv("RUVDS_SSH_KEY_PATH")
+	user := os.Getenv("RUVDS_SSH_USER")
+	if user == "" {
+		user = "root"
+	}
+
+	port := os.Getenv("RUVDS_SSH_PORT")
+	if port == "" {
+		port = "22"
+	}
+
+	return sshDial(ruvdsIP, port, user, os.Getenv("RUVDS_SSH_KEY_PATH"))
+}
+
+// sshDial подключается по SSH с ключом keyPath (пусто = ~/.ssh/id_rsa)
+func sshDial(host, port, user, keyPath string) (*ssh.Client, error) {
 	if keyPath == "" {
 		home, _ := os.UserHomeDir()
 		keyPath = filepath.Join(home, ".ssh", "id_rsa")
@@ -194,16 +208,6 @@ func sshConnect() (*ssh.Client, error) {
 		return nil, fmt.Errorf("не удалось распарсить SSH ключ: %w", err)
 	}
 
-	user := os.Getenv("RUVDS_SSH_USER")
-	if user == "" {
-		user = "root"
-	}
-
-	port := os.Getenv("RUVDS_SSH_PORT")
-	if port == "" {
-		port = "22"
-	}
-
 	config := &ssh.ClientConfig{
 		User: user,
 		Auth: []ssh.AuthMethod{
@@ -213,7 +217,7 @@ func sshConnect() (*ssh.Client, error) {
 		Timeout:         10 * time.Second,
 	}
 
-	addr := net.JoinHostPort(ruvdsIP, port)
+	addr := net.JoinHostPort(host, port)
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось подключиться по SSH к %s: %w", addr, err)
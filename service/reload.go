@@ -0,0 +1,228 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"vpnbot/database"
+)
+
+// ReloadDebounce — окно, в течение которого запросы перегенерации склеиваются в одну
+const ReloadDebounce = time.Second
+
+type reloadRequest struct {
+	trigger string
+	force   bool
+	done    chan error // nil — вызывающий не ждёт результата
+
+	// job — запись конфига в обход рендера (восстановление ревизии, настройка telemt).
+	// Выполняется в горутине координатора и может поправить applied.
+	job func(applied map[string]string) error
+}
+
+var (
+	reloadQueue     = make(chan reloadRequest, 256)
+	reloadStartOnce sync.Once
+)
+
+// RequestReload ставит перегенерацию конфигов в очередь и сразу возвращается
+func RequestReload(trigger string) {
+	reloadStartOnce.Do(startReloadCoordinator)
+	reloadQueue <- reloadRequest{trigger: trigger}
+}
+
+// ReloadNow ставит перегенерацию в очередь и ждёт её результата
+func ReloadNow(trigger string) error {
+	return waitReload(reloadRequest{trigger: trigger})
+}
+
+// ForceReload как ReloadNow, но выкладывает конфиги на все ноды, даже если они не изменились
+func ForceReload(trigger string) error {
+	return waitReload(reloadRequest{trigger: trigger, force: true})
+}
+
+// runInReloadCoordinator выполняет job в горутине координатора, не пересекаясь с перегенерацией
+func runInReloadCoordinator(job func(applied map[string]string) error) error {
+	return waitReload(reloadRequest{job: job})
+}
+
+// appliedKey — ключ хэша применённого конфига в applied
+func appliedKey(kind string, nodeID uint) string {
+	if kind == ConfigKindTelemt {
+		return ConfigKindTelemt
+	}
+	return fmt.Sprintf("%s|%d", kind, nodeID)
+}
+
+func waitReload(req reloadRequest) error {
+	reloadStartOnce.Do(startReloadCoordinator)
+	req.done = make(chan error, 1)
+	reloadQueue <- req
+	return <-req.done
+}
+
+// startReloadCoordinator запускает единственную горутину, которая пишет конфиги.
+// Запросы, пришедшие в течение ReloadDebounce после первого, выполняются одним проходом.
+func startReloadCoordinator() {
+	go func() {
+		// Хэш последнего успешно применённого конфига: "singbox|<node>" / "telemt"
+		applied := map[string]string{}
+
+		for first := range reloadQueue {
+			if first.job != nil {
+				first.done <- first.job(applied)
+				continue
+			}
+
+			batch := []reloadRequest{first}
+			var jobs []reloadRequest
+			timer := time.NewTimer(ReloadDebounce)
+		collect:
+			for {
+				select {
+				case req := <-reloadQueue:
+					if req.job != nil {
+						jobs = append(jobs, req)
+					} else {
+						batch = append(batch, req)
+					}
+				case <-timer.C:
+					break collect
+				}
+			}
+
+			force := false
+			var triggers []string
+			seen := map[string]bool{}
+			for _, req := range batch {
+				force = force || req.force
+				if !seen[req.trigger] {
+					seen[req.trigger] = true
+					triggers = append(triggers, req.trigger)
+				}
+			}
+
+			err := runReload(strings.Join(triggers, "; "), force, applied)
+			for _, req := range batch {
+				if req.done != nil {
+					req.done <- err
+				}
+			}
+			for _, req := range jobs {
+				req.done <- req.job(applied)
+			}
+		}
+	}()
+}
+
+// NodeReloadError — ошибка применения конфига sing-box на конкретной ноде
+type NodeReloadError struct {
+	NodeID uint
+	Node   string
+	Err    error
+}
+
+func (e *NodeReloadError) Error() string { return e.Node + ": " + e.Err.Error() }
+func (e *NodeReloadError) Unwrap() error { return e.Err }
+
+// NodeReloadErrors раскладывает ошибку перегенерации по нодам. Ошибки не нод (telemt) не попадают.
+func NodeReloadErrors(err error) map[uint]*NodeReloadError {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	result := map[uint]*NodeReloadError{}
+	for _, e := range errs {
+		var nodeErr *NodeReloadError
+		if errors.As(e, &nodeErr) {
+			result[nodeErr.NodeID] = nodeErr
+		}
+	}
+	return result
+}
+
+func configHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// runReload синхронизирует секреты telemt, рендерит конфиги sing-box для каждой ноды и конфиг
+// telemt, и применяет только те, что отличаются от последнего успешно применённого
+func runReload(trigger string, force bool, applied map[string]string) error {
+	SyncTelemetUsers()
+
+	// Юзеры, приостановленные за превышение лимита устройств, в конфиг не попадают
+	var users []database.User
+	database.DB.Where("status = ? AND (suspended_until IS NULL OR suspended_until <= ?)", "active", time.Now()).Find(&users)
+
+	access := loadInboundAccess()
+
+	var errs []error
+	for _, node := range NodeClients() {
+		var inbounds []database.InboundConfig
+		database.DB.Where("enabled = ? AND node_id = ?", true, node.ID()).Order("sort_order").Find(&inbounds)
+
+		cfg := buildSingboxConfig(inbounds, users, access, node.StatsListen())
+		file, _ := json.MarshalIndent(cfg, "", "  ")
+
+		key := appliedKey(ConfigKindSingbox, node.ID())
+		hash := configHash(file)
+		if !force && applied[key] == hash {
+			continue
+		}
+
+		err := node.ApplySingboxConfig(file)
+		recordConfigRevision(ConfigKindSingbox, node.ID(), file, trigger, err)
+		if err != nil {
+			log.Printf("Failed to apply sing-box config on %s: %v", node.Name(), err)
+			errs = append(errs, &NodeReloadError{NodeID: node.ID(), Node: node.Name(), Err: err})
+			delete(applied, key)
+			continue
+		}
+		applied[key] = hash
+	}
+
+	if err := reloadTelemet(trigger, force, applied); err != nil {
+		errs = append(errs, fmt.Errorf("telemt: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// reloadTelemet перезапускает telemt, только если его конфиг изменился
+func reloadTelemet(trigger string, force bool, applied map[string]string) error {
+	var cfg database.TelemetConfig
+	if err := database.DB.First(&cfg).Error; err != nil || !cfg.Enabled {
+		return nil
+	}
+
+	data := renderTelemetConfig(cfg)
+	hash := configHash(data)
+
+	if _, ok := applied[ConfigKindTelemt]; !ok {
+		// После старта сравниваем с тем, что уже лежит на диске, чтобы не перезапускать telemt зря
+		if current, err := os.ReadFile(TelemetConfigPath); err == nil {
+			applied[ConfigKindTelemt] = configHash(current)
+		}
+	}
+	if !force && applied[ConfigKindTelemt] == hash {
+		return nil
+	}
+
+	err := localNode{}.ApplyTelemetConfig(data)
+	recordConfigRevision(ConfigKindTelemt, 0, data, trigger, err)
+	if err != nil {
+		delete(applied, ConfigKindTelemt)
+		return err
+	}
+	applied[ConfigKindTelemt] = hash
+	return nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"vpnbot/database"
+
+	"github.com/google/uuid"
+)
+
+// Статусы заявок на доступ
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
+	RequestBanned   = "banned"
+)
+
+var (
+	ErrRequestAlreadyDecided = errors.New("request is already decided")
+	ErrUserAlreadyExists     = errors.New("user with this Telegram ID already exists")
+)
+
+// accessRequestCooldown — через сколько после отказа можно подать заявку снова.
+// ACCESS_REQUEST_COOLDOWN_HOURS, по умолчанию 24.
+func accessRequestCooldown() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("ACCESS_REQUEST_COOLDOWN_HOURS")); err == nil && v >= 0 {
+		return time.Duration(v) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// SubmitResult — что произошло с заявкой при повторной подаче
+type SubmitResult int
+
+const (
+	SubmitNew      SubmitResult = iota // новая заявка или повтор после отказа — админа уведомить
+	SubmitPending                      // уже ждёт решения — админа не беспокоим
+	SubmitCooldown                     // отказ был недавно
+	SubmitBanned                       // заявитель забанен
+	SubmitApproved                     // уже одобрен
+)
+
+// SubmitAccessRequest регистрирует заявку с дедупликацией по Telegram ID
+func SubmitAccessRequest(telegramID int64, username, firstName string) (database.AccessRequest, SubmitResult, error) {
+	var req database.AccessRequest
+	err := database.DB.Where("telegram_id = ?", telegramID).First(&req).Error
+	if err != nil {
+		req = database.AccessRequest{
+			TelegramID:       telegramID,
+			TelegramUsername: username,
+			FirstName:        firstName,
+			Status:           RequestPending,
+			Attempts:         1,
+		}
+		return req, SubmitNew, database.DB.Create(&req).Error
+	}
+
+	switch req.Status {
+	case RequestPending:
+		database.DB.Model(&req).Update("attempts", req.Attempts+1)
+		return req, SubmitPending, nil
+	case RequestBanned:
+		return req, SubmitBanned, nil
+	case RequestRejected:
+		if req.DecidedAt != nil && time.Since(*req.DecidedAt) < accessRequestCooldown() {
+			return req, SubmitCooldown, nil
+		}
+	case RequestApproved:
+		// Юзера могли удалить из админки — тогда заявку можно подать заново
+		var count int64
+		database.DB.Model(&database.User{}).Where("telegram_id = ?", telegramID).Count(&count)
+		if count > 0 {
+			return req, SubmitApproved, nil
+		}
+	}
+
+	err = database.DB.Model(&req).Updates(map[string]interface{}{
+		"status":            RequestPending,
+		"reason":            "",
+		"attempts":          req.Attempts + 1,
+		"telegram_username": username,
+		"first_name":        firstName,
+		"decided_at":        nil,
+		"decided_by":        0,
+	}).Error
+	database.DB.First(&req, req.ID)
+	return req, SubmitNew, err
+}
+
+// PendingAccessRequests — очередь заявок, старые первыми
+func PendingAccessRequests(limit int) ([]database.AccessRequest, int64) {
+	var total int64
+	database.DB.Model(&database.AccessRequest{}).Where("status = ?", RequestPending).Count(&total)
+	var reqs []database.AccessRequest
+	database.DB.Where("status = ?", RequestPending).Order("updated_at").Limit(limit).Find(&reqs)
+	return reqs, total
+}
+
+// decideAccessRequest переводит заявку из статуса from (по умолчанию pending) в итоговый
+func decideAccessRequest(req *database.AccessRequest, status, reason string, adminID int64, userID uint, from ...string) error {
+	if len(from) == 0 {
+		from = []string{RequestPending}
+	}
+	now := time.Now()
+	res := database.DB.Model(&database.AccessRequest{}).
+		Where("id = ? AND status IN ?", req.ID, from).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"reason":     reason,
+			"decided_at": now,
+			"decided_by": adminID,
+			"user_id":    userID,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRequestAlreadyDecided
+	}
+	database.DB.First(req, req.ID)
+	return nil
+}
+
+// ApproveAccessRequest заводит юзера по заявке и назначает тариф.
+// plan == nil — значения по умолчанию: 30 GB с ежемесячным сбросом.
+func ApproveAccessRequest(req *database.AccessRequest, plan *database.Plan, adminID int64) (database.User, error) {
+	var user database.User
+	if req.Status != RequestPending {
+		return user, ErrRequestAlreadyDecided
+	}
+	if database.DB.Where("telegram_id = ?", req.TelegramID).First(&user).Error == nil {
+		decideAccessRequest(req, RequestApproved, "", adminID, user.ID)
+		return user, ErrUserAlreadyExists
+	}
+
+	user, err := createBotUser(req.TelegramID, req.TelegramUsername, plan, nil)
+	if err != nil {
+		return user, err
+	}
+	if err := decideAccessRequest(req, RequestApproved, "", adminID, user.ID); err != nil {
+		return user, err
+	}
+
+	RequestReload("bot: approve @" + user.Username)
+	return user, nil
+}
+
+// createBotUser заводит юзера из Telegram: по умолчанию 30 GB с ежемесячным сбросом, plan их заменяет.
+// prepare может дополнить юзера перед созданием. Конфиг не перезагружает — это делает вызывающий.
+func createBotUser(telegramID int64, telegramUsername string, plan *database.Plan, prepare func(*database.User)) (database.User, error) {
+	user := database.User{
+		UUID:              uuid.New().String(),
+		Username:          fmt.Sprintf("user_%d", telegramID), // Техническое имя для VLESS конфига
+		TelegramUsername:  telegramUsername,
+		TelegramID:        telegramID,
+		Status:            "active",
+		TrafficLimit:      30 * 1024 * 1024 * 1024,
+		ResetPeriod:       ResetMonthly,
+		ResetDay:          min(time.Now().Day(), 28),
+		SubscriptionToken: database.GenerateToken(),
+	}
+	if prepare != nil {
+		prepare(&user)
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		return user, err
+	}
+	if plan != nil {
+		if err := ApplyPlan(&user, *plan); err != nil {
+			return user, err
+		}
+	}
+	return user, nil
+}
+
+// RejectAccessRequest отклоняет заявку; reason увидит заявитель
+func RejectAccessRequest(req *database.AccessRequest, reason string, adminID int64) error {
+	return decideAccessRequest(req, RequestRejected, reason, adminID, 0)
+}
+
+// BanAccessRequest отклоняет заявку навсегда: повторные заявки игнорируются.
+// Забанить можно и уже отклонённую заявку.
+func BanAccessRequest(req *database.AccessRequest, adminID int64) error {
+	return decideAccessRequest(req, RequestBanned, "", adminID, 0, RequestPending, RequestRejected)
+}
+
+// AccessRequestRetryAt — когда отклонённый заявитель сможет подать заявку снова
+func AccessRequestRetryAt(req database.AccessRequest) time.Time {
+	if req.DecidedAt == nil {
+		return time.Now()
+	}
+	return req.DecidedAt.Add(accessRequestCooldown())
+}
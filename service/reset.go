@@ -0,0 +1,212 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ResetNone    = "none"
+	ResetMonthly = "monthly"
+	ResetWeekly  = "weekly"
+	ResetDays    = "days"
+)
+
+// TrafficResetCheckInterval — как часто планировщик ищет юзеров, у которых закончился период
+const TrafficResetCheckInterval = 5 * time.Minute
+
+// ValidateResetPolicy проверяет пару ResetPeriod/ResetDay
+func ValidateResetPolicy(period string, day int) error {
+	switch period {
+	case "", ResetNone:
+		return nil
+	case ResetMonthly:
+		if day < 1 || day > 28 {
+			return fmt.Errorf("reset_day for monthly reset must be 1..28")
+		}
+	case ResetWeekly:
+		if day < 0 || day > 6 {
+			return fmt.Errorf("reset_day for weekly reset must be 0..6 (0 = Sunday)")
+		}
+	case ResetDays:
+		if day < 1 {
+			return fmt.Errorf("reset_day for days reset must be at least 1")
+		}
+	default:
+		return fmt.Errorf("reset_period must be one of none, monthly, weekly, days")
+	}
+	return nil
+}
+
+// currentPeriodStart — начало текущего расчётного периода юзера
+func currentPeriodStart(user database.User) time.Time {
+	if user.LastResetAt != nil {
+		return *user.LastResetAt
+	}
+	return user.CreatedAt
+}
+
+// nextResetAfter возвращает ближайший момент сброса строго после t
+func nextResetAfter(user database.User, t time.Time) *time.Time {
+	t = t.Local()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+
+	var next time.Time
+	switch user.ResetPeriod {
+	case ResetMonthly:
+		next = time.Date(t.Year(), t.Month(), user.ResetDay, 0, 0, 0, 0, time.Local)
+		if !next.After(t) {
+			next = next.AddDate(0, 1, 0)
+		}
+	case ResetWeekly:
+		shift := (user.ResetDay - int(t.Weekday()) + 7) % 7
+		next = midnight.AddDate(0, 0, shift)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case ResetDays:
+		if user.ResetDay < 1 {
+			return nil
+		}
+		next = t.AddDate(0, 0, user.ResetDay)
+	default:
+		return nil
+	}
+	return &next
+}
+
+// NextTrafficReset возвращает дату следующего сброса трафика или nil, если сброс не настроен
+func NextTrafficReset(user database.User) *time.Time {
+	return nextResetAfter(user, currentPeriodStart(user))
+}
+
+// SubscriptionExpire — значение expire для заголовка Subscription-Userinfo:
+// дата следующего сброса трафика, а если подписка закончится раньше — ExpiryDate.
+func SubscriptionExpire(user database.User) *time.Time {
+	next := NextTrafficReset(user)
+	if user.ExpiryDate != nil && (next == nil || user.ExpiryDate.Before(*next)) {
+		return user.ExpiryDate
+	}
+	return next
+}
+
+// StartTrafficResetScheduler запускает фоновый сброс трафика по расписанию юзеров
+func StartTrafficResetScheduler() {
+	go func() {
+		ResetDueUsers()
+
+		ticker := time.NewTicker(TrafficResetCheckInterval)
+		for range ticker.C {
+			ResetDueUsers()
+		}
+	}()
+}
+
+// ResetDueUsers сбрасывает трафик всем юзерам, у которых закончился расчётный период.
+// Если кого-то реактивировали, конфиги перегенерируются один раз на всю пачку.
+func ResetDueUsers() int {
+	var users []database.User
+	database.DB.Where("reset_period IN ?", []string{ResetMonthly, ResetWeekly, ResetDays}).Find(&users)
+
+	now := time.Now()
+	resetCount, reactivated := 0, 0
+	for i := range users {
+		user := &users[i]
+		next := NextTrafficReset(*user)
+		if next == nil || next.After(now) {
+			continue
+		}
+
+		// После простоя могло пройти несколько периодов — новый период начинается с последней границы
+		periodStart := *next
+		for {
+			n := nextResetAfter(*user, periodStart)
+			if n == nil || n.After(now) {
+				break
+			}
+			periodStart = *n
+		}
+
+		ok, err := resetUserTraffic(user, periodStart)
+		if err != nil {
+			log.Printf("Traffic reset failed for %s: %v", user.Username, err)
+			continue
+		}
+		resetCount++
+		if ok {
+			reactivated++
+		}
+	}
+
+	if reactivated > 0 {
+		RequestReload("scheduler: traffic reset")
+	}
+
+	return resetCount
+}
+
+// ResetUserTraffic вручную закрывает текущий период и начинает новый с текущего момента
+func ResetUserTraffic(user *database.User) error {
+	reactivated, err := resetUserTraffic(user, time.Now())
+	if err != nil {
+		return err
+	}
+	if reactivated {
+		RequestReload("traffic reset: " + user.Username)
+	}
+	return nil
+}
+
+// resetUserTraffic архивирует период, обнуляет TrafficUsed и возвращает юзера в active,
+// если он был expired только из-за квоты. Возвращает true, если юзер реактивирован.
+// Счётчики перечитываются в транзакции и вычитаются, а не обнуляются: трафик, который
+// поллер успел дописать между чтением и записью, остаётся в новом периоде.
+func resetUserTraffic(user *database.User, periodStart time.Time) (bool, error) {
+	reactivated := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var fresh database.User
+		if err := tx.First(&fresh, user.ID).Error; err != nil {
+			return err
+		}
+
+		archive := database.TrafficPeriod{
+			UserID:       fresh.ID,
+			PeriodStart:  currentPeriodStart(fresh),
+			PeriodEnd:    periodStart,
+			TrafficUsed:  fresh.TrafficUsed,
+			Uplink:       fresh.TrafficUplink,
+			Downlink:     fresh.TrafficDownlink,
+			TrafficLimit: fresh.TrafficLimit,
+		}
+		if err := tx.Create(&archive).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"traffic_used":     gorm.Expr("traffic_used - ?", fresh.TrafficUsed),
+			"traffic_uplink":   gorm.Expr("traffic_uplink - ?", fresh.TrafficUplink),
+			"traffic_downlink": gorm.Expr("traffic_downlink - ?", fresh.TrafficDownlink),
+			"last_reset_at":    periodStart,
+		}
+		notExpired := fresh.ExpiryDate == nil || fresh.ExpiryDate.After(time.Now())
+		if fresh.Status == "expired" && fresh.ExpiredReason == "quota" && notExpired {
+			updates["status"] = "active"
+			updates["expired_reason"] = ""
+			reactivated = true
+		}
+
+		return tx.Model(&fresh).Updates(updates).Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	database.DB.First(user, user.ID)
+
+	log.Printf("Traffic reset for %s (reactivated: %v)", user.Username, reactivated)
+	return reactivated, nil
+}
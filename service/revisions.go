@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"vpnbot/database"
+)
+
+const (
+	ConfigKindSingbox = "singbox"
+	ConfigKindTelemt  = "telemt"
+)
+
+// configRevisionKeep — сколько ревизий хранить на каждую пару kind/нода,
+// CONFIG_REVISIONS_KEEP (по умолчанию 200)
+func configRevisionKeep() int {
+	if v, err := strconv.Atoi(os.Getenv("CONFIG_REVISIONS_KEEP")); err == nil && v > 0 {
+		return v
+	}
+	return 200
+}
+
+// recordConfigRevision сохраняет отрендеренный конфиг в историю.
+// Если последняя ревизия этой ноды такая же (и с тем же результатом), новая не создаётся.
+func recordConfigRevision(kind string, nodeID uint, content []byte, trigger string, applyErr error) {
+	hash := configHash(content)
+	errText := ""
+	if applyErr != nil {
+		errText = applyErr.Error()
+	}
+
+	var last database.ConfigRevision
+	err := database.DB.Select("id", "hash", "error").
+		Where("kind = ? AND node_id = ?", kind, nodeID).
+		Order("id desc").First(&last).Error
+	if err == nil && last.Hash == hash && last.Error == errText {
+		return
+	}
+
+	rev := database.ConfigRevision{
+		Kind:    kind,
+		NodeID:  nodeID,
+		Hash:    hash,
+		Trigger: trigger,
+		Error:   errText,
+		Content: string(content),
+	}
+	if err := database.DB.Create(&rev).Error; err != nil {
+		log.Println("Failed to save config revision:", err)
+		return
+	}
+
+	var stale []uint
+	database.DB.Model(&database.ConfigRevision{}).
+		Where("kind = ? AND node_id = ?", kind, nodeID).
+		Order("id desc").Offset(configRevisionKeep()).Limit(-1).
+		Pluck("id", &stale)
+	if len(stale) > 0 {
+		database.DB.Delete(&database.ConfigRevision{}, stale)
+	}
+}
+
+// appliedRestored — хэш-заглушка после восстановления ревизии: не совпадёт ни с одним конфигом,
+// поэтому следующая перегенерация из БД выложит конфиг заново
+const appliedRestored = "restored"
+
+// RestoreConfigRevision заново выкладывает сохранённый конфиг на его ноду через координатор перезагрузок.
+// Восстановление временное: следующая перегенерация из БД перезапишет его.
+func RestoreConfigRevision(rev database.ConfigRevision, trigger string) error {
+	node, err := NodeClientByID(rev.NodeID)
+	if err != nil {
+		return err
+	}
+	if rev.Kind != ConfigKindSingbox && rev.Kind != ConfigKindTelemt {
+		return fmt.Errorf("unknown config kind %q", rev.Kind)
+	}
+
+	data := []byte(rev.Content)
+	return runInReloadCoordinator(func(applied map[string]string) error {
+		var err error
+		if rev.Kind == ConfigKindSingbox {
+			err = node.ApplySingboxConfig(data)
+		} else {
+			err = node.ApplyTelemetConfig(data)
+		}
+		recordConfigRevision(rev.Kind, rev.NodeID, data, trigger, err)
+		applied[appliedKey(rev.Kind, rev.NodeID)] = appliedRestored
+		return err
+	})
+}
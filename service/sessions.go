@@ -0,0 +1,109 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+	"vpnbot/database"
+)
+
+// Время жизни токенов админки. Access-токен короткий, продлевается через refresh
+const (
+	AdminAccessTokenTTL  = 15 * time.Minute
+	AdminRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found or expired")
+	ErrRefreshReused   = errors.New("refresh token reuse detected, session revoked")
+)
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAdminSession заводит сессию и возвращает её вместе с refresh-токеном в открытом виде
+func CreateAdminSession(accountID uint, ip, userAgent string) (database.AdminSession, string, error) {
+	// Заодно чистим давно истёкшие сессии
+	database.DB.Where("expires_at < ?", time.Now().Add(-AdminRefreshTokenTTL)).Delete(&database.AdminSession{})
+
+	refresh := randomToken(32)
+	session := database.AdminSession{
+		AccountID:   accountID,
+		RefreshHash: hashToken(refresh),
+		AccessJTI:   randomToken(16),
+		ExpiresAt:   time.Now().Add(AdminRefreshTokenTTL),
+		IP:          ip,
+		UserAgent:   userAgent,
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		return session, "", err
+	}
+	return session, refresh, nil
+}
+
+// RotateAdminSession меняет refresh-токен и jti. Старый access-токен сразу перестаёт работать.
+// Повторное предъявление уже заменённого refresh-токена отзывает всю сессию.
+func RotateAdminSession(refresh string) (database.AdminSession, string, error) {
+	hash := hashToken(refresh)
+	now := time.Now()
+
+	var session database.AdminSession
+	if err := database.DB.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+		if database.DB.Where("prev_refresh_hash = ? AND revoked_at IS NULL", hash).First(&session).Error == nil {
+			RevokeAdminSession(session.ID)
+			log.Printf("Admin session %d revoked: refresh token reused", session.ID)
+			return session, "", ErrRefreshReused
+		}
+		return session, "", ErrSessionNotFound
+	}
+	if session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+		return session, "", ErrSessionNotFound
+	}
+
+	newRefresh := randomToken(32)
+	session.PrevRefreshHash = session.RefreshHash
+	session.RefreshHash = hashToken(newRefresh)
+	session.AccessJTI = randomToken(16)
+	session.ExpiresAt = now.Add(AdminRefreshTokenTTL)
+	if err := database.DB.Save(&session).Error; err != nil {
+		return session, "", err
+	}
+	return session, newRefresh, nil
+}
+
+// ActiveAdminSession находит неотозванную сессию по jti access-токена
+func ActiveAdminSession(jti string) (database.AdminSession, error) {
+	var session database.AdminSession
+	err := database.DB.Where("access_jti = ? AND revoked_at IS NULL AND expires_at > ?", jti, time.Now()).First(&session).Error
+	return session, err
+}
+
+// RevokeAdminSession отзывает одну сессию
+func RevokeAdminSession(id uint) {
+	database.DB.Model(&database.AdminSession{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+}
+
+// RevokeAdminSessions отзывает все сессии аккаунта
+func RevokeAdminSessions(accountID uint) {
+	database.DB.Model(&database.AdminSession{}).Where("account_id = ? AND revoked_at IS NULL", accountID).Update("revoked_at", time.Now())
+}
+
+// RevokeOtherAdminSessions отзывает все сессии аккаунта, кроме keepID (например, после смены пароля)
+func RevokeOtherAdminSessions(accountID, keepID uint) {
+	database.DB.Model(&database.AdminSession{}).
+		Where("account_id = ? AND id != ? AND revoked_at IS NULL", accountID, keepID).
+		Update("revoked_at", time.Now())
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigBackupDir — каталог с последними рабочими конфигами sing-box
+const ConfigBackupDir = "/etc/sing-box/backups"
+
+// SingboxActiveCheckDelay — сколько ждать после reload, прежде чем проверять, что sing-box жив
+const SingboxActiveCheckDelay = 3 * time.Second
+
+// ConfigCheckError — sing-box отверг новый конфиг: он не прошёл `sing-box check`
+// или сервис не поднялся после reload и был откачен. На ноде остался прежний конфиг.
+type ConfigCheckError struct {
+	Output     string `json:"output"`
+	RolledBack bool   `json:"rolled_back"`
+}
+
+func (e *ConfigCheckError) Error() string {
+	if e.RolledBack {
+		return "sing-box failed after reload, previous config restored: " + e.Output
+	}
+	return "sing-box config check failed: " + e.Output
+}
+
+// configBackupKeep — сколько рабочих конфигов хранить, SINGBOX_CONFIG_BACKUPS (по умолчанию 5)
+func configBackupKeep() int {
+	if v, err := strconv.Atoi(os.Getenv("SINGBOX_CONFIG_BACKUPS")); err == nil && v > 0 {
+		return v
+	}
+	return 5
+}
+
+// InstallSingboxConfig применяет конфиг sing-box на текущей машине:
+// пишет во временный файл, проверяет через `sing-box check`, атомарно подменяет ConfigPath
+// и делает reload. Если reload не прошёл или сервис не активен через SingboxActiveCheckDelay,
+// возвращается прежний конфиг. Успешно применённый конфиг сохраняется в ConfigBackupDir.
+func InstallSingboxConfig(data []byte) error {
+	if _, err := exec.LookPath("sing-box"); err != nil {
+		// sing-box не установлен (dev-окружение) — проверять нечем, пишем как раньше
+		if err := os.WriteFile(ConfigPath, data, 0644); err != nil {
+			log.Println("Error writing config file:", err)
+			fmt.Println(string(data))
+			return nil
+		}
+		return ReloadService()
+	}
+
+	tmp := ConfigPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("не удалось записать конфиг: %w", err)
+	}
+
+	if output, err := exec.Command("sing-box", "check", "-c", tmp).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		checkErr := &ConfigCheckError{Output: strings.TrimSpace(string(output))}
+		log.Println(checkErr)
+		return checkErr
+	}
+
+	previous, prevErr := os.ReadFile(ConfigPath)
+	if err := os.Rename(tmp, ConfigPath); err != nil {
+		return fmt.Errorf("не удалось заменить конфиг: %w", err)
+	}
+
+	failure := ""
+	if err := ReloadService(); err != nil {
+		failure = "reload failed: " + err.Error()
+	} else {
+		time.Sleep(SingboxActiveCheckDelay)
+		if exec.Command("systemctl", "is-active", "--quiet", "sing-box").Run() != nil {
+			failure = "sing-box is not active after reload"
+		}
+	}
+
+	if failure == "" {
+		saveConfigBackup(data)
+		return nil
+	}
+
+	if prevErr != nil {
+		return fmt.Errorf("%s, no previous config to roll back to", failure)
+	}
+	if err := os.WriteFile(ConfigPath, previous, 0644); err != nil {
+		return fmt.Errorf("%s, rollback failed: %w", failure, err)
+	}
+	if err := ReloadService(); err != nil {
+		exec.Command("systemctl", "restart", "sing-box").Run()
+	}
+	log.Println("Sing-box config rolled back:", failure)
+	return &ConfigCheckError{Output: failure, RolledBack: true}
+}
+
+// saveConfigBackup сохраняет рабочий конфиг и оставляет только последние configBackupKeep()
+func saveConfigBackup(data []byte) {
+	if err := os.MkdirAll(ConfigBackupDir, 0755); err != nil {
+		log.Println("Failed to create config backup dir:", err)
+		return
+	}
+
+	backups := listConfigBackups()
+	if len(backups) > 0 {
+		if last, err := os.ReadFile(backups[len(backups)-1]); err == nil && bytes.Equal(last, data) {
+			return
+		}
+	}
+
+	name := filepath.Join(ConfigBackupDir, "config-"+time.Now().Format("20060102-150405")+".json")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		log.Println("Failed to save config backup:", err)
+		return
+	}
+
+	backups = append(backups, name)
+	for len(backups) > configBackupKeep() {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// listConfigBackups возвращает файлы бэкапов от старых к новым
+func listConfigBackups() []string {
+	files, _ := filepath.Glob(filepath.Join(ConfigBackupDir, "config-*.json"))
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,487 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"vpnbot/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatBase64  = "base64"
+	FormatRaw     = "raw"
+	FormatSingbox = "singbox"
+	FormatClash   = "clash"
+)
+
+const urlTestURL = "https://www.gstatic.com/generate_204"
+
+// DetectSubscriptionFormat выбирает формат подписки: явный ?format= важнее User-Agent.
+// Неизвестные клиенты (Hiddify, V2Box, Streisand) получают base64-список ссылок.
+func DetectSubscriptionFormat(userAgent, format string) string {
+	switch strings.ToLower(format) {
+	case "singbox", "sing-box", "json":
+		return FormatSingbox
+	case "clash", "mihomo", "yaml":
+		return FormatClash
+	case "raw", "links":
+		return FormatRaw
+	case "base64":
+		return FormatBase64
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "sing-box"), strings.HasPrefix(ua, "sfa"), strings.HasPrefix(ua, "sfi"), strings.HasPrefix(ua, "sfm"):
+		return FormatSingbox
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return FormatClash
+	}
+	return FormatBase64
+}
+
+// RenderSubscription строит тело подписки в нужном формате и возвращает его вместе с Content-Type
+func RenderSubscription(format string, inbounds []database.InboundConfig, user database.User, serverAddr string) ([]byte, string, error) {
+	switch format {
+	case FormatSingbox:
+		body, err := BuildSingboxClientConfig(inbounds, user, serverAddr)
+		return body, "application/json; charset=utf-8", err
+	case FormatClash:
+		body, err := BuildClashConfig(inbounds, user, serverAddr)
+		return body, "text/yaml; charset=utf-8", err
+	}
+
+	links := []string{}
+	for _, ib := range inbounds {
+		links = append(links, GenerateLinkForInbound(ib, user, serverAddr))
+	}
+	body := strings.Join(links, "\n")
+	if format == FormatRaw {
+		return []byte(body), "text/plain; charset=utf-8", nil
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(body))), "text/plain", nil
+}
+
+// clientTLSInsecure — клиент не проверяет сертификат, если для certificate-инбаунда не задан SNI
+// (самоподписанный сертификат на IP, как у встроенного hy2-in)
+func clientTLSInsecure(ib database.InboundConfig) bool {
+	return ib.TLSType == "certificate" && ib.SNI == ""
+}
+
+func inboundFingerprint(ib database.InboundConfig) string {
+	if ib.Fingerprint == "" {
+		return "random"
+	}
+	return ib.Fingerprint
+}
+
+// clientProxyNames даёт каждому инбаунду уникальное имя для клиентских конфигов
+func clientProxyNames(inbounds []database.InboundConfig) []string {
+	names := make([]string, 0, len(inbounds))
+	seen := map[string]bool{}
+	for _, ib := range inbounds {
+		name := ib.DisplayName
+		if name == "" || seen[name] {
+			name = ib.Tag
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// subscriptionDirectDomains — домены, которые клиент пускает мимо VPN (SUB_DIRECT_DOMAINS через запятую)
+func subscriptionDirectDomains() []string {
+	domains := []string{}
+	for _, d := range strings.Split(os.Getenv("SUB_DIRECT_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// --- sing-box client config ---
+
+type SingboxClientConfig struct {
+	Log       LogConfig               `json:"log"`
+	DNS       SingboxClientDNS        `json:"dns"`
+	Inbounds  []SingboxClientInbound  `json:"inbounds"`
+	Outbounds []SingboxClientOutbound `json:"outbounds"`
+	Route     SingboxClientRoute      `json:"route"`
+}
+
+type SingboxClientDNS struct {
+	Servers []SingboxDNSServer `json:"servers"`
+	Rules   []SingboxRouteRule `json:"rules,omitempty"`
+	Final   string             `json:"final,omitempty"`
+}
+
+type SingboxDNSServer struct {
+	Tag     string `json:"tag"`
+	Address string `json:"address"`
+	Detour  string `json:"detour,omitempty"`
+}
+
+type SingboxClientInbound struct {
+	Type        string   `json:"type"`
+	Tag         string   `json:"tag"`
+	Listen      string   `json:"listen,omitempty"`
+	ListenPort  int      `json:"listen_port,omitempty"`
+	Address     []string `json:"address,omitempty"`
+	AutoRoute   bool     `json:"auto_route,omitempty"`
+	StrictRoute bool     `json:"strict_route,omitempty"`
+	Sniff       bool     `json:"sniff,omitempty"`
+}
+
+type SingboxClientOutbound struct {
+	Type       string                 `json:"type"`
+	Tag        string                 `json:"tag"`
+	Server     string                 `json:"server,omitempty"`
+	ServerPort int                    `json:"server_port,omitempty"`
+	UUID       string                 `json:"uuid,omitempty"`
+	Flow       string                 `json:"flow,omitempty"`
+	Password   string                 `json:"password,omitempty"`
+	TLS        *ClientTLSConfig       `json:"tls,omitempty"`
+	Transport  *TransportConfig       `json:"transport,omitempty"`
+	Multiplex  *ClientMultiplexConfig `json:"multiplex,omitempty"`
+	Outbounds  []string               `json:"outbounds,omitempty"` // selector / urltest
+	Default    string                 `json:"default,omitempty"`
+	URL        string                 `json:"url,omitempty"`
+	Interval   string                 `json:"interval,omitempty"`
+}
+
+type ClientTLSConfig struct {
+	Enabled    bool                 `json:"enabled"`
+	ServerName string               `json:"server_name,omitempty"`
+	Insecure   bool                 `json:"insecure,omitempty"`
+	UTLS       *UTLSConfig          `json:"utls,omitempty"`
+	Reality    *ClientRealityConfig `json:"reality,omitempty"`
+}
+
+type UTLSConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type ClientRealityConfig struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type ClientMultiplexConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+type SingboxClientRoute struct {
+	Rules               []SingboxRouteRule `json:"rules"`
+	Final               string             `json:"final"`
+	AutoDetectInterface bool               `json:"auto_detect_interface"`
+}
+
+type SingboxRouteRule struct {
+	Protocol     string   `json:"protocol,omitempty"`
+	IPIsPrivate  bool     `json:"ip_is_private,omitempty"`
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	Outbound     string   `json:"outbound,omitempty"`
+	Server       string   `json:"server,omitempty"`
+}
+
+// buildSingboxOutbound — клиентская пара к buildSingboxInbound
+func buildSingboxOutbound(ib database.InboundConfig, user database.User, serverAddr, tag string) SingboxClientOutbound {
+	ob := SingboxClientOutbound{
+		Type:       ib.Protocol,
+		Tag:        tag,
+		Server:     inboundServerAddress(ib, serverAddr),
+		ServerPort: ib.ListenPort,
+	}
+
+	switch ib.UserType {
+	case "hy2":
+		ob.Password = user.UUID
+	default:
+		ob.UUID = user.UUID
+		ob.Flow = ib.Flow
+	}
+
+	// TLS
+	switch ib.TLSType {
+	case "reality":
+		shortID := ""
+		if len(ib.RealityShortIDs) > 0 {
+			shortID = ib.RealityShortIDs[0]
+		}
+		ob.TLS = &ClientTLSConfig{
+			Enabled:    true,
+			ServerName: ib.SNI,
+			UTLS:       &UTLSConfig{Enabled: true, Fingerprint: inboundFingerprint(ib)},
+			Reality: &ClientRealityConfig{
+				Enabled:   true,
+				PublicKey: ib.RealityPublicKey,
+				ShortID:   shortID,
+			},
+		}
+	case "certificate":
+		ob.TLS = &ClientTLSConfig{
+			Enabled:    true,
+			ServerName: ib.SNI,
+			Insecure:   clientTLSInsecure(ib),
+		}
+	}
+
+	// Transport — те же поля, что и на сервере
+	if ib.Transport != "" {
+		ob.Transport = &TransportConfig{Type: ib.Transport}
+		switch ib.Transport {
+		case "grpc":
+			ob.Transport.ServiceName = ib.ServiceName
+		case "httpupgrade", "ws", "xhttp":
+			ob.Transport.Path = ib.ServiceName
+			if ib.Transport == "xhttp" {
+				ob.Transport.Mode = "auto"
+			}
+		}
+	}
+
+	if ib.Multiplex {
+		ob.Multiplex = &ClientMultiplexConfig{Enabled: true}
+	}
+
+	return ob
+}
+
+// BuildSingboxClientConfig строит полный клиентский конфиг sing-box:
+// TUN + mixed inbound, селектор с url-test по всем инбаундам и базовые правила маршрутизации.
+func BuildSingboxClientConfig(inbounds []database.InboundConfig, user database.User, serverAddr string) ([]byte, error) {
+	// urltest без outbounds sing-box не примет
+	if len(inbounds) == 0 {
+		return nil, fmt.Errorf("no inbounds available")
+	}
+	names := clientProxyNames(inbounds)
+
+	proxies := []SingboxClientOutbound{}
+	for i, ib := range inbounds {
+		proxies = append(proxies, buildSingboxOutbound(ib, user, serverAddr, names[i]))
+	}
+
+	outbounds := []SingboxClientOutbound{
+		{Type: "selector", Tag: "proxy", Outbounds: append([]string{"auto"}, names...), Default: "auto"},
+		{Type: "urltest", Tag: "auto", Outbounds: names, URL: urlTestURL, Interval: "3m"},
+	}
+	outbounds = append(outbounds, proxies...)
+	outbounds = append(outbounds,
+		SingboxClientOutbound{Type: "direct", Tag: "direct"},
+		SingboxClientOutbound{Type: "block", Tag: "block"},
+		SingboxClientOutbound{Type: "dns", Tag: "dns-out"},
+	)
+
+	rules := []SingboxRouteRule{
+		{Protocol: "dns", Outbound: "dns-out"},
+		{IPIsPrivate: true, Outbound: "direct"},
+	}
+	if domains := subscriptionDirectDomains(); len(domains) > 0 {
+		rules = append(rules, SingboxRouteRule{DomainSuffix: domains, Outbound: "direct"})
+	}
+
+	cfg := SingboxClientConfig{
+		Log: LogConfig{Level: "warn", Timestamp: true},
+		DNS: SingboxClientDNS{
+			Servers: []SingboxDNSServer{
+				{Tag: "remote", Address: "https://1.1.1.1/dns-query", Detour: "proxy"},
+				{Tag: "local", Address: "local", Detour: "direct"},
+			},
+			Rules: []SingboxRouteRule{{Outbound: "any", Server: "local"}},
+			Final: "remote",
+		},
+		Inbounds: []SingboxClientInbound{
+			{Type: "tun", Tag: "tun-in", Address: []string{"172.19.0.1/30"}, AutoRoute: true, StrictRoute: true, Sniff: true},
+			{Type: "mixed", Tag: "mixed-in", Listen: "127.0.0.1", ListenPort: 2080, Sniff: true},
+		},
+		Outbounds: outbounds,
+		Route: SingboxClientRoute{
+			Rules:               rules,
+			Final:               "proxy",
+			AutoDetectInterface: true,
+		},
+	}
+
+	return json.MarshalIndent(cfg, "", "  ")
+}
+
+// --- Clash.Meta / Mihomo ---
+
+type ClashConfig struct {
+	MixedPort   int               `yaml:"mixed-port"`
+	AllowLan    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	LogLevel    string            `yaml:"log-level"`
+	Proxies     []ClashProxy      `yaml:"proxies"`
+	ProxyGroups []ClashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+type ClashProxy struct {
+	Name              string             `yaml:"name"`
+	Type              string             `yaml:"type"`
+	Server            string             `yaml:"server"`
+	Port              int                `yaml:"port"`
+	UUID              string             `yaml:"uuid,omitempty"`
+	Password          string             `yaml:"password,omitempty"`
+	Network           string             `yaml:"network,omitempty"`
+	UDP               bool               `yaml:"udp"`
+	TLS               bool               `yaml:"tls,omitempty"`
+	Flow              string             `yaml:"flow,omitempty"`
+	ServerName        string             `yaml:"servername,omitempty"`
+	SNI               string             `yaml:"sni,omitempty"`
+	SkipCertVerify    bool               `yaml:"skip-cert-verify,omitempty"`
+	ClientFingerprint string             `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *ClashRealityOpts  `yaml:"reality-opts,omitempty"`
+	GrpcOpts          *ClashGrpcOpts     `yaml:"grpc-opts,omitempty"`
+	WsOpts            *ClashWsOpts       `yaml:"ws-opts,omitempty"`
+	H2Opts            *ClashH2Opts       `yaml:"h2-opts,omitempty"`
+	Smux              *ClashSmuxSettings `yaml:"smux,omitempty"`
+}
+
+type ClashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type ClashGrpcOpts struct {
+	GrpcServiceName string `yaml:"grpc-service-name"`
+}
+
+type ClashWsOpts struct {
+	Path             string `yaml:"path,omitempty"`
+	V2rayHTTPUpgrade bool   `yaml:"v2ray-http-upgrade,omitempty"`
+}
+
+type ClashH2Opts struct {
+	Path string `yaml:"path,omitempty"`
+}
+
+type ClashSmuxSettings struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+type ClashProxyGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// buildClashProxy переводит инбаунд в прокси Mihomo. ok=false, если Mihomo не поддерживает транспорт.
+func buildClashProxy(ib database.InboundConfig, user database.User, serverAddr, name string) (ClashProxy, bool) {
+	p := ClashProxy{
+		Name:   name,
+		Type:   ib.Protocol,
+		Server: inboundServerAddress(ib, serverAddr),
+		Port:   ib.ListenPort,
+		UDP:    true,
+	}
+
+	if ib.Protocol == "hysteria2" {
+		p.Password = user.UUID
+		p.SNI = ib.SNI
+		p.SkipCertVerify = clientTLSInsecure(ib)
+		return p, true
+	}
+
+	p.UUID = user.UUID
+	p.Flow = ib.Flow
+
+	switch ib.TLSType {
+	case "reality":
+		p.TLS = true
+		p.ServerName = ib.SNI
+		p.ClientFingerprint = inboundFingerprint(ib)
+		shortID := ""
+		if len(ib.RealityShortIDs) > 0 {
+			shortID = ib.RealityShortIDs[0]
+		}
+		p.RealityOpts = &ClashRealityOpts{PublicKey: ib.RealityPublicKey, ShortID: shortID}
+	case "certificate":
+		p.TLS = true
+		p.ServerName = ib.SNI
+		p.SkipCertVerify = clientTLSInsecure(ib)
+		p.ClientFingerprint = inboundFingerprint(ib)
+	}
+
+	switch ib.Transport {
+	case "":
+		p.Network = "tcp"
+	case "http":
+		p.Network = "h2"
+		p.H2Opts = &ClashH2Opts{Path: "/"}
+	case "grpc":
+		p.Network = "grpc"
+		p.GrpcOpts = &ClashGrpcOpts{GrpcServiceName: ib.ServiceName}
+	case "ws":
+		p.Network = "ws"
+		p.WsOpts = &ClashWsOpts{Path: ib.ServiceName}
+	case "httpupgrade":
+		p.Network = "ws"
+		p.WsOpts = &ClashWsOpts{Path: ib.ServiceName, V2rayHTTPUpgrade: true}
+	default:
+		// xhttp и прочие транспорты sing-box-extended Mihomo не понимает
+		return p, false
+	}
+
+	if ib.Multiplex {
+		p.Smux = &ClashSmuxSettings{Enabled: true}
+	}
+
+	return p, true
+}
+
+// BuildClashConfig строит профиль Clash.Meta/Mihomo с группами select / url-test / fallback по всем инбаундам
+func BuildClashConfig(inbounds []database.InboundConfig, user database.User, serverAddr string) ([]byte, error) {
+	names := clientProxyNames(inbounds)
+
+	proxies := []ClashProxy{}
+	proxyNames := []string{}
+	for i, ib := range inbounds {
+		p, ok := buildClashProxy(ib, user, serverAddr, names[i])
+		if !ok {
+			continue
+		}
+		proxies = append(proxies, p)
+		proxyNames = append(proxyNames, p.Name)
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no inbounds compatible with Clash")
+	}
+
+	rules := []string{
+		"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+		"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+		"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+		"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	}
+	for _, d := range subscriptionDirectDomains() {
+		rules = append(rules, "DOMAIN-SUFFIX,"+strings.TrimPrefix(d, ".")+",DIRECT")
+	}
+	rules = append(rules, "MATCH,Proxy")
+
+	cfg := ClashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "warning",
+		Proxies:   proxies,
+		ProxyGroups: []ClashProxyGroup{
+			{Name: "Proxy", Type: "select", Proxies: append([]string{"Auto", "Fallback"}, proxyNames...)},
+			{Name: "Auto", Type: "url-test", Proxies: proxyNames, URL: urlTestURL, Interval: 300},
+			{Name: "Fallback", Type: "fallback", Proxies: proxyNames, URL: urlTestURL, Interval: 300},
+		},
+		Rules: rules,
+	}
+
+	return yaml.Marshal(cfg)
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"vpnbot/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testServerAddr = "1.2.3.4"
+
+var testSubUser = database.User{Username: "alice", UUID: "11111111-2222-3333-4444-555555555555"}
+
+// testSubInbounds — reality, hysteria2 с самоподписанным сертификатом и xhttp, которого нет в Mihomo
+func testSubInbounds() []database.InboundConfig {
+	return []database.InboundConfig{
+		{
+			Tag: "vless-in", DisplayName: "Reality", Protocol: "vless", UserType: "vless", ListenPort: 443,
+			TLSType: "reality", SNI: "www.example.com", Flow: "xtls-rprx-vision",
+			RealityPublicKey: "pubkey", RealityShortIDs: database.JSONStringArray{"ab12"},
+		},
+		{
+			// Имя совпадает с первым — в клиентских конфигах берётся тег
+			Tag: "hy2-in", DisplayName: "Reality", Protocol: "hysteria2", UserType: "hy2", ListenPort: 8443,
+			TLSType: "certificate",
+		},
+		{
+			Tag: "xhttp-in", DisplayName: "XHTTP", Protocol: "vless", UserType: "vless", ListenPort: 2053,
+			TLSType: "certificate", SNI: "cdn.example.com", Transport: "xhttp", ServiceName: "/x",
+			ServerAddress: "cdn.example.com",
+		},
+	}
+}
+
+func TestDetectSubscriptionFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		format    string
+		want      string
+	}{
+		{"unknown client", "Hiddify/2.0", "", FormatBase64},
+		{"no user agent", "", "", FormatBase64},
+		{"sing-box", "sing-box 1.8.0", "", FormatSingbox},
+		{"SFA", "SFA/1.8.0 (Android)", "", FormatSingbox},
+		{"SFI", "SFI/1.8.0", "", FormatSingbox},
+		{"SFM", "SFM/1.8.0", "", FormatSingbox},
+		{"clash", "ClashMetaForAndroid/2.8", "", FormatClash},
+		{"mihomo", "mihomo/1.18", "", FormatClash},
+		{"stash", "Stash/2.4", "", FormatClash},
+		{"explicit json", "Hiddify/2.0", "json", FormatSingbox},
+		{"explicit sing-box", "", "Sing-Box", FormatSingbox},
+		{"explicit yaml", "", "yaml", FormatClash},
+		{"explicit links", "", "links", FormatRaw},
+		{"explicit raw", "", "raw", FormatRaw},
+		{"format beats user agent", "sing-box 1.8.0", "base64", FormatBase64},
+		{"unknown format falls back to user agent", "mihomo/1.18", "xml", FormatClash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectSubscriptionFormat(tt.userAgent, tt.format); got != tt.want {
+				t.Errorf("DetectSubscriptionFormat(%q, %q) = %q, want %q", tt.userAgent, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSubscriptionLinks(t *testing.T) {
+	t.Setenv("SUB_DIRECT_DOMAINS", "")
+	inbounds := testSubInbounds()
+
+	raw, contentType, err := RenderSubscription(FormatRaw, inbounds, testSubUser, testServerAddr)
+	if err != nil || contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("raw: %q, %v", contentType, err)
+	}
+	links := strings.Split(string(raw), "\n")
+	if len(links) != len(inbounds) {
+		t.Fatalf("raw: %d links, want %d:\n%s", len(links), len(inbounds), raw)
+	}
+	for i, prefix := range []string{
+		"vless://" + testSubUser.UUID + "@1.2.3.4:443?",
+		"hysteria2://" + testSubUser.UUID + "@1.2.3.4:8443?",
+		"vless://" + testSubUser.UUID + "@cdn.example.com:2053?",
+	} {
+		if !strings.HasPrefix(links[i], prefix) {
+			t.Errorf("link %d = %q, want prefix %q", i, links[i], prefix)
+		}
+	}
+
+	encoded, _, err := RenderSubscription(FormatBase64, inbounds, testSubUser, testServerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || string(decoded) != string(raw) {
+		t.Errorf("base64 body does not decode to the raw links: %v", err)
+	}
+}
+
+func TestBuildSingboxClientConfig(t *testing.T) {
+	t.Setenv("SUB_DIRECT_DOMAINS", "example.ru, .local")
+
+	body, contentType, err := RenderSubscription(FormatSingbox, testSubInbounds(), testSubUser, testServerAddr)
+	if err != nil || contentType != "application/json; charset=utf-8" {
+		t.Fatalf("RenderSubscription: %q, %v", contentType, err)
+	}
+	var cfg SingboxClientConfig
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var tags []string
+	byTag := map[string]SingboxClientOutbound{}
+	for _, ob := range cfg.Outbounds {
+		tags = append(tags, ob.Tag)
+		byTag[ob.Tag] = ob
+	}
+	wantTags := []string{"proxy", "auto", "Reality", "hy2-in", "XHTTP", "direct", "block", "dns-out"}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Fatalf("outbound tags = %q, want %q", tags, wantTags)
+	}
+
+	names := []string{"Reality", "hy2-in", "XHTTP"}
+	if got := byTag["proxy"]; got.Type != "selector" || got.Default != "auto" || !reflect.DeepEqual(got.Outbounds, append([]string{"auto"}, names...)) {
+		t.Errorf("selector = %+v", got)
+	}
+	if got := byTag["auto"]; got.Type != "urltest" || got.URL != urlTestURL || !reflect.DeepEqual(got.Outbounds, names) {
+		t.Errorf("urltest = %+v", got)
+	}
+
+	reality := byTag["Reality"]
+	if reality.Type != "vless" || reality.Server != testServerAddr || reality.ServerPort != 443 ||
+		reality.UUID != testSubUser.UUID || reality.Flow != "xtls-rprx-vision" {
+		t.Errorf("reality outbound = %+v", reality)
+	}
+	if reality.TLS == nil || reality.TLS.Reality == nil || reality.TLS.Reality.PublicKey != "pubkey" ||
+		reality.TLS.Reality.ShortID != "ab12" || reality.TLS.ServerName != "www.example.com" {
+		t.Errorf("reality tls = %+v", reality.TLS)
+	}
+
+	hy2 := byTag["hy2-in"]
+	if hy2.Type != "hysteria2" || hy2.Password != testSubUser.UUID || hy2.UUID != "" {
+		t.Errorf("hysteria2 outbound = %+v", hy2)
+	}
+	if hy2.TLS == nil || !hy2.TLS.Insecure {
+		t.Errorf("hysteria2 with a self-signed certificate must skip verification: %+v", hy2.TLS)
+	}
+
+	xhttp := byTag["XHTTP"]
+	if xhttp.Server != "cdn.example.com" || xhttp.Transport == nil ||
+		xhttp.Transport.Type != "xhttp" || xhttp.Transport.Path != "/x" || xhttp.Transport.Mode != "auto" {
+		t.Errorf("xhttp outbound = %+v, transport %+v", xhttp, xhttp.Transport)
+	}
+
+	if cfg.Route.Final != "proxy" || len(cfg.Route.Rules) != 3 ||
+		!reflect.DeepEqual(cfg.Route.Rules[2].DomainSuffix, []string{"example.ru", ".local"}) {
+		t.Errorf("route = %+v", cfg.Route)
+	}
+}
+
+func TestBuildClashConfig(t *testing.T) {
+	t.Setenv("SUB_DIRECT_DOMAINS", ".example.ru")
+
+	body, contentType, err := RenderSubscription(FormatClash, testSubInbounds(), testSubUser, testServerAddr)
+	if err != nil || contentType != "text/yaml; charset=utf-8" {
+		t.Fatalf("RenderSubscription: %q, %v", contentType, err)
+	}
+	var cfg ClashConfig
+	if err := yaml.Unmarshal(body, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// xhttp Mihomo не поддерживает — его в профиле нет
+	if len(cfg.Proxies) != 2 {
+		t.Fatalf("got %d proxies, want 2:\n%s", len(cfg.Proxies), body)
+	}
+	reality, hy2 := cfg.Proxies[0], cfg.Proxies[1]
+	if reality.Name != "Reality" || reality.Type != "vless" || reality.Server != testServerAddr || reality.Port != 443 ||
+		reality.UUID != testSubUser.UUID || reality.Network != "tcp" || !reality.TLS ||
+		reality.RealityOpts == nil || reality.RealityOpts.PublicKey != "pubkey" || reality.RealityOpts.ShortID != "ab12" {
+		t.Errorf("reality proxy = %+v", reality)
+	}
+	if hy2.Name != "hy2-in" || hy2.Type != "hysteria2" || hy2.Password != testSubUser.UUID || !hy2.SkipCertVerify {
+		t.Errorf("hysteria2 proxy = %+v", hy2)
+	}
+
+	wantGroups := []ClashProxyGroup{
+		{Name: "Proxy", Type: "select", Proxies: []string{"Auto", "Fallback", "Reality", "hy2-in"}},
+		{Name: "Auto", Type: "url-test", Proxies: []string{"Reality", "hy2-in"}, URL: urlTestURL, Interval: 300},
+		{Name: "Fallback", Type: "fallback", Proxies: []string{"Reality", "hy2-in"}, URL: urlTestURL, Interval: 300},
+	}
+	if !reflect.DeepEqual(cfg.ProxyGroups, wantGroups) {
+		t.Errorf("proxy groups = %+v", cfg.ProxyGroups)
+	}
+
+	if n := len(cfg.Rules); n < 2 || cfg.Rules[n-2] != "DOMAIN-SUFFIX,example.ru,DIRECT" || cfg.Rules[n-1] != "MATCH,Proxy" {
+		t.Errorf("rules = %q", cfg.Rules)
+	}
+}
+
+func TestClientConfigsWithoutInbounds(t *testing.T) {
+	onlyXHTTP := testSubInbounds()[2:]
+
+	tests := []struct {
+		name     string
+		format   string
+		inbounds []database.InboundConfig
+	}{
+		{"sing-box without inbounds", FormatSingbox, nil},
+		{"clash without inbounds", FormatClash, nil},
+		{"clash without compatible inbounds", FormatClash, onlyXHTTP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if body, _, err := RenderSubscription(tt.format, tt.inbounds, testSubUser, testServerAddr); err == nil {
+				t.Errorf("expected an error, got config:\n%s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm"
+)
+
+// TelegramLoginMaxAge — сколько действительны данные Telegram Login Widget после auth_date
+const TelegramLoginMaxAge = 24 * time.Hour
+
+// PanelLoginTokenTTL — время жизни одноразовой ссылки входа из бота
+const PanelLoginTokenTTL = 5 * time.Minute
+
+var (
+	ErrTelegramAuthInvalid = errors.New("invalid telegram auth data")
+	ErrTelegramAuthExpired = errors.New("telegram auth data expired")
+	ErrPanelTokenInvalid   = errors.New("login link is invalid, expired or already used")
+)
+
+// VerifyTelegramLogin проверяет данные Telegram Login Widget и возвращает Telegram ID.
+// Подпись: hex(HMAC-SHA256(data_check_string, SHA256(bot_token))), где data_check_string —
+// отсортированные "key=value" без hash через \n.
+func VerifyTelegramLogin(botToken string, data map[string]string) (int64, error) {
+	if botToken == "" {
+		return 0, errors.New("BOT_TOKEN is not configured")
+	}
+
+	hash := data["hash"]
+	if hash == "" {
+		return 0, ErrTelegramAuthInvalid
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + data[k]
+	}
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hash))) {
+		return 0, ErrTelegramAuthInvalid
+	}
+
+	authDate, err := strconv.ParseInt(data["auth_date"], 10, 64)
+	if err != nil {
+		return 0, ErrTelegramAuthInvalid
+	}
+	if time.Since(time.Unix(authDate, 0)) > TelegramLoginMaxAge {
+		return 0, ErrTelegramAuthExpired
+	}
+
+	id, err := strconv.ParseInt(data["id"], 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrTelegramAuthInvalid
+	}
+	return id, nil
+}
+
+// AdminAccountByTelegramID находит включённый аккаунт админки, привязанный к Telegram ID
+func AdminAccountByTelegramID(telegramID int64) (database.AdminAccount, error) {
+	var account database.AdminAccount
+	if telegramID == 0 {
+		return account, gorm.ErrRecordNotFound
+	}
+	err := database.DB.Where("telegram_id = ? AND disabled = ?", telegramID, false).First(&account).Error
+	return account, err
+}
+
+// LinkBotAdminTelegramID привязывает ADMIN_ID бота к первому owner-аккаунту,
+// если этот Telegram ID ещё ни к кому не привязан
+func LinkBotAdminTelegramID(adminID int64) {
+	if adminID == 0 {
+		return
+	}
+
+	var count int64
+	database.DB.Model(&database.AdminAccount{}).Where("telegram_id = ?", adminID).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	var owner database.AdminAccount
+	if err := database.DB.Where("role = ? AND disabled = ? AND telegram_id = 0", RoleOwner, false).
+		Order("id").First(&owner).Error; err != nil {
+		return
+	}
+	database.DB.Model(&owner).Update("telegram_id", adminID)
+	log.Printf("Linked bot admin Telegram ID %d to admin account '%s'", adminID, owner.Username)
+}
+
+// CreatePanelLoginToken выпускает одноразовый токен входа в панель для аккаунта
+func CreatePanelLoginToken(accountID uint) (string, error) {
+	// Заодно чистим старые токены
+	database.DB.Where("expires_at < ?", time.Now().Add(-time.Hour)).Delete(&database.AdminLoginToken{})
+
+	token := randomToken(24)
+	row := database.AdminLoginToken{
+		AccountID: accountID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(PanelLoginTokenTTL),
+	}
+	if err := database.DB.Create(&row).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PanelLoginTokenAccount — аккаунт действующего токена без погашения (чтобы сначала спросить 2FA)
+func PanelLoginTokenAccount(token string) (database.AdminAccount, error) {
+	var account database.AdminAccount
+	var row database.AdminLoginToken
+	err := database.DB.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&row).Error
+	if err != nil {
+		return account, ErrPanelTokenInvalid
+	}
+	if err := database.DB.First(&account, row.AccountID).Error; err != nil || account.Disabled {
+		return account, ErrPanelTokenInvalid
+	}
+	return account, nil
+}
+
+// ConsumePanelLoginToken гасит токен и возвращает аккаунт. Второй раз токен не сработает.
+func ConsumePanelLoginToken(token string) (database.AdminAccount, error) {
+	var account database.AdminAccount
+	hash := hashToken(token)
+	now := time.Now()
+
+	res := database.DB.Model(&database.AdminLoginToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if res.Error != nil || res.RowsAffected != 1 {
+		return account, ErrPanelTokenInvalid
+	}
+
+	var row database.AdminLoginToken
+	if err := database.DB.Where("token_hash = ?", hash).First(&row).Error; err != nil {
+		return account, ErrPanelTokenInvalid
+	}
+	if err := database.DB.First(&account, row.AccountID).Error; err != nil || account.Disabled {
+		return account, ErrPanelTokenInvalid
+	}
+	return account, nil
+}
+
+// PanelLoginURL — ссылка на панель с одноразовым токеном.
+// База берётся из PANEL_URL, иначе из SERVER_DOMAIN / SERVER_IP.
+func PanelLoginURL(token string) string {
+	base := strings.TrimRight(os.Getenv("PANEL_URL"), "/")
+	if base == "" {
+		if domain := os.Getenv("SERVER_DOMAIN"); domain != "" {
+			base = "https://" + domain
+		} else {
+			ip := os.Getenv("SERVER_IP")
+			if ip == "" {
+				ip = "49.13.201.110"
+			}
+			base = fmt.Sprintf("https://%s:8085", ip)
+		}
+	}
+	return base + "/login?magic=" + token
+}
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 	"vpnbot/database"
 )
 
@@ -91,11 +92,30 @@ func InstallTelemt() error {
 	return nil
 }
 
-// GenerateTelemetConfig генерирует TOML-конфиг и записывает в /etc/telemt.toml
-func GenerateTelemetConfig(cfg database.TelemetConfig) error {
-	// Получаем всех telemetUser для этого конфига
+// GenerateTelemetConfig синхронизирует секреты, записывает TOML-конфиг в /etc/telemt.toml и сохраняет ревизию.
+// Запись идёт через координатор перезагрузок, чтобы не пересекаться с перегенерацией.
+func GenerateTelemetConfig(cfg database.TelemetConfig, trigger string) error {
+	return runInReloadCoordinator(func(applied map[string]string) error {
+		SyncTelemetUsers()
+		data := renderTelemetConfig(cfg)
+		err := writeTelemetConfig(data)
+		recordConfigRevision(ConfigKindTelemt, 0, data, trigger, err)
+		if err != nil {
+			delete(applied, ConfigKindTelemt)
+			return err
+		}
+		applied[ConfigKindTelemt] = configHash(data)
+		return nil
+	})
+}
+
+// renderTelemetConfig собирает TOML-конфиг telemt с секретами всех TelemetUser
+func renderTelemetConfig(cfg database.TelemetConfig) []byte {
+	// Получаем всех telemetUser для этого конфига. Приостановленные за лимит устройств в конфиг
+	// не попадают, но секрет сохраняют — после приостановки ссылка снова заработает.
 	var telemetUsers []database.TelemetUser
-	database.DB.Where("telemet_config_id = ?", cfg.ID).Find(&telemetUsers)
+	suspended := database.DB.Model(&database.User{}).Select("id").Where("suspended_until > ?", time.Now())
+	database.DB.Where("telemet_config_id = ? AND user_id NOT IN (?)", cfg.ID, suspended).Find(&telemetUsers)
 
 	port := cfg.Port
 	if port == 0 {
@@ -146,8 +166,13 @@ func GenerateTelemetConfig(cfg database.TelemetConfig) error {
 		sb.WriteString(fmt.Sprintf("%s = \"%s\"\n", tu.Label, tu.Secret))
 	}
 
+	return []byte(sb.String())
+}
+
+// writeTelemetConfig записывает готовый TOML-конфиг telemt
+func writeTelemetConfig(data []byte) error {
 	os.MkdirAll(TelemetConfigDir, 0755)
-	err := os.WriteFile(TelemetConfigPath, []byte(sb.String()), 0644)
+	err := os.WriteFile(TelemetConfigPath, data, 0644)
 	if err != nil {
 		log.Println("Ошибка записи конфига telemt:", err)
 		return err
@@ -254,9 +279,7 @@ func SetupTelemet() error {
 		return err
 	}
 
-	SyncTelemetUsers()
-
-	if err := GenerateTelemetConfig(cfg); err != nil {
+	if err := GenerateTelemetConfig(cfg, "telemt setup"); err != nil {
 		return err
 	}
 
@@ -267,25 +290,6 @@ func SetupTelemet() error {
 	return StartTelemet()
 }
 
-// GenerateAndReloadTelemet перегенерирует конфиг и перезапускает telemt
-func GenerateAndReloadTelemet() {
-	var cfg database.TelemetConfig
-	if err := database.DB.First(&cfg).Error; err != nil {
-		return
-	}
-
-	if !cfg.Enabled {
-		return
-	}
-
-	if err := GenerateTelemetConfig(cfg); err != nil {
-		log.Println("Ошибка генерации конфига telemt:", err)
-		return
-	}
-
-	ReloadTelemet()
-}
-
 // SyncTelemetUsers синхронизирует TelemetUser с активными юзерами
 func SyncTelemetUsers() {
 	var cfg database.TelemetConfig
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"vpnbot/database"
+)
+
+// Параметры TOTP совместимы с Google Authenticator и аналогами: SHA1, 6 цифр, шаг 30 секунд
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew — сколько соседних шагов принимать из-за расхождения часов
+	totpSkew = 1
+	// RecoveryCodeCount — сколько кодов восстановления выдаётся при включении TOTP
+	RecoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret возвращает новый 160-битный секрет в base32
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// TOTPProvisioningURL — otpauth:// ссылка для QR-кода в приложении-аутентификаторе
+func TOTPProvisioningURL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode считает код для шага step (RFC 4226 с счётчиком = шаг времени)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP возвращает шаг, для которого code верен, или -1
+func matchTOTP(secret, code string, now time.Time) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return -1
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// CheckTOTPCode проверяет код для (ещё не подтверждённого) секрета, без учёта повторов
+func CheckTOTPCode(secret, code string) bool {
+	return matchTOTP(secret, code, time.Now()) >= 0
+}
+
+// VerifyAdminTOTP проверяет код аккаунта и запоминает шаг, чтобы тот же код нельзя было использовать дважды.
+// Шаг сдвигается условным UPDATE: из двух одновременных входов с одним кодом пройдёт только один.
+func VerifyAdminTOTP(account *database.AdminAccount, code string) bool {
+	if account.TOTPSecret == "" {
+		return false
+	}
+	step := matchTOTP(account.TOTPSecret, code, time.Now())
+	if step < 0 {
+		return false
+	}
+	res := database.DB.Model(&database.AdminAccount{}).
+		Where("id = ? AND totp_last_step < ?", account.ID, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		log.Printf("Failed to save TOTP step for %s: %v", account.Username, res.Error)
+		return false
+	}
+	if res.RowsAffected != 1 {
+		return false
+	}
+	account.TOTPLastStep = step
+	return true
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes создаёт новые коды восстановления, сохраняет их хэши
+// и возвращает коды в открытом виде — показать их можно только один раз
+func GenerateRecoveryCodes(account *database.AdminAccount) []string {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		raw := strings.ToLower(hex.EncodeToString(b))
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(raw)
+	}
+	account.RecoveryCodes = strings.Join(hashes, "\n")
+	database.DB.Model(account).Update("recovery_codes", account.RecoveryCodes)
+	return codes
+}
+
+// recoveryCodeAttempts — сколько раз UseRecoveryCode перечитывает коды, если их одновременно сжёг другой вход
+const recoveryCodeAttempts = 3
+
+// UseRecoveryCode проверяет код восстановления и сжигает его. Список кодов перезаписывается,
+// только если он не изменился с момента чтения, поэтому один код нельзя использовать дважды,
+// а одновременное использование двух разных кодов не возвращает сожжённый.
+func UseRecoveryCode(account *database.AdminAccount, code string) bool {
+	hash := hashRecoveryCode(code)
+	for attempt := 0; attempt < recoveryCodeAttempts; attempt++ {
+		current := account.RecoveryCodes
+		hashes := strings.Split(current, "\n")
+		found := -1
+		for i, h := range hashes {
+			if h != "" && subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return false
+		}
+		remaining := strings.Join(append(hashes[:found:found], hashes[found+1:]...), "\n")
+
+		res := database.DB.Model(&database.AdminAccount{}).
+			Where("id = ? AND recovery_codes = ?", account.ID, current).
+			Update("recovery_codes", remaining)
+		if res.Error != nil {
+			log.Printf("Failed to burn recovery code for %s: %v", account.Username, res.Error)
+			return false
+		}
+		if res.RowsAffected == 1 {
+			account.RecoveryCodes = remaining
+			return true
+		}
+
+		// Коды успели поменяться — перечитываем и пробуем снова
+		var fresh database.AdminAccount
+		if err := database.DB.Select("recovery_codes").First(&fresh, account.ID).Error; err != nil {
+			return false
+		}
+		account.RecoveryCodes = fresh.RecoveryCodes
+	}
+	return false
+}
+
+// RemainingRecoveryCodes — сколько кодов восстановления ещё не использовано
+func RemainingRecoveryCodes(account database.AdminAccount) int {
+	if account.RecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(account.RecoveryCodes, "\n"))
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vpnbot/database"
+)
+
+// rfc6238Secret — ключ SHA1 из приложения B RFC 6238 ("12345678901234567890") в base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// testDB открывает чистую БД во временном каталоге
+func testDB(t *testing.T) {
+	t.Helper()
+	database.Init(filepath.Join(t.TempDir(), "test.db"))
+}
+
+func testAdminAccount(t *testing.T) *database.AdminAccount {
+	t.Helper()
+	testDB(t)
+	account := &database.AdminAccount{Username: "admin", Role: "owner", TOTPSecret: rfc6238Secret, TOTPEnabled: true}
+	if err := database.DB.Create(account).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Векторы SHA1 из RFC 6238; у нас 6 цифр — младшие разряды 8-значных кодов
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode(T=%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted an invalid secret")
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := totpCode(rfc6238Secret, current+tt.offset)
+			step := matchTOTP(rfc6238Secret, code, now)
+			if tt.ok && step != current+tt.offset {
+				t.Errorf("matchTOTP = %d, want %d", step, current+tt.offset)
+			}
+			if !tt.ok && step != -1 {
+				t.Errorf("matchTOTP = %d, want -1", step)
+			}
+		})
+	}
+
+	code, _ := totpCode(rfc6238Secret, current)
+	if matchTOTP(rfc6238Secret, code[:3]+" "+code[3:], now) != current {
+		t.Error("code with a space was rejected")
+	}
+	if matchTOTP(rfc6238Secret, code[:5], now) != -1 {
+		t.Error("short code was accepted")
+	}
+}
+
+func TestVerifyAdminTOTPReplay(t *testing.T) {
+	account := testAdminAccount(t)
+	current := time.Now().Unix() / totpPeriod
+
+	previous, _ := totpCode(rfc6238Secret, current-1)
+	code, _ := totpCode(rfc6238Secret, current)
+
+	if !VerifyAdminTOTP(account, code) {
+		t.Fatal("valid code was rejected")
+	}
+	if VerifyAdminTOTP(account, code) {
+		t.Error("the same code was accepted twice")
+	}
+	if VerifyAdminTOTP(account, previous) {
+		t.Error("code of an earlier step was accepted after a later one")
+	}
+
+	// Последний шаг сохранён в БД — повтор не проходит и после перечитывания аккаунта
+	var stored database.AdminAccount
+	database.DB.First(&stored, account.ID)
+	if stored.TOTPLastStep != account.TOTPLastStep {
+		t.Errorf("TOTPLastStep = %d in DB, want %d", stored.TOTPLastStep, account.TOTPLastStep)
+	}
+	if VerifyAdminTOTP(&stored, code) {
+		t.Error("the same code was accepted with a reloaded account")
+	}
+
+	if VerifyAdminTOTP(&database.AdminAccount{}, code) {
+		t.Error("code was accepted for an account without a secret")
+	}
+}
+
+// concurrently запускает fn в n горутинах одновременно и считает, сколько вызовов вернули true
+func concurrently(n int, fn func(i int) bool) int {
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		ok    atomic.Int32
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if fn(i) {
+				ok.Add(1)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return int(ok.Load())
+}
+
+func TestVerifyAdminTOTPConcurrentReplay(t *testing.T) {
+	account := testAdminAccount(t)
+	code, _ := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod)
+
+	// У каждого входа своя копия аккаунта, как после загрузки из БД в разных запросах
+	if n := concurrently(10, func(int) bool {
+		acc := *account
+		return VerifyAdminTOTP(&acc, code)
+	}); n != 1 {
+		t.Errorf("same code accepted %d times concurrently, want 1", n)
+	}
+}
+
+func TestUseRecoveryCodeConcurrent(t *testing.T) {
+	account := testAdminAccount(t)
+	codes := GenerateRecoveryCodes(account)
+
+	if n := concurrently(10, func(int) bool {
+		acc := *account
+		return UseRecoveryCode(&acc, codes[0])
+	}); n != 1 {
+		t.Errorf("same recovery code accepted %d times concurrently, want 1", n)
+	}
+
+	// Разные коды одновременно: оба проходят и ни один не возвращается в список
+	var stored database.AdminAccount
+	database.DB.First(&stored, account.ID)
+	if n := concurrently(2, func(i int) bool {
+		acc := stored
+		return UseRecoveryCode(&acc, codes[1+i])
+	}); n != 2 {
+		t.Errorf("%d of 2 different recovery codes accepted, want 2", n)
+	}
+
+	database.DB.First(&stored, account.ID)
+	if got := RemainingRecoveryCodes(stored); got != RecoveryCodeCount-3 {
+		t.Errorf("remaining = %d, want %d", got, RecoveryCodeCount-3)
+	}
+	for _, code := range codes[:3] {
+		if UseRecoveryCode(&stored, code) {
+			t.Errorf("burned recovery code %s was accepted again", code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	account := testAdminAccount(t)
+	codes := GenerateRecoveryCodes(account)
+	if len(codes) != RecoveryCodeCount || RemainingRecoveryCodes(*account) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, %d remaining", len(codes), RemainingRecoveryCodes(*account))
+	}
+
+	// Регистр, пробелы и дефис не важны
+	if !UseRecoveryCode(account, " "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" ") {
+		t.Fatal("valid recovery code was rejected")
+	}
+	if UseRecoveryCode(account, codes[0]) {
+		t.Error("recovery code was accepted twice")
+	}
+	if RemainingRecoveryCodes(*account) != RecoveryCodeCount-1 {
+		t.Errorf("remaining = %d, want %d", RemainingRecoveryCodes(*account), RecoveryCodeCount-1)
+	}
+
+	var stored database.AdminAccount
+	database.DB.First(&stored, account.ID)
+	if UseRecoveryCode(&stored, codes[0]) {
+		t.Error("burned recovery code was accepted with a reloaded account")
+	}
+	if !UseRecoveryCode(&stored, codes[1]) {
+		t.Error("unused recovery code was rejected")
+	}
+	if UseRecoveryCode(&stored, "") {
+		t.Error("empty recovery code was accepted")
+	}
+
+	// Новая генерация отменяет старые коды
+	GenerateRecoveryCodes(&stored)
+	if UseRecoveryCode(&stored, codes[2]) {
+		t.Error("code from a previous set was accepted")
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+	"vpnbot/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// TrafficDelta — прирост трафика по направлениям
+type TrafficDelta struct {
+	Uplink   int64
+	Downlink int64
+}
+
+func (d TrafficDelta) Total() int64 {
+	return d.Uplink + d.Downlink
+}
+
+// TrafficPoint — одна точка временного ряда для API
+type TrafficPoint struct {
+	BucketStart time.Time `json:"bucket_start"`
+	Uplink      int64     `json:"uplink"`
+	Downlink    int64     `json:"downlink"`
+	Total       int64     `json:"total"`
+}
+
+// trafficRetention возвращает срок хранения почасовых и суточных бакетов.
+// TRAFFIC_HOURLY_RETENTION_DAYS (по умолчанию 7) и TRAFFIC_DAILY_RETENTION_DAYS (по умолчанию 365).
+func trafficRetention() (hourly, daily time.Duration) {
+	hourlyDays, dailyDays := 7, 365
+	if v, err := strconv.Atoi(os.Getenv("TRAFFIC_HOURLY_RETENTION_DAYS")); err == nil && v > 0 {
+		hourlyDays = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("TRAFFIC_DAILY_RETENTION_DAYS")); err == nil && v > 0 {
+		dailyDays = v
+	}
+	return time.Duration(hourlyDays) * 24 * time.Hour, time.Duration(dailyDays) * 24 * time.Hour
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func bucketStart(t time.Time, granularity string) time.Time {
+	if granularity == GranularityDay {
+		return truncateToDay(t)
+	}
+	return t.UTC().Truncate(time.Hour)
+}
+
+// addTrafficSample прибавляет трафик к бакету (создаёт его при отсутствии)
+func addTrafficSample(tx *gorm.DB, userID uint, inboundTag, granularity string, at time.Time, d TrafficDelta) error {
+	sample := database.TrafficSample{
+		UserID:      userID,
+		InboundTag:  inboundTag,
+		Granularity: granularity,
+		BucketStart: bucketStart(at, granularity),
+		Uplink:      d.Uplink,
+		Downlink:    d.Downlink,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "inbound_tag"}, {Name: "granularity"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"uplink":   gorm.Expr("uplink + ?", d.Uplink),
+			"downlink": gorm.Expr("downlink + ?", d.Downlink),
+		}),
+	}).Create(&sample).Error
+}
+
+// RecordUserTraffic пишет прирост трафика юзера в почасовой бакет
+func RecordUserTraffic(tx *gorm.DB, userID uint, at time.Time, d TrafficDelta) error {
+	return addTrafficSample(tx, userID, "", GranularityHour, at, d)
+}
+
+// RecordInboundTraffic пишет прирост трафика инбаунда в почасовой бакет
+func RecordInboundTraffic(inboundTag string, at time.Time, d TrafficDelta) error {
+	return addTrafficSample(database.DB, 0, inboundTag, GranularityHour, at, d)
+}
+
+// StartTrafficMaintenance раз в час сворачивает старые почасовые бакеты в суточные и чистит устаревшие
+func StartTrafficMaintenance() {
+	go func() {
+		RollupTrafficSamples()
+
+		ticker := time.NewTicker(time.Hour)
+		for range ticker.C {
+			RollupTrafficSamples()
+		}
+	}()
+}
+
+// RollupTrafficSamples переносит почасовые бакеты старше срока хранения в суточные
+// и удаляет суточные бакеты старше их срока хранения.
+func RollupTrafficSamples() {
+	hourlyRetention, dailyRetention := trafficRetention()
+	// Сворачиваем только целые сутки, чтобы суточный бакет не собирался из двух прогонов
+	hourlyCutoff := truncateToDay(time.Now().Add(-hourlyRetention))
+	dailyCutoff := truncateToDay(time.Now().Add(-dailyRetention))
+
+	var hourly []database.TrafficSample
+	database.DB.Where("granularity = ? AND bucket_start < ?", GranularityHour, hourlyCutoff).Find(&hourly)
+
+	if len(hourly) > 0 {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			for _, s := range hourly {
+				d := TrafficDelta{Uplink: s.Uplink, Downlink: s.Downlink}
+				if err := addTrafficSample(tx, s.UserID, s.InboundTag, GranularityDay, s.BucketStart, d); err != nil {
+					return err
+				}
+			}
+			return tx.Where("granularity = ? AND bucket_start < ?", GranularityHour, hourlyCutoff).
+				Delete(&database.TrafficSample{}).Error
+		})
+		if err != nil {
+			log.Println("Traffic rollup error:", err)
+			return
+		}
+		log.Printf("Traffic rollup: %d hourly samples merged into daily buckets", len(hourly))
+	}
+
+	database.DB.Where("granularity = ? AND bucket_start < ?", GranularityDay, dailyCutoff).
+		Delete(&database.TrafficSample{})
+}
+
+// GetTrafficSeries возвращает временной ряд трафика юзера (userID > 0) или инбаунда (inboundTag != "").
+// Для granularity=day почасовые бакеты суммируются по суткам; для hour возвращаются только почасовые
+// бакеты, поэтому за пределами срока хранения ряд пуст.
+func GetTrafficSeries(userID uint, inboundTag string, from, to time.Time, granularity string) []TrafficPoint {
+	query := database.DB.Where("user_id = ? AND inbound_tag = ?", userID, inboundTag).
+		Where("bucket_start >= ? AND bucket_start < ?", bucketStart(from, granularity), to.UTC())
+	if granularity == GranularityHour {
+		query = query.Where("granularity = ?", GranularityHour)
+	}
+
+	var samples []database.TrafficSample
+	query.Find(&samples)
+
+	byBucket := map[time.Time]*TrafficPoint{}
+	for _, s := range samples {
+		start := bucketStart(s.BucketStart, granularity)
+		p, ok := byBucket[start]
+		if !ok {
+			p = &TrafficPoint{BucketStart: start}
+			byBucket[start] = p
+		}
+		p.Uplink += s.Uplink
+		p.Downlink += s.Downlink
+		p.Total += s.Uplink + s.Downlink
+	}
+
+	points := make([]TrafficPoint, 0, len(byBucket))
+	for _, p := range byBucket {
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].BucketStart.Before(points[j].BucketStart)
+	})
+	return points
+}
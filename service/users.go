@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"vpnbot/database"
+)
+
+// SetUserStatus вручную меняет статус юзера (active | banned | expired) и перезагружает конфиг.
+// Причина истечения сбрасывается: ручной статус важнее автоматического.
+// При бане отзываются личные ссылки-приглашения юзера.
+func SetUserStatus(user *database.User, status, trigger string) error {
+	switch status {
+	case "active", "banned", "expired":
+	default:
+		return fmt.Errorf("status must be one of active, banned, expired")
+	}
+
+	user.Status = status
+	user.ExpiredReason = ""
+	if err := database.DB.Save(user).Error; err != nil {
+		return err
+	}
+	if status == "banned" {
+		if err := revokeOwnedInviteCodes(user.ID); err != nil {
+			log.Printf("Failed to revoke invite codes of %s: %v", user.Username, err)
+		}
+	}
+	RequestReload(trigger)
+	return nil
+}
+
+// SetUserTrafficLimit задаёт лимит трафика (0 = безлимит). Юзер в expired реактивируется,
+// если лимит больше не исчерпан и подписка не закончилась.
+func SetUserTrafficLimit(user *database.User, limit int64, trigger string) error {
+	if limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+
+	user.TrafficLimit = limit
+	reactivated := false
+	if user.Status == "expired" && (user.TrafficLimit == 0 || user.TrafficUsed < user.TrafficLimit) &&
+		(user.ExpiryDate == nil || user.ExpiryDate.After(time.Now())) {
+		user.Status = "active"
+		user.ExpiredReason = ""
+		reactivated = true
+	}
+
+	if err := database.DB.Save(user).Error; err != nil {
+		return err
+	}
+	if reactivated {
+		RequestReload(trigger)
+	}
+	return nil
+}
@@ -1,24 +1,17 @@
 package service
 
 import (
-	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/url"
-	"os"
 	"os/exec"
 	"strings"
 	"time"
 	"vpnbot/database"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"gorm.io/gorm"
-
-	"github.com/v2fly/v2ray-core/v4/app/stats/command"
 )
 
 const ConfigPath = "/etc/sing-box/config.json"
@@ -223,30 +216,25 @@ func buildSingboxInbound(ib database.InboundConfig, users []database.User) Singb
 	return sb
 }
 
-func GenerateAndReload() error {
-	var users []database.User
-	database.DB.Where("status = ?", "active").Find(&users)
-
-	// Load enabled inbound configs from DB
-	var inbounds []database.InboundConfig
-	database.DB.Where("enabled = ?", true).Order("sort_order").Find(&inbounds)
-
+// buildSingboxConfig собирает серверный конфиг sing-box для набора инбаундов одной ноды.
+// В каждый инбаунд попадают только юзеры, которым он доступен по тарифу.
+func buildSingboxConfig(inbounds []database.InboundConfig, users []database.User, access inboundAccess, apiListen string) SingBoxConfig {
 	singboxInbounds := []SingboxInbound{}
 	inboundTags := []string{}
 	for _, ib := range inbounds {
-		singboxInbounds = append(singboxInbounds, buildSingboxInbound(ib, users))
+		singboxInbounds = append(singboxInbounds, buildSingboxInbound(ib, access.filterUsers(users, ib.Tag)))
 		inboundTags = append(inboundTags, ib.Tag)
 	}
 
-	cfg := SingBoxConfig{
+	return SingBoxConfig{
 		Log: LogConfig{
 			Level:     "info",
 			Timestamp: true,
-			Output:    "/etc/sing-box/access.log",
+			Output:    AccessLogPath,
 		},
 		Experimental: &ExperimentalConfig{
 			V2RayAPI: V2RayAPIConfig{
-				Listen: ApiAddr,
+				Listen: apiListen,
 				Stats: StatsConfig{
 					Enabled:  true,
 					Inbounds: inboundTags,
@@ -260,24 +248,19 @@ func GenerateAndReload() error {
 			{Type: "block", Tag: "block"},
 		},
 	}
+}
 
-	file, _ := json.MarshalIndent(cfg, "", "  ")
-
-	err := os.WriteFile(ConfigPath, file, 0644)
-	if err != nil {
-		log.Println("Error writing config file:", err)
-		fmt.Println(string(file))
-	} else {
-		return ReloadService()
+// inboundServerAddress returns the address clients should connect to for an inbound
+func inboundServerAddress(ib database.InboundConfig, serverAddr string) string {
+	if ib.ServerAddress != "" {
+		return ib.ServerAddress
 	}
-	return nil
+	return serverAddr
 }
 
 // GenerateLinkForInbound generates a subscription link for a given inbound config
 func GenerateLinkForInbound(ib database.InboundConfig, user database.User, serverAddr string) string {
-	if ib.ServerAddress != "" {
-		serverAddr = ib.ServerAddress
-	}
+	serverAddr = inboundServerAddress(ib, serverAddr)
 
 	fingerprint := ib.Fingerprint
 	if fingerprint == "" {
@@ -297,6 +280,14 @@ func GenerateLinkForInbound(ib database.InboundConfig, user database.User, serve
 			if len(ib.RealityShortIDs) > 0 {
 				v.Add("sid", ib.RealityShortIDs[0])
 			}
+		} else if ib.TLSType == "certificate" {
+			v.Add("security", "tls")
+			if ib.SNI != "" {
+				v.Add("sni", ib.SNI)
+			}
+			if clientTLSInsecure(ib) {
+				v.Add("allowInsecure", "1")
+			}
 		}
 
 		if ib.Flow != "" {
@@ -337,7 +328,12 @@ func GenerateLinkForInbound(ib database.InboundConfig, user database.User, serve
 
 	case "hysteria2":
 		v := url.Values{}
-		v.Add("insecure", "1")
+		if ib.SNI != "" {
+			v.Add("sni", ib.SNI)
+		}
+		if clientTLSInsecure(ib) {
+			v.Add("insecure", "1")
+		}
 
 		fragment := url.QueryEscape(ib.DisplayName + "-" + user.Username)
 		return fmt.Sprintf("hysteria2://%s@%s:%d?%s#%s",
@@ -385,54 +381,63 @@ func ValidateRealitySNI(domain string) bool {
 var previousStats = make(map[string]int64)
 
 func UpdateTrafficViaAPI() error {
-	conn, err := grpc.Dial(ApiAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil
-	}
-	defer conn.Close()
-
-	client := command.NewStatsServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	// Запрашиваем всё, но фильтруем в коде
-	resp, err := client.QueryStats(ctx, &command.QueryStatsRequest{
-		Pattern: "",
-		Reset_:  false,
-	})
-	if err != nil {
-		return err
-	}
-
-	userTrafficDelta := make(map[string]int64)
+	userDeltas := make(map[string]TrafficDelta)
+	inboundDeltas := make(map[string]TrafficDelta)
 	currentStats := make(map[string]int64)
 
-	for _, stat := range resp.Stat {
-		parts := strings.Split(stat.Name, ">>>")
-		if len(parts) < 4 {
+	var localErr error
+	for _, node := range NodeClients() {
+		stats, err := node.QueryStats()
+		if err != nil {
+			if node.ID() == 0 {
+				localErr = err
+			} else {
+				log.Printf("Traffic stats from node %s: %v", node.Name(), err)
+			}
 			continue
 		}
 
-		// Фильтр: обрабатываем только статистику пользователей
-		if parts[0] != "user" {
-			continue
-		}
+		for _, stat := range stats {
+			// user>>>name>>>traffic>>>uplink | inbound>>>tag>>>traffic>>>downlink
+			parts := strings.Split(stat.Name, ">>>")
+			if len(parts) < 4 {
+				continue
+			}
 
-		username := parts[1]
-		direction := parts[3]
+			kind := parts[0]
+			if kind != "user" && kind != "inbound" {
+				continue
+			}
 
-		key := fmt.Sprintf("%s_%s", username, direction)
-		currentStats[key] = stat.Value
+			name := parts[1]
+			direction := parts[3]
 
-		prev := previousStats[key]
-		delta := stat.Value - prev
+			// Счётчики разных нод независимы — ключ включает ID ноды
+			key := fmt.Sprintf("%d|%s", node.ID(), stat.Name)
+			currentStats[key] = stat.Value
 
-		if delta < 0 {
-			delta = stat.Value
-		}
+			prev := previousStats[key]
+			delta := stat.Value - prev
 
-		if delta > 0 {
-			userTrafficDelta[username] += delta
+			if delta < 0 {
+				delta = stat.Value
+			}
+
+			if delta <= 0 {
+				continue
+			}
+
+			deltas := userDeltas
+			if kind == "inbound" {
+				deltas = inboundDeltas
+			}
+			d := deltas[name]
+			if direction == "uplink" {
+				d.Uplink += delta
+			} else {
+				d.Downlink += delta
+			}
+			deltas[name] = d
 		}
 	}
 
@@ -440,29 +445,42 @@ func UpdateTrafficViaAPI() error {
 		previousStats[k] = v
 	}
 
-	for username, newBytes := range userTrafficDelta {
-		if newBytes > 0 {
-			err := database.DB.Transaction(func(tx *gorm.DB) error {
-				var count int64
-				tx.Model(&database.User{}).Where("username = ?", username).Count(&count)
-				if count == 0 {
-					return nil
-				}
-
-				return tx.Model(&database.User{}).
-					Where("username = ?", username).
-					Update("traffic_used", gorm.Expr("traffic_used + ?", newBytes)).Error
-			})
-
-			if err != nil {
-				log.Printf("DB Error for %s: %v", username, err)
-			} else {
-				checkLimits(username)
+	now := time.Now()
+
+	for tag, d := range inboundDeltas {
+		if err := RecordInboundTraffic(tag, now, d); err != nil {
+			log.Printf("DB Error for inbound %s: %v", tag, err)
+		}
+	}
+
+	for username, d := range userDeltas {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			var user database.User
+			if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+				return nil
 			}
+
+			if err := RecordUserTraffic(tx, user.ID, now, d); err != nil {
+				return err
+			}
+
+			return tx.Model(&database.User{}).
+				Where("id = ?", user.ID).
+				Updates(map[string]interface{}{
+					"traffic_used":     gorm.Expr("traffic_used + ?", d.Total()),
+					"traffic_uplink":   gorm.Expr("traffic_uplink + ?", d.Uplink),
+					"traffic_downlink": gorm.Expr("traffic_downlink + ?", d.Downlink),
+				}).Error
+		})
+
+		if err != nil {
+			log.Printf("DB Error for %s: %v", username, err)
+		} else {
+			checkLimits(username)
 		}
 	}
 
-	return nil
+	return localErr
 }
 
 func checkLimits(username string) {
@@ -470,11 +488,12 @@ func checkLimits(username string) {
 	if err := database.DB.Where("username = ?", username).First(&user).Error; err == nil {
 		if user.TrafficLimit > 0 && user.TrafficUsed >= user.TrafficLimit {
 			if user.Status == "active" {
-				database.DB.Model(&user).Update("status", "expired")
+				database.DB.Model(&user).Updates(map[string]interface{}{"status": "expired", "expired_reason": "quota"})
 				log.Printf("User %s expired due to traffic limit", username)
-				GenerateAndReload()
-				GenerateAndReloadTelemet()
+				recordLimitHit(user, LimitQuota)
+				RequestReload("quota exceeded: " + username)
 			}
 		}
+		CheckQuotaNotifications(user)
 	}
 }